@@ -4,20 +4,31 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
+	"pro-banana-ai-bot/internal/callback"
 	"pro-banana-ai-bot/internal/config"
+	"pro-banana-ai-bot/internal/credits"
+	"pro-banana-ai-bot/internal/dispatch"
 	"pro-banana-ai-bot/internal/gemini"
 	"pro-banana-ai-bot/internal/handlers"
 	"pro-banana-ai-bot/internal/httpclient"
+	"pro-banana-ai-bot/internal/jobs"
 	"pro-banana-ai-bot/internal/mediagroup"
+	"pro-banana-ai-bot/internal/metrics"
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
 	"pro-banana-ai-bot/internal/session"
+	"pro-banana-ai-bot/internal/storage"
 	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/users"
 )
 
 func main() {
@@ -40,6 +51,11 @@ func main() {
 		HTTPClient: httpClient,
 		Logger:     logger,
 		Debug:      cfg.Debug,
+		Pacing: telegram.PacingOptions{
+			GlobalRate: float64(cfg.TelegramGlobalRate),
+			ChatRate:   1 / cfg.TelegramChatInterval.Seconds(),
+			ChatBurst:  cfg.TelegramChatBurst,
+		},
 	})
 	if err != nil {
 		logger.Error("telegram init failed", "err", err)
@@ -54,36 +70,189 @@ func main() {
 		Logger:     logger,
 	})
 
+	db, err := storage.Open(cfg.StateDBPath)
+	if err != nil {
+		logger.Error("storage init failed", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	previewBackend, err := preview.NewBoltBackend(db)
+	if err != nil {
+		logger.Error("preview storage init failed", "err", err)
+		os.Exit(1)
+	}
+	previewStore := preview.NewStore(preview.StoreOptions{
+		Backend:   previewBackend,
+		TTL:       cfg.PreviewStateTTL,
+		MaxStates: cfg.PreviewStateMax,
+		Logger:    logger,
+	})
+	defer func() {
+		if err := previewStore.Flush(); err != nil {
+			logger.Error("preview state flush failed", "err", err)
+		}
+	}()
+
+	sessionBackend, err := session.NewDiskBackend(db)
+	if err != nil {
+		logger.Error("session storage init failed", "err", err)
+		os.Exit(1)
+	}
+	blobs, err := session.NewBlobStore(cfg.BlobDir)
+	if err != nil {
+		logger.Error("blob storage init failed", "err", err)
+		os.Exit(1)
+	}
 	sessions := session.NewStore(session.Options{
-		MaxMessages: cfg.MaxHistoryMessages,
+		MaxMessages:   cfg.MaxHistoryMessages,
+		Backend:       sessionBackend,
+		Blobs:         blobs,
+		TTL:           cfg.SessionTTL,
+		MaxCacheBytes: int64(cfg.SessionCacheMaxMB) << 20,
+		Logger:        logger,
 	})
 
+	recipes, err := preview.NewRecipes(preview.RecipeOptions{DB: db, TTL: cfg.RecipeTTL, Logger: logger})
+	if err != nil {
+		logger.Error("recipe storage init failed", "err", err)
+		os.Exit(1)
+	}
+	// Button payloads live as long as the result messages that carry them.
+	callbacks, err := callback.NewBoltStore(callback.BoltStoreOptions{DB: db, TTL: cfg.RecipeTTL, Logger: logger})
+	if err != nil {
+		logger.Error("callback storage init failed", "err", err)
+		os.Exit(1)
+	}
+	results, err := session.NewBlobStore(cfg.ResultDir)
+	if err != nil {
+		logger.Error("result storage init failed", "err", err)
+		os.Exit(1)
+	}
+	history, err := preview.NewHistory(preview.HistoryOptions{DB: db, MaxPerUser: cfg.HistoryMax, Logger: logger})
+	if err != nil {
+		logger.Error("history storage init failed", "err", err)
+		os.Exit(1)
+	}
+	presets, err := preview.NewPresets(preview.PresetOptions{DB: db, Logger: logger})
+	if err != nil {
+		logger.Error("preset storage init failed", "err", err)
+		os.Exit(1)
+	}
+
+	userStore, err := users.NewStore(users.Options{DB: db, Logger: logger})
+	if err != nil {
+		logger.Error("user storage init failed", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := userStore.Flush(); err != nil {
+			logger.Error("user flush failed", "err", err)
+		}
+	}()
+
+	accessMode, err := handlers.ParseAccessMode(cfg.AccessMode)
+	if err != nil {
+		logger.Error("access config invalid", "err", err)
+		os.Exit(1)
+	}
+	invites, err := users.NewInvites(db)
+	if err != nil {
+		logger.Error("invite storage init failed", "err", err)
+		os.Exit(1)
+	}
+
+	queue := jobs.New(jobs.Options{
+		Workers:    cfg.GenerationWorkers,
+		MaxPerUser: cfg.MaxQueuedPerUser,
+		MaxQueued:  cfg.GenerationQueueDepth,
+		Timeout:    cfg.RequestTimeout,
+		Logger:     logger,
+	})
+	defer queue.Close()
+
+	limiter, err := newLimiter(cfg)
+	if err != nil {
+		logger.Error("rate limit config invalid", "err", err)
+		os.Exit(1)
+	}
+
+	var ledger *credits.Ledger
+	var packs []credits.Pack
+	if cfg.CreditsEnabled {
+		if ledger, err = credits.NewLedger(db); err != nil {
+			logger.Error("credit ledger init failed", "err", err)
+			os.Exit(1)
+		}
+		if packs, err = credits.ParsePacks(cfg.CreditPacks); err != nil {
+			logger.Error("credit packs invalid", "err", err)
+			os.Exit(1)
+		}
+	}
+
 	handler := handlers.New(handlers.Options{
-		Telegram: tg,
-		Gemini:   gem,
-		Sessions: sessions,
-		Logger:   logger,
+		Telegram:  tg,
+		Gemini:    gem,
+		Sessions:  sessions,
+		Logger:    logger,
+		Preview:   previewStore,
+		Recipes:   recipes,
+		Results:   results,
+		History:   history,
+		Presets:   presets,
+		Callbacks: callbacks,
+		Jobs:      queue,
+		Limiter:   limiter,
+		Users:     userStore,
+		Access: handlers.AccessOptions{
+			Mode:  accessMode,
+			Allow: cfg.AllowedUsers,
+		},
+		Invites: invites,
+
+		Ledger:         ledger,
+		CreditPacks:    packs,
+		WelcomeCredits: cfg.WelcomeCredits,
+		AdminIDs:       cfg.AdminIDs,
+
+		BatchConcurrency: cfg.BatchConcurrency,
+		CutoutPadding:    cfg.CutoutPadding,
 	})
 
+	// ctx ends intake on SIGTERM; work started before that runs on workCtx,
+	// which is only canceled once the drain grace period is over.
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	workCtx, abortWork := context.WithCancel(context.Background())
+	defer abortWork()
 
-	sem := make(chan struct{}, cfg.MaxConcurrent)
+	dispatcher := dispatch.New(dispatch.Options{
+		Lanes: map[dispatch.Lane]dispatch.LaneOptions{
+			dispatch.LaneControl: {Workers: cfg.ControlWorkers, Depth: cfg.ControlQueueDepth},
+			dispatch.LaneChat:    {Workers: cfg.ChatWorkers, Depth: cfg.ChatQueueDepth},
+		},
+		MaxPerKey: cfg.MaxPendingPerUser,
+		Logger:    logger,
+	})
 	onGroupFlush := func(group mediagroup.Group) {
-		select {
-		case sem <- struct{}{}:
-		case <-ctx.Done():
-			return
-		}
-
-		go func() {
-			defer func() { <-sem }()
-
-			reqCtx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
+		err := dispatcher.Submit(dispatch.Key{ChatID: group.ChatID, UserID: group.UserID}, dispatch.LaneChat, func() {
+			reqCtx, cancel := context.WithTimeout(workCtx, cfg.RequestTimeout)
 			defer cancel()
 
 			handler.HandleMediaGroup(reqCtx, group)
-		}()
+		})
+		if err != nil {
+			if err := handler.RejectBusyGroup(workCtx, group); err != nil {
+				logger.Warn("busy reply failed", "err", err)
+			}
+		}
+	}
+
+	go janitorLoop(ctx, cfg.JanitorInterval, sessions, previewStore, userStore, logger)
+	go recipeJanitor(ctx, cfg.RecipeTTL, recipes, callbacks, results, logger)
+
+	if cfg.MetricsAddr != "" {
+		go serveMetrics(ctx, cfg.MetricsAddr, logger)
 	}
 
 	aggregator := mediagroup.New(mediagroup.Options{
@@ -92,44 +261,231 @@ func main() {
 	})
 	handler.SetMediaGroupAggregator(aggregator)
 
-	logger.Info("bot started", "username", tg.Username())
+	updates, err := receiveUpdates(ctx, tg, cfg, logger)
+	if err != nil {
+		logger.Error("receiving updates failed", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("bot started", "username", tg.Username(), "access", accessMode, "webhook", cfg.WebhookURL != "")
+
+	dispatchUpdate := func(update telegram.Update) {
+		run := func() {
+			reqCtx, cancel := context.WithTimeout(workCtx, cfg.RequestTimeout)
+			defer cancel()
 
-	updates := tg.Updates(telegram.UpdatesOptions{
-		Timeout: 30 * time.Second,
-	})
-	defer tg.StopUpdates()
+			if err := handler.HandleUpdate(reqCtx, update); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Error("handle update failed", "err", err)
+			}
+		}
+		var err error
+		switch {
+		case update.CallbackQuery != nil || update.PreCheckoutQuery != nil:
+			// Button presses and payment checks must be answered within
+			// seconds, so they skip the per-user order.
+			err = dispatcher.Go(dispatch.LaneControl, run)
+		case update.Message != nil && update.Message.SuccessfulPayment != nil:
+			// The user has been charged and Telegram will not resend the
+			// payment, so it is never dropped.
+			err = dispatcher.Force(dispatch.LaneControl, run)
+		default:
+			err = dispatcher.Submit(dispatch.KeyOf(update), dispatch.LaneOf(update), run)
+		}
+		if err != nil {
+			if err := handler.RejectBusy(workCtx, update); err != nil {
+				logger.Warn("busy reply failed", "err", err)
+			}
+		}
+	}
 
+loop:
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Info("shutting down")
-			return
+			if cfg.WebhookURL != "" {
+				// Buffered webhook updates were already acknowledged and
+				// Telegram will not resend them; the channel closes once
+				// the server has stopped.
+				n := 0
+				for update := range updates {
+					dispatchUpdate(update)
+					n++
+				}
+				logger.Info("buffered webhook updates handed over", "count", n)
+			}
+			break loop
 		case update, ok := <-updates:
 			if !ok {
 				logger.Info("updates channel closed")
-				return
+				break loop
 			}
+			dispatchUpdate(update)
+		}
+	}
+
+	if cfg.WebhookURL == "" {
+		tg.StopUpdates()
+	}
+	drain(cfg.ShutdownGrace, aggregator, dispatcher, queue, abortWork, logger)
+}
+
+// drain finishes what the bot already accepted: albums still debouncing are
+// processed, running handlers and generation jobs get until grace to finish,
+// and whatever is left is canceled; the queue tells those users.
+func drain(grace time.Duration, aggregator *mediagroup.Aggregator, dispatcher *dispatch.Dispatcher, queue *jobs.Queue, abortWork context.CancelFunc, logger *slog.Logger) {
+	deadline := time.Now().Add(grace)
+	logger.Info("shutting down, draining", "grace", grace)
+
+	if n := aggregator.FlushAll(); n > 0 {
+		logger.Info("media groups flushed", "count", n)
+	}
+	if !dispatcher.Wait(time.Until(deadline)) {
+		logger.Warn("update handlers still running at drain deadline")
+	}
+
+	// Jobs are closures over handler state, so unfinished ones cannot be
+	// persisted yet; their users are notified and refunded instead.
+	unfinished := queue.Shutdown(time.Until(deadline))
+	for _, job := range unfinished {
+		logger.Warn("job aborted by shutdown", "kind", job.Kind, "user_id", job.UserID)
+	}
+
+	abortWork()
+	dispatcher.Wait(5 * time.Second)
+	logger.Info("shutdown complete", "aborted_jobs", len(unfinished))
+}
 
-			select {
-			case sem <- struct{}{}:
-			case <-ctx.Done():
-				return
+// receiveUpdates starts the webhook when WEBHOOK_URL is set and long polling
+// otherwise. A webhook left over from an earlier run is removed first, since
+// Telegram refuses getUpdates while one is set.
+func receiveUpdates(ctx context.Context, tg *telegram.Client, cfg config.Config, logger *slog.Logger) (<-chan telegram.Update, error) {
+	if cfg.WebhookURL != "" {
+		return tg.ListenWebhook(ctx, telegram.WebhookOptions{
+			URL:            cfg.WebhookURL,
+			ListenAddr:     cfg.WebhookListenAddr,
+			SecretToken:    cfg.WebhookSecret,
+			MaxConnections: cfg.WebhookMaxConnections,
+			Buffer:         cfg.MaxConcurrent * 16,
+		})
+	}
+
+	if err := tg.DeleteWebhook(); err != nil {
+		logger.Warn("delete webhook failed", "err", err)
+	}
+	return tg.Updates(telegram.UpdatesOptions{
+		Timeout: 30 * time.Second,
+	}), nil
+}
+
+// janitorLoop expires idle sessions and wizards, keeps the in-memory caches
+// within their caps, saves user activity and, hourly, removes history images
+// nothing references.
+func janitorLoop(ctx context.Context, interval time.Duration, sessions *session.Store, wizards *preview.Store, people *users.Store, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		if expired, evicted := sessions.Sweep(); expired+evicted > 0 {
+			logger.Info("sessions swept", "expired", expired, "evicted", evicted)
+		}
+		if expired, evicted := wizards.Sweep(); expired+evicted > 0 {
+			logger.Info("preview states swept", "expired", expired, "evicted", evicted)
+		}
+
+		if err := people.Flush(); err != nil {
+			logger.Error("user flush failed", "err", err)
+		}
+
+		if time.Since(lastPrune) >= time.Hour {
+			lastPrune = time.Now()
+			if n, err := sessions.PruneBlobs(time.Hour); err != nil {
+				logger.Error("blob prune failed", "err", err)
+			} else if n > 0 {
+				logger.Info("blobs pruned", "count", n)
 			}
+		}
 
-			go func(update telegram.Update) {
-				defer func() { <-sem }()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-				reqCtx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
-				defer cancel()
+// recipeJanitor hourly drops expired recipes, the button payloads and
+// original images kept for them; all live for ttl, so blobs older than that
+// are unreferenced.
+func recipeJanitor(ctx context.Context, ttl time.Duration, recipes *preview.Recipes, callbacks *callback.BoltStore, results *session.BlobStore, logger *slog.Logger) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
 
-				if err := handler.HandleUpdate(reqCtx, update); err != nil && !errors.Is(err, context.Canceled) {
-					logger.Error("handle update failed", "err", err)
-				}
-			}(update)
+	for {
+		if n := recipes.Sweep(); n > 0 {
+			logger.Info("recipes swept", "count", n)
+		}
+		if n := callbacks.Sweep(); n > 0 {
+			logger.Info("callback payloads swept", "count", n)
+		}
+		if n, err := results.Prune(nil, ttl); err != nil {
+			logger.Error("result prune failed", "err", err)
+		} else if n > 0 {
+			logger.Info("results pruned", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
 }
 
+func serveMetrics(ctx context.Context, addr string, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", metrics.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logger.Info("metrics listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("metrics server failed", "err", err)
+	}
+}
+
+func newLimiter(cfg config.Config) (*ratelimit.Limiter, error) {
+	free, err := ratelimit.ParseTierLimits(cfg.LimitsFree, ratelimit.DefaultTierLimits[ratelimit.TierFree])
+	if err != nil {
+		return nil, err
+	}
+	pro, err := ratelimit.ParseTierLimits(cfg.LimitsPro, ratelimit.DefaultTierLimits[ratelimit.TierPro])
+	if err != nil {
+		return nil, err
+	}
+
+	proUsers := make(map[string]bool, len(cfg.ProUserIDs))
+	for _, id := range cfg.ProUserIDs {
+		proUsers[strconv.FormatInt(id, 10)] = true
+	}
+
+	return ratelimit.New(ratelimit.Options{
+		Tiers: map[string]ratelimit.TierLimits{
+			ratelimit.TierFree: free,
+			ratelimit.TierPro:  pro,
+		},
+		Tier: func(key string) string {
+			if proUsers[key] {
+				return ratelimit.TierPro
+			}
+			return ratelimit.TierFree
+		},
+	}), nil
+}
+
 func newLogger(cfg config.Config) *slog.Logger {
 	level := slog.LevelInfo
 	switch cfg.LogLevel {
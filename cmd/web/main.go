@@ -9,6 +9,8 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -16,17 +18,33 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/sync/errgroup"
 
 	"pro-banana-ai-bot/internal/gemini"
 	"pro-banana-ai-bot/internal/httpclient"
+	"pro-banana-ai-bot/internal/imaging"
 	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
 )
 
 //go:embed static/*
 var staticFS embed.FS
 
+const maxBatchItems = 10
+
+// batchUploadTimeout bounds reading a batch upload, which may be far larger
+// than the server's ReadTimeout allows for.
+const batchUploadTimeout = 2 * time.Minute
+
 type server struct {
-	gem *gemini.Client
+	gem              *gemini.Client
+	batchConcurrency int
+
+	limiter *ratelimit.Limiter
+	// apiKeys lists keys accepted in X-API-Key; they get the pro tier and their
+	// own budget, everyone else is limited per client IP.
+	apiKeys    map[string]bool
+	trustProxy bool
 }
 
 type apiError struct {
@@ -38,6 +56,30 @@ type previewResponse struct {
 	Warning string   `json:"warning,omitempty"`
 }
 
+type batchResult struct {
+	Name    string   `json:"name"`
+	Images  []string `json:"images"`
+	Warning string   `json:"warning,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// presetResponse mirrors the preview form fields so the UI can fill them in.
+type presetResponse struct {
+	Mode          string   `json:"mode"`
+	GridPreset    string   `json:"grid_preset"`
+	VerticalCount string   `json:"vertical_count"`
+	AspectRatio   string   `json:"aspect_ratio"`
+	ProductType   string   `json:"product_type"`
+	VisualStyle   string   `json:"visual_style"`
+	HumanUsage    bool     `json:"human_usage"`
+	Custom        string   `json:"custom"`
+	FrameIDs      []string `json:"frame_ids,omitempty"`
+}
+
 func main() {
 	_ = godotenv.Load()
 
@@ -70,10 +112,49 @@ func main() {
 		Logger:     logger,
 	})
 
-	s := &server{gem: gem}
+	batchConcurrency := getEnvInt("BATCH_CONCURRENCY", 2)
+	if batchConcurrency < 1 {
+		batchConcurrency = 1
+	}
+
+	free, err := ratelimit.ParseTierLimits(getEnv("LIMITS_FREE", ""), ratelimit.DefaultTierLimits[ratelimit.TierFree])
+	if err != nil {
+		panic(err)
+	}
+	pro, err := ratelimit.ParseTierLimits(getEnv("LIMITS_PRO", ""), ratelimit.DefaultTierLimits[ratelimit.TierPro])
+	if err != nil {
+		panic(err)
+	}
+	apiKeys := make(map[string]bool)
+	for _, key := range splitCSV(getEnv("WEB_API_KEYS", "")) {
+		apiKeys[key] = true
+	}
+
+	s := &server{
+		gem:              gem,
+		batchConcurrency: batchConcurrency,
+		apiKeys:          apiKeys,
+		trustProxy:       getEnvBool("WEB_TRUST_PROXY", false),
+		limiter: ratelimit.New(ratelimit.Options{
+			Tiers: map[string]ratelimit.TierLimits{
+				ratelimit.TierFree: free,
+				ratelimit.TierPro:  pro,
+			},
+			Tier: func(key string) string {
+				if strings.HasPrefix(key, "key:") {
+					return ratelimit.TierPro
+				}
+				return ratelimit.TierFree
+			},
+		}),
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/preview", s.handlePreview)
+	mux.HandleFunc("/api/preview/batch", s.handlePreviewBatch)
+	mux.HandleFunc("/api/background", s.handleBackground)
+	mux.HandleFunc("/api/edit", s.handleEdit)
+	mux.HandleFunc("/api/preset", handlePreset)
 
 	staticSub, err := fs.Sub(staticFS, "static")
 	if err != nil {
@@ -115,28 +196,344 @@ func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing image"})
 		return
 	}
-	defer file.Close()
+	file.Close()
 
-	imgBytes, err := io.ReadAll(file)
+	img, err := readUpload(header)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read image"})
 		return
 	}
 
-	mimeType := strings.TrimSpace(header.Header.Get("Content-Type"))
-	if strings.Contains(mimeType, ";") {
-		mimeType = strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
+	opts := parsePreviewOptions(r)
+	prompt, out := preview.BuildPrompt(opts)
+	if !s.allow(w, r, ratelimit.BudgetPreview, out.Count) {
+		return
 	}
-	if mimeType == "" || mimeType == "application/octet-stream" {
-		mimeType = http.DetectContentType(imgBytes)
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout())
+	defer cancel()
+
+	resp, err := s.gem.Chat(ctx, nil, prompt, []gemini.ImageInput{img}, gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
 	}
-	if strings.Contains(mimeType, ";") {
-		mimeType = strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
+
+	images := resp.Images
+	if out.Mode == "cutout" {
+		images, err = cutoutImages(images, cutoutPadding(r))
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, apiError{Error: err.Error()})
+			return
+		}
 	}
-	if mimeType == "" || mimeType == "application/octet-stream" {
-		mimeType = "image/jpeg"
+
+	outResp := previewResponse{
+		Images: images,
+	}
+	if len(resp.Images) != out.Count {
+		outResp.Warning = "model returned different image count"
+	}
+
+	writeJSON(w, http.StatusOK, outResp)
+}
+
+// handlePreset decodes a share code from the bot ("?code=...") into form
+// values.
+func handlePreset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	opts, err := preview.DecodeCode(r.URL.Query().Get("code"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid code"})
+		return
+	}
+	writeJSON(w, http.StatusOK, presetResponse{
+		Mode:          opts.Mode,
+		GridPreset:    opts.GridPreset,
+		VerticalCount: opts.VerticalCount,
+		AspectRatio:   opts.AspectRatio,
+		ProductType:   opts.ProductType,
+		VisualStyle:   opts.VisualStyle,
+		HumanUsage:    opts.HumanUsage,
+		Custom:        opts.Custom,
+		FrameIDs:      opts.FrameIDs,
+	})
+}
+
+// handlePreviewBatch runs the preview pipeline once per uploaded "images" file
+// with a shared option set and returns results grouped per product.
+func (s *server) handlePreviewBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	const maxUploadBytes = 100 << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	// The server-wide deadlines fit single previews; a batch gets its own.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(batchUploadTimeout))
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid multipart form"})
+		return
+	}
+
+	headers := r.MultipartForm.File["images"]
+	if len(headers) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing images"})
+		return
+	}
+	if len(headers) > maxBatchItems {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "too many images (max " + strconv.Itoa(maxBatchItems) + ")"})
+		return
+	}
+
+	opts := parsePreviewOptions(r)
+	opts.Bundle = false
+	prompt, out := preview.BuildPrompt(opts)
+	padding := cutoutPadding(r)
+	if !s.allow(w, r, ratelimit.BudgetPreview, out.Count*len(headers)) {
+		return
+	}
+
+	timeout := requestTimeout() * time.Duration(len(headers))
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// Leave time to write the results once the work is done.
+	_ = rc.SetWriteDeadline(time.Now().Add(timeout + time.Minute))
+
+	results := make([]batchResult, len(headers))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(s.batchConcurrency)
+	for i, header := range headers {
+		i := i
+		header := header
+		eg.Go(func() error {
+			results[i].Name = header.Filename
+
+			img, err := readUpload(header)
+			if err != nil {
+				results[i].Error = "failed to read image"
+				return nil
+			}
+
+			resp, err := s.gem.Chat(egCtx, nil, prompt, []gemini.ImageInput{img}, gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio})
+			if err != nil {
+				results[i].Error = err.Error()
+				return nil
+			}
+			results[i].Images = resp.Images
+			if out.Mode == "cutout" {
+				if results[i].Images, err = cutoutImages(resp.Images, padding); err != nil {
+					results[i].Error = err.Error()
+					return nil
+				}
+			}
+			if len(resp.Images) != out.Count {
+				results[i].Warning = "model returned different image count"
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	writeJSON(w, http.StatusOK, batchResponse{Results: results})
+}
+
+// handleBackground replaces only the background of the uploaded photo with a
+// solid colour, gradient or scene; "exact" enforces a solid colour locally.
+func (s *server) handleBackground(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	const maxUploadBytes = 25 << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid multipart form"})
+		return
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing image"})
+		return
+	}
+	file.Close()
+
+	img, err := readUpload(header)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read image"})
+		return
+	}
+
+	bg, err := preview.ParseBackground(r.FormValue("background"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	if parseBool(r.FormValue("exact")) && bg.Kind == "solid" {
+		bg.Exact = true
+	}
+	if !s.allow(w, r, ratelimit.BudgetImage, 1) {
+		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout())
+	defer cancel()
+
+	resp, err := s.gem.Chat(ctx, nil, preview.BuildBackgroundPrompt(bg), []gemini.ImageInput{img}, gemini.ChatOptions{WantImage: true})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	images := resp.Images
+	if bg.Kind == "solid" && bg.Exact {
+		images = make([]string, 0, len(resp.Images))
+		for _, out := range resp.Images {
+			png, err := imaging.RecolorBackgroundDataURL(out, bg.Colors[0], imaging.RecolorOptions{})
+			if err != nil {
+				writeJSON(w, http.StatusBadGateway, apiError{Error: err.Error()})
+				return
+			}
+			images = append(images, png)
+		}
+	}
+
+	outResp := previewResponse{Images: images}
+	if len(images) == 0 {
+		outResp.Warning = "model returned no images"
+	}
+	writeJSON(w, http.StatusOK, outResp)
+}
+
+// handleEdit performs a mask-guided edit: "mask" is a PNG where white marks the
+// editable area. The result is composited back onto the original outside the
+// mask so untouched pixels stay byte-identical.
+func (s *server) handleEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	const maxUploadBytes = 40 << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid multipart form"})
+		return
+	}
+
+	instruction := strings.TrimSpace(r.FormValue("prompt"))
+	if instruction == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing prompt"})
+		return
+	}
+
+	imageFile, imageHeader, err := r.FormFile("image")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing image"})
+		return
+	}
+	imageFile.Close()
+
+	maskFile, maskHeader, err := r.FormFile("mask")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "missing mask"})
+		return
+	}
+	maskFile.Close()
+
+	img, err := readUpload(imageHeader)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read image"})
+		return
+	}
+	mask, err := readUpload(maskHeader)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read mask"})
+		return
+	}
+	if !s.allow(w, r, ratelimit.BudgetImage, 1) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout())
+	defer cancel()
+
+	resp, err := s.gem.Edit(ctx, gemini.EditRequest{Image: img, Mask: mask, Instruction: instruction})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	if len(resp.Images) == 0 {
+		writeJSON(w, http.StatusOK, previewResponse{Warning: "model returned no images"})
+		return
+	}
+
+	originalURL := "data:" + img.MimeType + ";base64," + img.DataBase64
+	maskURL := "data:" + mask.MimeType + ";base64," + mask.DataBase64
+	composited, err := imaging.CompositeMaskedDataURL(originalURL, resp.Images[0], maskURL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, previewResponse{Images: []string{composited}})
+}
+
+// allow applies the shared limiter per API key or client IP and answers 429
+// with Retry-After when the budget is spent.
+func (s *server) allow(w http.ResponseWriter, r *http.Request, budget ratelimit.Budget, images int) bool {
+	d := s.limiter.Allow(s.clientKey(r), budget, images)
+	if d.Allowed {
+		return true
+	}
+
+	retry := time.Until(d.RetryAt)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	msg := "rate limit exceeded, retry in " + retry.Round(time.Second).String()
+	if d.Daily {
+		msg = "daily quota of " + strconv.Itoa(d.Cap) + " images reached, resets at " + d.RetryAt.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusTooManyRequests, apiError{Error: msg})
+	return false
+}
+
+func (s *server) clientKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" && s.apiKeys[key] {
+		return "key:" + key
+	}
+	// The trusted proxy appends the address it saw; anything left of it is
+	// client-supplied and could be forged to dodge the limits.
+	if s.trustProxy {
+		fwd := r.Header.Values("X-Forwarded-For")
+		if len(fwd) > 0 {
+			list := fwd[len(fwd)-1]
+			if i := strings.LastIndex(list, ","); i >= 0 {
+				list = list[i+1:]
+			}
+			if ip := strings.TrimSpace(list); ip != "" {
+				return "ip:" + ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func parsePreviewOptions(r *http.Request) preview.Options {
 	opts := preview.Options{
 		Mode:          strings.TrimSpace(r.FormValue("mode")),
 		GridPreset:    strings.TrimSpace(r.FormValue("grid_preset")),
@@ -156,35 +553,72 @@ func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
 			opts.FrameIDs = splitCSV(raw)
 		}
 	}
+	return opts
+}
 
-	prompt, out := preview.BuildPrompt(opts)
-
-	timeout := time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", 240)) * time.Second
-	if timeout <= 0 {
-		timeout = 240 * time.Second
+func readUpload(header *multipart.FileHeader) (gemini.ImageInput, error) {
+	file, err := header.Open()
+	if err != nil {
+		return gemini.ImageInput{}, err
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
+	defer file.Close()
 
-	resp, err := s.gem.Chat(ctx, nil, prompt, []gemini.ImageInput{
-		{
-			DataBase64: base64.StdEncoding.EncodeToString(imgBytes),
-			MimeType:   mimeType,
-		},
-	}, gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio})
+	imgBytes, err := io.ReadAll(file)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
-		return
+		return gemini.ImageInput{}, err
 	}
 
-	outResp := previewResponse{
-		Images: resp.Images,
+	mimeType := strings.TrimSpace(header.Header.Get("Content-Type"))
+	if strings.Contains(mimeType, ";") {
+		mimeType = strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
 	}
-	if len(resp.Images) != out.Count {
-		outResp.Warning = "model returned different image count"
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(imgBytes)
+	}
+	if strings.Contains(mimeType, ";") {
+		mimeType = strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = "image/jpeg"
 	}
 
-	writeJSON(w, http.StatusOK, outResp)
+	return gemini.ImageInput{
+		DataBase64: base64.StdEncoding.EncodeToString(imgBytes),
+		MimeType:   mimeType,
+	}, nil
+}
+
+// cutoutImages mattes key-background results into transparent PNG data URLs.
+func cutoutImages(images []string, padding int) ([]string, error) {
+	out := make([]string, 0, len(images))
+	for _, img := range images {
+		png, err := imaging.CutoutDataURL(img, imaging.CutoutOptions{Padding: padding})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, png)
+	}
+	return out, nil
+}
+
+// cutoutPadding reads the "padding" form value, falling back to
+// CUTOUT_PADDING; both are clamped to imaging.MaxCutoutPadding.
+func cutoutPadding(r *http.Request) int {
+	padding := getEnvInt("CUTOUT_PADDING", 32)
+	if raw := strings.TrimSpace(r.FormValue("padding")); raw != "" {
+		if v, err := strconv.Atoi(raw); err == nil {
+			padding = v
+		}
+	}
+	return min(max(padding, 0), imaging.MaxCutoutPadding)
+}
+
+func requestTimeout() time.Duration {
+	timeout := time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", 240)) * time.Second
+	if timeout <= 0 {
+		timeout = 240 * time.Second
+	}
+	return timeout
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
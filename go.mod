@@ -5,5 +5,8 @@ go 1.23
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/sync v0.10.0
 )
+
+require golang.org/x/sys v0.4.0 // indirect
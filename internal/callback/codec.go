@@ -0,0 +1,124 @@
+// Package callback packs inline button payloads into Telegram's 64-byte
+// callback_data.
+//
+// Data is encoded as base64url of
+//
+//	[version][action][owner varint]{[arg length uvarint][arg bytes]}...
+//
+// When that does not fit, the bytes are kept in a Store and the button
+// carries only the version byte with the stored flag set plus a random key.
+package callback
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// Version is written into every button; bump it when the layout changes so
+// old buttons are recognised instead of misread.
+const Version = 1
+
+// MaxLen is Telegram's callback_data limit in bytes.
+const MaxLen = 64
+
+// storedFlag marks buttons whose payload lives in the Store.
+const storedFlag = 0x80
+
+const keyLen = 8
+
+var (
+	ErrMalformed      = errors.New("callback: malformed data")
+	ErrUnknownVersion = errors.New("callback: unknown version")
+	ErrExpired        = errors.New("callback: stored payload expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Data is what a button carries: an action code, the user allowed to press
+// it and the action's arguments.
+type Data struct {
+	Action byte
+	Owner  int64
+	Args   []string
+}
+
+// Store keeps payloads too long for callback_data.
+type Store interface {
+	Put(key string, payload []byte)
+	Get(key string) ([]byte, bool)
+}
+
+type Codec struct {
+	store Store
+}
+
+// New returns a codec; store may be nil, in which case oversized data is
+// returned as is and Telegram will reject the button.
+func New(store Store) *Codec {
+	return &Codec{store: store}
+}
+
+func (c *Codec) Encode(d Data) string {
+	raw := make([]byte, 0, 32)
+	raw = append(raw, Version, d.Action)
+	raw = binary.AppendVarint(raw, d.Owner)
+	for _, arg := range d.Args {
+		raw = binary.AppendUvarint(raw, uint64(len(arg)))
+		raw = append(raw, arg...)
+	}
+
+	s := encoding.EncodeToString(raw)
+	if len(s) <= MaxLen || c.store == nil {
+		return s
+	}
+
+	key := make([]byte, keyLen)
+	_, _ = rand.Read(key)
+	c.store.Put(hex.EncodeToString(key), raw)
+	return encoding.EncodeToString(append([]byte{Version | storedFlag}, key...))
+}
+
+func (c *Codec) Decode(s string) (Data, error) {
+	raw, err := encoding.DecodeString(s)
+	if err != nil || len(raw) < 2 {
+		return Data{}, ErrMalformed
+	}
+	if raw[0]&^storedFlag != Version {
+		return Data{}, ErrUnknownVersion
+	}
+
+	if raw[0]&storedFlag != 0 {
+		if len(raw) != 1+keyLen || c.store == nil {
+			return Data{}, ErrExpired
+		}
+		payload, ok := c.store.Get(hex.EncodeToString(raw[1:]))
+		if !ok {
+			return Data{}, ErrExpired
+		}
+		if len(payload) < 2 || payload[0] != Version {
+			return Data{}, ErrMalformed
+		}
+		raw = payload
+	}
+
+	d := Data{Action: raw[1]}
+	rest := raw[2:]
+	owner, n := binary.Varint(rest)
+	if n <= 0 {
+		return Data{}, ErrMalformed
+	}
+	d.Owner = owner
+	rest = rest[n:]
+	for len(rest) > 0 {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return Data{}, ErrMalformed
+		}
+		d.Args = append(d.Args, string(rest[n:n+int(size)]))
+		rest = rest[n+int(size):]
+	}
+	return d, nil
+}
@@ -0,0 +1,132 @@
+package callback
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := New(NewMemoryStore(time.Hour, 10))
+
+	tests := []struct {
+		name   string
+		data   Data
+		stored bool
+	}{
+		{name: "no args", data: Data{Action: 1, Owner: 42}},
+		{name: "negative owner", data: Data{Action: 3, Owner: -1001234567890, Args: []string{"a"}}},
+		{name: "empty arg", data: Data{Action: 5, Owner: 7, Args: []string{"", "x"}}},
+		{name: "large owner", data: Data{Action: 9, Owner: 9_000_000_000, Args: []string{"grid", "3x3"}}},
+		{name: "stored", data: Data{Action: 2, Owner: 42, Args: []string{strings.Repeat("n", 80)}}, stored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := codec.Encode(tt.data)
+			if len(s) > MaxLen {
+				t.Fatalf("encoded length %d > %d", len(s), MaxLen)
+			}
+			raw, _ := base64.RawURLEncoding.DecodeString(s)
+			if got := raw[0]&storedFlag != 0; got != tt.stored {
+				t.Fatalf("stored = %v, want %v", got, tt.stored)
+			}
+
+			got, err := codec.Decode(s)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if got.Action != tt.data.Action || got.Owner != tt.data.Owner || !slices.Equal(got.Args, tt.data.Args) {
+				t.Fatalf("Decode = %+v, want %+v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestCodecDecodeErrors(t *testing.T) {
+	codec := New(NewMemoryStore(time.Hour, 10))
+	enc := func(raw ...byte) string { return base64.RawURLEncoding.EncodeToString(raw) }
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{name: "not base64", data: "pv:1:menu!", want: ErrMalformed},
+		{name: "too short", data: enc(Version), want: ErrMalformed},
+		{name: "future version", data: enc(Version+1, 1, 2), want: ErrUnknownVersion},
+		{name: "future stored version", data: enc((Version+1)|storedFlag, 1, 2, 3, 4, 5, 6, 7, 8), want: ErrUnknownVersion},
+		{name: "missing owner", data: enc(Version, 1), want: ErrMalformed},
+		{name: "arg overruns", data: enc(Version, 1, 2, 5, 'a'), want: ErrMalformed},
+		{name: "unknown stored key", data: enc(Version|storedFlag, 1, 2, 3, 4, 5, 6, 7, 8), want: ErrExpired},
+		{name: "short stored key", data: enc(Version|storedFlag, 1, 2), want: ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Decode(tt.data); !errors.Is(err, tt.want) {
+				t.Fatalf("Decode error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	long := Data{Action: 1, Owner: 1, Args: []string{strings.Repeat("x", 100)}}
+
+	evicting := New(NewMemoryStore(time.Hour, 1))
+	first := evicting.Encode(long)
+	evicting.Encode(long)
+	if _, err := evicting.Decode(first); !errors.Is(err, ErrExpired) {
+		t.Fatalf("evicted payload: err = %v, want ErrExpired", err)
+	}
+
+	expiring := New(NewMemoryStore(time.Nanosecond, 10))
+	s := expiring.Encode(long)
+	time.Sleep(time.Millisecond)
+	if _, err := expiring.Decode(s); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired payload: err = %v, want ErrExpired", err)
+	}
+}
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.db")
+	long := Data{Action: 4, Owner: 99, Args: []string{strings.Repeat("y", 90)}}
+
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewBoltStore(BoltStoreOptions{DB: db, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(store).Encode(long)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err = NewBoltStore(BoltStoreOptions{DB: db, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := New(store).Decode(s)
+	if err != nil {
+		t.Fatalf("Decode after reopen: %v", err)
+	}
+	if got.Owner != long.Owner || !slices.Equal(got.Args, long.Args) {
+		t.Fatalf("Decode = %+v, want %+v", got, long)
+	}
+	if n := store.Sweep(); n != 0 {
+		t.Fatalf("Sweep removed %d live payloads", n)
+	}
+}
@@ -0,0 +1,144 @@
+package callback
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+// MemoryStore keeps payloads for a while in memory; buttons pointing at
+// payloads lost to expiry or a restart decode as ErrExpired.
+type MemoryStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	items map[string]storedPayload
+	order []string
+}
+
+type storedPayload struct {
+	data []byte
+	at   time.Time
+}
+
+// NewMemoryStore keeps up to max payloads for ttl each; the oldest are
+// dropped first.
+func NewMemoryStore(ttl time.Duration, max int) *MemoryStore {
+	if max < 1 {
+		max = 10000
+	}
+	return &MemoryStore{ttl: ttl, max: max, items: make(map[string]storedPayload)}
+}
+
+func (s *MemoryStore) Put(key string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.order) >= s.max {
+		delete(s.items, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.items[key] = storedPayload{data: append([]byte(nil), payload...), at: time.Now()}
+	s.order = append(s.order, key)
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.items[key]
+	if !ok || (s.ttl > 0 && time.Since(p.at) > s.ttl) {
+		return nil, false
+	}
+	return p.data, true
+}
+
+const boltBucket = "callback_payloads"
+
+type BoltStoreOptions struct {
+	DB *storage.DB
+	// TTL forgets payloads older than this; zero keeps them until swept by
+	// hand.
+	TTL    time.Duration
+	Logger *slog.Logger
+}
+
+// BoltStore keeps payloads in the embedded database, so buttons keep working
+// across restarts.
+type BoltStore struct {
+	bucket *storage.Bucket
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+type boltPayload struct {
+	Data []byte
+	At   time.Time
+}
+
+func NewBoltStore(opts BoltStoreOptions) (*BoltStore, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	bucket, err := opts.DB.Bucket(boltBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{bucket: bucket, ttl: opts.TTL, logger: logger}, nil
+}
+
+func (s *BoltStore) Put(key string, payload []byte) {
+	if err := s.bucket.Put(key, boltPayload{Data: payload, At: time.Now().UTC()}); err != nil {
+		s.logger.Error("callback payload save failed", "key", key, "err", err)
+	}
+}
+
+func (s *BoltStore) Get(key string) ([]byte, bool) {
+	var p boltPayload
+	ok, err := s.bucket.Get(key, &p)
+	if err != nil {
+		s.logger.Error("callback payload load failed", "key", key, "err", err)
+	}
+	if !ok || s.expired(p, time.Now()) {
+		return nil, false
+	}
+	return p.Data, true
+}
+
+// Sweep deletes expired payloads.
+func (s *BoltStore) Sweep() (removed int) {
+	if s.ttl <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	var stale []string
+	err := s.bucket.ForEach(func(key string, raw []byte) error {
+		var p boltPayload
+		if err := json.Unmarshal(raw, &p); err != nil || s.expired(p, now) {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("callback payload sweep failed", "err", err)
+		return 0
+	}
+	for _, key := range stale {
+		if err := s.bucket.Delete(key); err != nil {
+			s.logger.Error("callback payload delete failed", "key", key, "err", err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+func (s *BoltStore) expired(p boltPayload, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(p.At) > s.ttl
+}
@@ -3,9 +3,12 @@ package config
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"pro-banana-ai-bot/internal/imaging"
 )
 
 type Config struct {
@@ -19,11 +22,79 @@ type Config struct {
 
 	MediaGroupDebounce time.Duration
 	MaxConcurrent      int
+	MaxPendingPerUser  int
+	GenerationWorkers  int
+	MaxQueuedPerUser   int
+	BatchConcurrency   int
+	CutoutPadding      int
 	MaxHistoryMessages int
 	RequestTimeout     time.Duration
 	HTTPTimeout        time.Duration
 	GeminiBaseURL      string
 	GeminiAPIVersion   string
+
+	// Lanes split update handling so slow work cannot starve button presses:
+	// control (callbacks, commands), chat (model replies) and generation
+	// (the job queue), each with its own concurrency and queue depth.
+	ControlWorkers       int
+	ControlQueueDepth    int
+	ChatWorkers          int
+	ChatQueueDepth       int
+	GenerationQueueDepth int
+
+	// DataDir holds durable bot state (embedded database, blobs).
+	DataDir     string
+	StateDBPath string
+	BlobDir     string
+	// ResultDir keeps original generated images for "Send as files"; they
+	// and the regenerate buttons' recipes are dropped after RecipeTTL.
+	ResultDir string
+	RecipeTTL time.Duration
+	// HistoryMax is how many preview runs /history keeps per user.
+	HistoryMax int
+
+	SessionTTL        time.Duration
+	SessionCacheMaxMB int
+	PreviewStateTTL   time.Duration
+	PreviewStateMax   int
+	// JanitorInterval is how often expired state is swept and wizard state
+	// is written to disk; a crash loses up to this much of wizard edits.
+	JanitorInterval time.Duration
+	MetricsAddr     string
+	// ShutdownGrace is how long in-flight generations may finish after SIGTERM.
+	ShutdownGrace time.Duration
+
+	// LimitsFree/LimitsPro override per-tier budgets, e.g.
+	// "chat=10/m,200/d;image=3/m,20/d;preview=2/m,36/d".
+	LimitsFree string
+	LimitsPro  string
+	ProUserIDs []int64
+
+	// CreditsEnabled charges 1 credit per preview image.
+	CreditsEnabled bool
+	WelcomeCredits int
+	// CreditPacks lists "credits:stars" pairs offered by /buy.
+	CreditPacks string
+	AdminIDs    []int64
+
+	// AccessMode is open, allowlist or invite; AllowedUsers lists IDs and
+	// @usernames served when the bot is not open.
+	AccessMode   string
+	AllowedUsers []string
+
+	// WebhookURL switches the bot from long polling to a webhook served on
+	// WebhookListenAddr behind a reverse proxy.
+	WebhookURL            string
+	WebhookListenAddr     string
+	WebhookSecret         string
+	WebhookMaxConnections int
+
+	// Outbound pacing: TelegramGlobalRate messages per second overall, one
+	// message per TelegramChatInterval into a chat after a burst of
+	// TelegramChatBurst.
+	TelegramGlobalRate   int
+	TelegramChatInterval time.Duration
+	TelegramChatBurst    int
 }
 
 func Load() (Config, error) {
@@ -33,12 +104,53 @@ func Load() (Config, error) {
 		PreferIPv4:         getEnvBool("PREFER_IPV4", true),
 		MediaGroupDebounce: time.Duration(getEnvInt("MEDIA_GROUP_DEBOUNCE_MS", 1200)) * time.Millisecond,
 		MaxConcurrent:      getEnvInt("MAX_CONCURRENT", 4),
+		MaxPendingPerUser:  getEnvInt("MAX_PENDING_PER_USER", 16),
+		GenerationWorkers:  getEnvInt("GENERATION_WORKERS", 2),
+		MaxQueuedPerUser:   getEnvInt("MAX_QUEUED_PER_USER", 3),
+		BatchConcurrency:   getEnvInt("BATCH_CONCURRENCY", 2),
+		CutoutPadding:      getEnvInt("CUTOUT_PADDING", 32),
 		MaxHistoryMessages: getEnvInt("MAX_HISTORY_MESSAGES", 20),
 		RequestTimeout:     time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", 180)) * time.Second,
 		HTTPTimeout:        time.Duration(getEnvInt("HTTP_TIMEOUT_SECONDS", 180)) * time.Second,
 		GeminiBaseURL:      strings.TrimSpace(getEnv("GEMINI_BASE_URL", "https://generativelanguage.googleapis.com")),
 		GeminiAPIVersion:   strings.TrimSpace(getEnv("GEMINI_API_VERSION", "v1beta")),
+		DataDir:            strings.TrimSpace(getEnv("DATA_DIR", "data")),
+		SessionTTL:         getEnvDuration("SESSION_TTL", 7*24*time.Hour),
+		SessionCacheMaxMB:  getEnvInt("SESSION_CACHE_MAX_MB", 64),
+		PreviewStateTTL:    getEnvDuration("PREVIEW_STATE_TTL", 48*time.Hour),
+		PreviewStateMax:    getEnvInt("PREVIEW_STATE_MAX", 10000),
+		JanitorInterval:    time.Duration(getEnvInt("JANITOR_INTERVAL_SECONDS", 60)) * time.Second,
+		MetricsAddr:        strings.TrimSpace(getEnv("METRICS_ADDR", "")),
+		ShutdownGrace:      getEnvDuration("SHUTDOWN_GRACE", 60*time.Second),
+		LimitsFree:         strings.TrimSpace(getEnv("LIMITS_FREE", "")),
+		LimitsPro:          strings.TrimSpace(getEnv("LIMITS_PRO", "")),
+		ProUserIDs:         getEnvInt64List("PRO_USER_IDS"),
+		CreditsEnabled:     getEnvBool("CREDITS_ENABLED", false),
+		WelcomeCredits:     getEnvInt("WELCOME_CREDITS", 9),
+		CreditPacks:        strings.TrimSpace(getEnv("CREDIT_PACKS", "")),
+		AdminIDs:           getEnvInt64List("ADMIN_IDS"),
+		AccessMode:         strings.TrimSpace(getEnv("ACCESS_MODE", "open")),
+		AllowedUsers:       getEnvList("ALLOWED_USERS"),
+
+		WebhookURL:            strings.TrimSpace(getEnv("WEBHOOK_URL", "")),
+		WebhookListenAddr:     strings.TrimSpace(getEnv("WEBHOOK_LISTEN_ADDR", ":8081")),
+		WebhookSecret:         strings.TrimSpace(getEnv("WEBHOOK_SECRET", "")),
+		WebhookMaxConnections: getEnvInt("WEBHOOK_MAX_CONNECTIONS", 40),
+
+		TelegramGlobalRate:   getEnvInt("TELEGRAM_GLOBAL_RATE", 30),
+		TelegramChatInterval: getEnvDuration("TELEGRAM_CHAT_INTERVAL", time.Second),
+		TelegramChatBurst:    getEnvInt("TELEGRAM_CHAT_BURST", 3),
 	}
+	cfg.ControlWorkers = getEnvInt("CONTROL_WORKERS", 8)
+	cfg.ControlQueueDepth = getEnvInt("CONTROL_QUEUE_DEPTH", 256)
+	cfg.ChatWorkers = getEnvInt("CHAT_WORKERS", cfg.MaxConcurrent)
+	cfg.ChatQueueDepth = getEnvInt("CHAT_QUEUE_DEPTH", 128)
+	cfg.GenerationQueueDepth = getEnvInt("GENERATION_QUEUE_DEPTH", 100)
+	cfg.StateDBPath = strings.TrimSpace(getEnv("STATE_DB_PATH", filepath.Join(cfg.DataDir, "bot.db")))
+	cfg.BlobDir = strings.TrimSpace(getEnv("BLOB_DIR", filepath.Join(cfg.DataDir, "blobs")))
+	cfg.ResultDir = strings.TrimSpace(getEnv("RESULT_DIR", filepath.Join(cfg.DataDir, "results")))
+	cfg.RecipeTTL = getEnvDuration("RECIPE_TTL", 72*time.Hour)
+	cfg.HistoryMax = getEnvInt("HISTORY_MAX", 50)
 
 	cfg.TelegramToken = strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
 	cfg.GeminiAPIKey = strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
@@ -48,11 +160,42 @@ func Load() (Config, error) {
 		return Config{}, errors.New("TELEGRAM_BOT_TOKEN is required")
 	case cfg.GeminiAPIKey == "":
 		return Config{}, errors.New("GEMINI_API_KEY is required")
+	case filepath.Clean(cfg.BlobDir) == filepath.Clean(cfg.ResultDir):
+		// Each store prunes files the other still uses.
+		return Config{}, errors.New("BLOB_DIR and RESULT_DIR must be different directories")
 	}
 
 	if cfg.MaxConcurrent < 1 {
 		cfg.MaxConcurrent = 1
 	}
+	if cfg.ControlWorkers < 1 {
+		cfg.ControlWorkers = 1
+	}
+	if cfg.ControlQueueDepth < 1 {
+		cfg.ControlQueueDepth = 1
+	}
+	if cfg.ChatWorkers < 1 {
+		cfg.ChatWorkers = cfg.MaxConcurrent
+	}
+	if cfg.ChatQueueDepth < 1 {
+		cfg.ChatQueueDepth = 1
+	}
+	if cfg.GenerationQueueDepth < 1 {
+		cfg.GenerationQueueDepth = 1
+	}
+	if cfg.MaxPendingPerUser < 1 {
+		cfg.MaxPendingPerUser = 1
+	}
+	if cfg.GenerationWorkers < 1 {
+		cfg.GenerationWorkers = 1
+	}
+	if cfg.MaxQueuedPerUser < 1 {
+		cfg.MaxQueuedPerUser = 1
+	}
+	if cfg.BatchConcurrency < 1 {
+		cfg.BatchConcurrency = 1
+	}
+	cfg.CutoutPadding = min(max(cfg.CutoutPadding, 0), imaging.MaxCutoutPadding)
 	if cfg.MaxHistoryMessages < 1 {
 		cfg.MaxHistoryMessages = 1
 	}
@@ -62,6 +205,33 @@ func Load() (Config, error) {
 	if cfg.HTTPTimeout <= 0 {
 		cfg.HTTPTimeout = 180 * time.Second
 	}
+	if cfg.SessionCacheMaxMB < 0 {
+		cfg.SessionCacheMaxMB = 0
+	}
+	if cfg.PreviewStateMax < 0 {
+		cfg.PreviewStateMax = 0
+	}
+	if cfg.ShutdownGrace < 0 {
+		cfg.ShutdownGrace = 0
+	}
+	if cfg.RecipeTTL <= 0 {
+		cfg.RecipeTTL = 72 * time.Hour
+	}
+	if cfg.HistoryMax < 1 {
+		cfg.HistoryMax = 50
+	}
+	if cfg.JanitorInterval <= 0 {
+		cfg.JanitorInterval = time.Minute
+	}
+	if cfg.TelegramGlobalRate < 1 {
+		cfg.TelegramGlobalRate = 30
+	}
+	if cfg.TelegramChatInterval <= 0 {
+		cfg.TelegramChatInterval = time.Second
+	}
+	if cfg.TelegramChatBurst < 1 {
+		cfg.TelegramChatBurst = 1
+	}
 
 	return cfg, nil
 }
@@ -96,3 +266,45 @@ func getEnvBool(key string, fallback bool) bool {
 	}
 	return parsed
 }
+
+// getEnvDuration accepts Go durations ("48h", "30m") or plain seconds.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvList splits a comma-separated list, dropping empty entries.
+func getEnvList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// getEnvInt64List parses a comma-separated list, skipping invalid entries.
+func getEnvInt64List(key string) []int64 {
+	var out []int64
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if v, err := strconv.ParseInt(part, 10, 64); err == nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
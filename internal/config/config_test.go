@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestLoadDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		blobs   string
+		results string
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "separate", blobs: "/srv/bot/blobs", results: "/srv/bot/results"},
+		{name: "shared", blobs: "/srv/bot/files", results: "/srv/bot/files", wantErr: true},
+		{name: "shared after cleaning", blobs: "data/files/", results: "./data/files", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("GEMINI_API_KEY", "key")
+			t.Setenv("BLOB_DIR", tt.blobs)
+			t.Setenv("RESULT_DIR", tt.results)
+
+			_, err := Load()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
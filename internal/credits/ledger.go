@@ -0,0 +1,203 @@
+package credits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+const (
+	accountsBucket = "credit_accounts"
+	txBucket       = "credit_tx"
+	historyBucket  = "credit_history"
+)
+
+const (
+	KindGrant    = "grant"
+	KindPurchase = "purchase"
+	KindDebit    = "debit"
+	KindRefund   = "refund"
+	KindWelcome  = "welcome"
+)
+
+var (
+	ErrInsufficient = errors.New("credits: insufficient balance")
+	ErrNoDebit      = errors.New("credits: no debit to refund")
+)
+
+type Account struct {
+	UserID    int64
+	Balance   int
+	UpdatedAt time.Time
+}
+
+// Transaction is one balance change. ID doubles as the idempotency key: a
+// second Apply with the same ID returns the stored transaction unchanged.
+type Transaction struct {
+	ID        string
+	UserID    int64
+	Kind      string
+	Amount    int
+	Balance   int
+	Note      string
+	CreatedAt time.Time
+}
+
+// Ledger keeps credit balances and their transaction log in the embedded
+// database; every change updates both in one transaction.
+type Ledger struct {
+	mu sync.Mutex
+	db *storage.DB
+}
+
+func NewLedger(db *storage.DB) (*Ledger, error) {
+	for _, name := range []string{accountsBucket, txBucket, historyBucket} {
+		if _, err := db.Bucket(name); err != nil {
+			return nil, err
+		}
+	}
+	return &Ledger{db: db}, nil
+}
+
+func (l *Ledger) Balance(userID int64) (int, error) {
+	var acc Account
+	err := l.db.View(func(tx *storage.Tx) error {
+		_, err := tx.Get(accountsBucket, accountKey(userID), &acc)
+		return err
+	})
+	return acc.Balance, err
+}
+
+// Credit adds amount to the balance; ref makes it idempotent (payment charge
+// ID, grant ID, ...).
+func (l *Ledger) Credit(userID int64, amount int, kind, ref, note string) (Transaction, error) {
+	if amount <= 0 {
+		return Transaction{}, fmt.Errorf("credits: credit amount must be positive, got %d", amount)
+	}
+	return l.apply(Transaction{ID: kind + ":" + ref, UserID: userID, Kind: kind, Amount: amount, Note: note})
+}
+
+// Credited reports whether Credit already ran for kind and ref, without a
+// write transaction.
+func (l *Ledger) Credited(kind, ref string) (bool, error) {
+	var ok bool
+	err := l.db.View(func(tx *storage.Tx) error {
+		var t Transaction
+		var err error
+		ok, err = tx.Get(txBucket, kind+":"+ref, &t)
+		return err
+	})
+	return ok, err
+}
+
+// Debit charges a generation job. Repeating it for the same jobID is a no-op
+// that returns the original transaction.
+func (l *Ledger) Debit(userID int64, amount int, jobID, note string) (Transaction, error) {
+	if amount <= 0 {
+		return Transaction{}, fmt.Errorf("credits: debit amount must be positive, got %d", amount)
+	}
+	return l.apply(Transaction{ID: KindDebit + ":" + jobID, UserID: userID, Kind: KindDebit, Amount: -amount, Note: note})
+}
+
+// Refund returns up to amount of a job's debit, once per job.
+func (l *Ledger) Refund(userID int64, jobID string, amount int, note string) (Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out Transaction
+	err := l.db.Update(func(tx *storage.Tx) error {
+		var debit Transaction
+		ok, err := tx.Get(txBucket, KindDebit+":"+jobID, &debit)
+		if err != nil {
+			return err
+		}
+		if !ok || debit.UserID != userID {
+			return ErrNoDebit
+		}
+		if amount <= 0 || amount > -debit.Amount {
+			amount = -debit.Amount
+		}
+		out, err = applyTx(tx, Transaction{ID: KindRefund + ":" + jobID, UserID: userID, Kind: KindRefund, Amount: amount, Note: note})
+		return err
+	})
+	return out, err
+}
+
+// History returns the user's latest transactions, newest first.
+func (l *Ledger) History(userID int64, limit int) ([]Transaction, error) {
+	var all []Transaction
+	err := l.db.View(func(tx *storage.Tx) error {
+		return tx.Scan(historyBucket, accountKey(userID)+"/", func(_ string, raw []byte) error {
+			var t Transaction
+			if err := json.Unmarshal(raw, &t); err != nil {
+				return err
+			}
+			all = append(all, t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Transaction, 0, min(limit, len(all)))
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, all[i])
+	}
+	return out, nil
+}
+
+func (l *Ledger) apply(t Transaction) (Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out Transaction
+	err := l.db.Update(func(tx *storage.Tx) error {
+		var err error
+		out, err = applyTx(tx, t)
+		return err
+	})
+	return out, err
+}
+
+func applyTx(tx *storage.Tx, t Transaction) (Transaction, error) {
+	var existing Transaction
+	if ok, err := tx.Get(txBucket, t.ID, &existing); err != nil || ok {
+		return existing, err
+	}
+
+	var acc Account
+	if _, err := tx.Get(accountsBucket, accountKey(t.UserID), &acc); err != nil {
+		return Transaction{}, err
+	}
+	if acc.Balance+t.Amount < 0 {
+		return Transaction{}, ErrInsufficient
+	}
+
+	now := time.Now().UTC()
+	acc.UserID = t.UserID
+	acc.Balance += t.Amount
+	acc.UpdatedAt = now
+	t.Balance = acc.Balance
+	t.CreatedAt = now
+
+	if err := tx.Put(accountsBucket, accountKey(t.UserID), acc); err != nil {
+		return Transaction{}, err
+	}
+	if err := tx.Put(txBucket, t.ID, t); err != nil {
+		return Transaction{}, err
+	}
+	historyKey := fmt.Sprintf("%s/%020d/%s", accountKey(t.UserID), now.UnixNano(), t.ID)
+	if err := tx.Put(historyBucket, historyKey, t); err != nil {
+		return Transaction{}, err
+	}
+	return t, nil
+}
+
+func accountKey(userID int64) string {
+	return fmt.Sprintf("%d", userID)
+}
@@ -0,0 +1,133 @@
+package credits
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	l, err := NewLedger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLedgerIdempotency(t *testing.T) {
+	const user = 7
+
+	tests := []struct {
+		name string
+		op   func(l *Ledger) (Transaction, error)
+		want int
+	}{
+		{name: "credit", op: func(l *Ledger) (Transaction, error) {
+			return l.Credit(user, 5, KindPurchase, "charge-1", "")
+		}, want: 15},
+		{name: "debit", op: func(l *Ledger) (Transaction, error) {
+			return l.Debit(user, 4, "job-1", "")
+		}, want: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			if _, err := l.Credit(user, 10, KindGrant, "start", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := tt.op(l)
+			if err != nil {
+				t.Fatalf("first: %v", err)
+			}
+			again, err := tt.op(l)
+			if err != nil {
+				t.Fatalf("repeat: %v", err)
+			}
+			if again != first {
+				t.Fatalf("repeat returned %+v, want the original %+v", again, first)
+			}
+			if got, _ := l.Balance(user); got != tt.want {
+				t.Fatalf("balance = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedgerCredited(t *testing.T) {
+	l := newTestLedger(t)
+	if ok, err := l.Credited(KindWelcome, "7"); err != nil || ok {
+		t.Fatalf("Credited before grant = %v, %v", ok, err)
+	}
+	if _, err := l.Credit(7, 3, KindWelcome, "7", "welcome"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := l.Credited(KindWelcome, "7"); err != nil || !ok {
+		t.Fatalf("Credited after grant = %v, %v", ok, err)
+	}
+}
+
+func TestLedgerRefund(t *testing.T) {
+	const user = 7
+
+	tests := []struct {
+		name    string
+		refund  int
+		user    int64
+		job     string
+		want    int
+		wantErr error
+	}{
+		{name: "full", refund: 0, user: user, job: "job-1", want: 10},
+		{name: "partial", refund: 2, user: user, job: "job-1", want: 8},
+		{name: "capped at debit", refund: 50, user: user, job: "job-1", want: 10},
+		{name: "unknown job", refund: 1, user: user, job: "job-2", want: 6, wantErr: ErrNoDebit},
+		{name: "other user", refund: 1, user: 8, job: "job-1", want: 6, wantErr: ErrNoDebit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			if _, err := l.Credit(user, 10, KindGrant, "start", ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Debit(user, 4, "job-1", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := l.Refund(tt.user, tt.job, tt.refund, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refund error = %v, want %v", err, tt.wantErr)
+			}
+			// A second refund of the same job changes nothing.
+			_, _ = l.Refund(tt.user, tt.job, tt.refund, "")
+			if got, _ := l.Balance(user); got != tt.want {
+				t.Fatalf("balance = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedgerInsufficient(t *testing.T) {
+	l := newTestLedger(t)
+	if _, err := l.Credit(7, 2, KindGrant, "start", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Debit(7, 3, "job-1", ""); !errors.Is(err, ErrInsufficient) {
+		t.Fatalf("Debit error = %v, want ErrInsufficient", err)
+	}
+	if got, _ := l.Balance(7); got != 2 {
+		t.Fatalf("balance = %d, want 2", got)
+	}
+	hist, err := l.History(7, 10)
+	if err != nil || len(hist) != 1 {
+		t.Fatalf("History = %d entries, %v; want only the grant", len(hist), err)
+	}
+}
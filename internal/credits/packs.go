@@ -0,0 +1,47 @@
+package credits
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pack is a purchasable bundle of credits priced in Telegram Stars.
+type Pack struct {
+	Credits int
+	Stars   int
+}
+
+// DefaultPacks is used when CREDIT_PACKS is empty.
+var DefaultPacks = []Pack{
+	{Credits: 30, Stars: 25},
+	{Credits: 100, Stars: 75},
+	{Credits: 300, Stars: 200},
+}
+
+// ParsePacks reads "credits:stars" pairs separated by commas, e.g. "30:25,100:75".
+func ParsePacks(spec string) ([]Pack, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return append([]Pack(nil), DefaultPacks...), nil
+	}
+
+	var packs []Pack
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		c, s, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("credit pack %q: want credits:stars", part)
+		}
+		creditsN, err1 := strconv.Atoi(strings.TrimSpace(c))
+		stars, err2 := strconv.Atoi(strings.TrimSpace(s))
+		if err1 != nil || err2 != nil || creditsN <= 0 || stars <= 0 {
+			return nil, fmt.Errorf("credit pack %q: want positive numbers", part)
+		}
+		packs = append(packs, Pack{Credits: creditsN, Stars: stars})
+	}
+	return packs, nil
+}
@@ -0,0 +1,305 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/metrics"
+	"pro-banana-ai-bot/internal/telegram"
+)
+
+var (
+	ErrKeyQueueFull = errors.New("dispatch: too many pending updates for key")
+	ErrLaneFull     = errors.New("dispatch: lane queue is full")
+)
+
+var droppedCount = metrics.NewCounter("dispatch_dropped")
+
+// Lane is a class of work with its own concurrency limit, so slow chat
+// replies cannot hold up button presses.
+type Lane string
+
+const (
+	// LaneControl carries callbacks, commands and payments.
+	LaneControl Lane = "control"
+	// LaneChat carries text and photo messages answered by the model.
+	LaneChat Lane = "chat"
+)
+
+type LaneOptions struct {
+	// Workers bounds the lane's tasks running at once.
+	Workers int
+	// Depth bounds the lane's tasks waiting to run; beyond it work is dropped.
+	Depth int
+}
+
+// DefaultLanes is used for lanes missing from Options.Lanes.
+var DefaultLanes = map[Lane]LaneOptions{
+	LaneControl: {Workers: 8, Depth: 256},
+	LaneChat:    {Workers: 4, Depth: 128},
+}
+
+// saturationLogEvery rate-limits the "lane saturated" warning per lane.
+const saturationLogEvery = 30 * time.Second
+
+// Key identifies one conversation; updates with the same key run in order.
+type Key struct {
+	ChatID int64
+	UserID int64
+}
+
+// KeyOf returns the (chat, user) an update belongs to; either part is zero
+// when Telegram does not send it (e.g. pre-checkout queries have no chat).
+func KeyOf(update telegram.Update) Key {
+	var k Key
+	if chat := update.FromChat(); chat != nil {
+		k.ChatID = chat.ID
+	}
+	if from := update.SentFrom(); from != nil {
+		k.UserID = from.ID
+	}
+	return k
+}
+
+// LaneOf sorts an update into the lane that should run it.
+func LaneOf(update telegram.Update) Lane {
+	msg := update.Message
+	if msg == nil || msg.IsCommand() || msg.SuccessfulPayment != nil {
+		return LaneControl
+	}
+	return LaneChat
+}
+
+type Options struct {
+	Lanes map[Lane]LaneOptions
+	// MaxPerKey bounds tasks waiting behind a key's running one.
+	MaxPerKey int
+	Logger    *slog.Logger
+}
+
+// Dispatcher runs tasks one at a time per key and in parallel across keys,
+// so a user's second message sees the history written by the first. Each
+// task also takes a slot in its lane while it runs.
+type Dispatcher struct {
+	mu        sync.Mutex
+	lanes     map[Lane]*lane
+	maxPerKey int
+	logger    *slog.Logger
+
+	queues map[Key][]task
+	wg     sync.WaitGroup
+}
+
+type task struct {
+	lane *lane
+	fn   func()
+}
+
+type lane struct {
+	name  Lane
+	sem   chan struct{}
+	depth int
+
+	waiting   int // guarded by Dispatcher.mu
+	lastWarn  time.Time
+	running   *metrics.Gauge
+	queued    *metrics.Gauge
+	saturated *metrics.Counter
+}
+
+func New(opts Options) *Dispatcher {
+	maxPerKey := opts.MaxPerKey
+	if maxPerKey < 1 {
+		maxPerKey = 16
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	lanes := make(map[Lane]*lane)
+	for name, def := range DefaultLanes {
+		lo, ok := opts.Lanes[name]
+		if !ok {
+			lo = def
+		}
+		if lo.Workers < 1 {
+			lo.Workers = 1
+		}
+		if lo.Depth < 1 {
+			lo.Depth = def.Depth
+		}
+		lanes[name] = &lane{
+			name:      name,
+			sem:       make(chan struct{}, lo.Workers),
+			depth:     lo.Depth,
+			running:   metrics.NewGauge(fmt.Sprintf("lane_%s_running", name)),
+			queued:    metrics.NewGauge(fmt.Sprintf("lane_%s_queued", name)),
+			saturated: metrics.NewCounter(fmt.Sprintf("lane_%s_saturated", name)),
+		}
+	}
+
+	return &Dispatcher{
+		lanes:     lanes,
+		maxPerKey: maxPerKey,
+		logger:    logger,
+		queues:    make(map[Key][]task),
+	}
+}
+
+// Submit queues fn behind the key's earlier tasks. It never blocks; fn is
+// dropped when the key already has MaxPerKey tasks waiting or the lane is
+// at its depth.
+func (d *Dispatcher) Submit(key Key, name Lane, fn func()) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, err := d.reserveLocked(name, false)
+	if err != nil {
+		return err
+	}
+	queue, active := d.queues[key]
+	// The head of the queue is the running task.
+	if active && len(queue) > d.maxPerKey {
+		d.releaseLocked(l)
+		droppedCount.Inc()
+		d.logger.Warn("update dropped, key queue full", "chat_id", key.ChatID, "user_id", key.UserID)
+		return ErrKeyQueueFull
+	}
+	d.queues[key] = append(queue, task{lane: l, fn: fn})
+	if !active {
+		d.wg.Add(1)
+		go d.drainKey(key)
+	}
+	return nil
+}
+
+// Go runs fn outside any key's order, still within its lane's limits. It is
+// meant for callback queries, which must be answered promptly.
+func (d *Dispatcher) Go(name Lane, fn func()) error {
+	return d.goLane(name, false, fn)
+}
+
+// Force is Go for work that must not be lost, such as payments Telegram
+// will not deliver again: the lane's depth does not apply, only its
+// concurrency limit.
+func (d *Dispatcher) Force(name Lane, fn func()) error {
+	return d.goLane(name, true, fn)
+}
+
+func (d *Dispatcher) goLane(name Lane, force bool, fn func()) error {
+	d.mu.Lock()
+	l, err := d.reserveLocked(name, force)
+	if err == nil {
+		d.wg.Add(1)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer d.wg.Done()
+		d.run(task{lane: l, fn: fn})
+	}()
+	return nil
+}
+
+// Wait blocks until every submitted task has finished or timeout passes,
+// and reports whether everything finished.
+func (d *Dispatcher) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(max(timeout, 0))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (d *Dispatcher) drainKey(key Key) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		t := d.queues[key][0]
+		d.mu.Unlock()
+
+		d.run(t)
+
+		d.mu.Lock()
+		rest := d.queues[key][1:]
+		if len(rest) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		d.queues[key] = rest
+		d.mu.Unlock()
+	}
+}
+
+// run waits for a lane slot and runs the task, reporting when the lane had
+// no free slot.
+func (d *Dispatcher) run(t task) {
+	l := t.lane
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		d.saturated(l)
+		l.sem <- struct{}{}
+	}
+
+	d.mu.Lock()
+	d.releaseLocked(l)
+	l.running.Set(int64(len(l.sem)))
+	d.mu.Unlock()
+
+	defer func() {
+		<-l.sem
+		l.running.Set(int64(len(l.sem)))
+	}()
+	t.fn()
+}
+
+func (d *Dispatcher) reserveLocked(name Lane, force bool) (*lane, error) {
+	l, ok := d.lanes[name]
+	if !ok {
+		return nil, fmt.Errorf("dispatch: unknown lane %q", name)
+	}
+	if !force && l.waiting >= l.depth {
+		droppedCount.Inc()
+		d.logger.Warn("update dropped, lane full", "lane", name, "depth", l.depth)
+		return nil, ErrLaneFull
+	}
+	l.waiting++
+	l.queued.Set(int64(l.waiting))
+	return l, nil
+}
+
+func (d *Dispatcher) releaseLocked(l *lane) {
+	l.waiting--
+	l.queued.Set(int64(l.waiting))
+}
+
+func (d *Dispatcher) saturated(l *lane) {
+	l.saturated.Inc()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(l.lastWarn) < saturationLogEvery {
+		return
+	}
+	l.lastWarn = time.Now()
+	d.logger.Warn("lane saturated", "lane", l.name, "workers", cap(l.sem), "waiting", l.waiting)
+}
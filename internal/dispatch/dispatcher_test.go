@@ -0,0 +1,49 @@
+package dispatch
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestForceIgnoresLaneDepth(t *testing.T) {
+	d := New(Options{Lanes: map[Lane]LaneOptions{LaneControl: {Workers: 1, Depth: 1}}})
+	release := make(chan struct{})
+	if err := d.Go(LaneControl, func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first task to take the worker so the next one waits.
+	for deadline := time.Now().Add(time.Second); ; {
+		d.mu.Lock()
+		waiting := d.lanes[LaneControl].waiting
+		d.mu.Unlock()
+		if waiting == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first task never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Go(LaneControl, func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Go(LaneControl, func() {}); !errors.Is(err, ErrLaneFull) {
+		t.Fatalf("Go on a full lane = %v, want ErrLaneFull", err)
+	}
+	ran := make(chan struct{})
+	if err := d.Force(LaneControl, func() { close(ran) }); err != nil {
+		t.Fatalf("Force on a full lane = %v", err)
+	}
+
+	close(release)
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("forced task did not run")
+	}
+	if !d.Wait(time.Second) {
+		t.Fatal("tasks did not finish")
+	}
+}
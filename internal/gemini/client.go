@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+
+	"pro-banana-ai-bot/internal/metrics"
 )
 
 const (
@@ -25,6 +27,30 @@ Vazifalaringiz:
 2. Rasmlarni tahlil qilish va tahrirlash.
 3. Murakkab savollarga aniq javob berish.`
 
+var (
+	requestCount = metrics.NewCounter("gemini_requests")
+	failureCount = metrics.NewCounter("gemini_failures")
+	promptTokens = metrics.NewCounter("gemini_prompt_tokens")
+	outputTokens = metrics.NewCounter("gemini_output_tokens")
+)
+
+// Stats are process-lifetime API counters.
+type Stats struct {
+	Requests     int64
+	Failures     int64
+	PromptTokens int64
+	OutputTokens int64
+}
+
+func ReadStats() Stats {
+	return Stats{
+		Requests:     requestCount.Value(),
+		Failures:     failureCount.Value(),
+		PromptTokens: promptTokens.Value(),
+		OutputTokens: outputTokens.Value(),
+	}
+}
+
 type Options struct {
 	APIKey     string
 	BaseURL    string
@@ -36,6 +62,9 @@ type Options struct {
 type ChatOptions struct {
 	WantImage   bool
 	AspectRatio string // e.g. "1:1", "3:4", "9:16"
+	// ImageLabels, when set, labels each attached image in order instead of the
+	// default reference/target roles (e.g. bundle shots where every image is a product).
+	ImageLabels []string
 }
 
 type Client struct {
@@ -151,6 +180,56 @@ func (c *Client) GenerateImage(ctx context.Context, prompt string) ([]string, er
 	return resp.Images, nil
 }
 
+// Edit sends the image, its mask and an instruction to the image model. The
+// model output is not trusted outside the mask; callers composite it back onto
+// the original (see imaging.CompositeMasked).
+func (c *Client) Edit(ctx context.Context, req EditRequest) (Response, error) {
+	instruction := strings.TrimSpace(req.Instruction)
+	if instruction == "" {
+		return Response{}, errors.New("instruction is empty")
+	}
+
+	promptText := "TASK: Masked image edit (inpainting).\n" +
+		"Instruction: " + instruction + "\n\n" +
+		"Rules:\n" +
+		"- Image #1 is the original photo. Image #2 is the mask.\n" +
+		"- White area of the mask = the ONLY region you may change. Black area = must stay pixel-identical.\n" +
+		"- Blend the edit seamlessly with the surrounding lighting, texture and perspective.\n" +
+		"- Keep the same framing, size and aspect ratio as the original.\n" +
+		"- Do not add text, logos or watermarks.\n" +
+		"- Natijani faqat rasm (inlineData) ko'rinishida qaytaring. Matn/JSON/kod yozmang."
+
+	cfg := generationConfig{
+		Temperature:        0.2,
+		ResponseModalities: []string{"IMAGE"},
+	}
+	if ar := strings.TrimSpace(req.AspectRatio); ar != "" {
+		cfg.ImageConfig = &imageConfig{AspectRatio: ar}
+	}
+
+	payload := generateContentRequest{
+		Contents: []content{{
+			Role: "user",
+			Parts: []part{
+				{Text: promptText},
+				{Text: "Rasm #1 (original):"},
+				{InlineData: &blob{Data: stripDataURLPrefix(req.Image.DataBase64), MimeType: req.Image.MimeType}},
+				{Text: "Rasm #2 (mask, white = editable):"},
+				{InlineData: &blob{Data: stripDataURLPrefix(req.Mask.DataBase64), MimeType: req.Mask.MimeType}},
+			},
+		}},
+		SystemInstruction: &content{Role: "user", Parts: []part{{Text: systemInstruction}}},
+		GenerationConfig:  cfg,
+	}
+
+	resp, err := c.generateContent(ctx, modelImage, payload)
+	if err != nil && cfg.ImageConfig != nil && isUnknownFieldError(err, "imageConfig") {
+		payload.GenerationConfig.ImageConfig = nil
+		resp, err = c.generateContent(ctx, modelImage, payload)
+	}
+	return resp, err
+}
+
 func buildContents(history []Message, currentPrompt string, images []ImageInput, opts ChatOptions) []content {
 	var contents []content
 
@@ -178,7 +257,23 @@ func buildContents(history []Message, currentPrompt string, images []ImageInput,
 	}
 
 	var currentParts []part
-	if len(images) <= 1 {
+	if len(opts.ImageLabels) > 0 && len(images) > 0 {
+		currentParts = []part{{Text: promptText}}
+		for i, img := range images {
+			label := fmt.Sprintf("Rasm #%d:", i+1)
+			if i < len(opts.ImageLabels) && strings.TrimSpace(opts.ImageLabels[i]) != "" {
+				label = opts.ImageLabels[i]
+			}
+
+			currentParts = append(currentParts,
+				part{Text: label},
+				part{InlineData: &blob{
+					Data:     stripDataURLPrefix(img.DataBase64),
+					MimeType: img.MimeType,
+				}},
+			)
+		}
+	} else if len(images) <= 1 {
 		if len(images) == 1 {
 			promptText += "\n\nRasm: target/edit (asosiy mahsulotni aynan saqlang; faqat fon/yoritish/kompozitsiyani o'zgartiring)."
 		}
@@ -240,8 +335,10 @@ func (c *Client) generateContent(ctx context.Context, model string, payload gene
 	httpReq.Header.Set("content-type", "application/json")
 	httpReq.Header.Set("x-goog-api-key", c.apiKey)
 
+	requestCount.Inc()
 	httpResp, err := c.httpClient.Do(httpReq)
 	if err != nil {
+		failureCount.Inc()
 		return Response{}, fmt.Errorf("request: %w", err)
 	}
 	defer httpResp.Body.Close()
@@ -252,13 +349,17 @@ func (c *Client) generateContent(ctx context.Context, model string, payload gene
 	}
 
 	if httpResp.StatusCode >= 400 {
+		failureCount.Inc()
 		return Response{}, fmt.Errorf("gemini API %s: %s", httpResp.Status, strings.TrimSpace(string(rawBody)))
 	}
 
 	var decoded generateContentResponse
 	if err := json.Unmarshal(rawBody, &decoded); err != nil {
+		failureCount.Inc()
 		return Response{}, fmt.Errorf("decode response: %w", err)
 	}
+	promptTokens.Add(int64(decoded.UsageMetadata.PromptTokens))
+	outputTokens.Add(int64(decoded.UsageMetadata.OutputTokens))
 
 	text, images := extractParts(decoded)
 	if strings.TrimSpace(text) == "" && len(images) > 0 {
@@ -271,6 +372,7 @@ func (c *Client) generateContent(ctx context.Context, model string, payload gene
 	return Response{
 		Text:   text,
 		Images: images,
+		Usage:  decoded.UsageMetadata,
 	}, nil
 }
 
@@ -331,7 +433,8 @@ type blob struct {
 }
 
 type generateContentResponse struct {
-	Candidates []candidate `json:"candidates"`
+	Candidates    []candidate `json:"candidates"`
+	UsageMetadata Usage       `json:"usageMetadata"`
 }
 
 type candidate struct {
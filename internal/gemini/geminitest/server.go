@@ -0,0 +1,218 @@
+// Package geminitest runs a local fake of the Gemini generateContent API.
+// Point a real gemini.Client at it with NewClient, script the replies with
+// SetImages and Fail, then inspect what was asked with Requests.
+package geminitest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"pro-banana-ai-bot/internal/gemini"
+)
+
+// APIKey is accepted by the server; any other key gets 403.
+const APIKey = "test-key"
+
+// Request is one generateContent call as the server received it.
+type Request struct {
+	Model string
+	// Prompt joins the text parts of the last content.
+	Prompt string
+	// Images counts the inline images of the last content.
+	Images      int
+	AspectRatio string
+	WantImage   bool
+}
+
+// Failure is an error the server returns instead of a reply.
+type Failure struct {
+	Code    int
+	Message string
+}
+
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+	failures []Failure
+	images   int
+	text     string
+}
+
+// NewServer starts the fake; it answers every call with one image until
+// told otherwise. Close it when done.
+func NewServer() *Server {
+	s := &Server{images: 1}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// NewClient returns a gemini.Client talking to this server.
+func (s *Server) NewClient() *gemini.Client {
+	return gemini.New(gemini.Options{
+		APIKey:     APIKey,
+		BaseURL:    s.srv.URL,
+		HTTPClient: s.srv.Client(),
+	})
+}
+
+// SetImages makes later replies carry n images (PNG) and text; n == 0 makes
+// a text-only reply.
+func (s *Server) SetImages(n int, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images, s.text = n, text
+}
+
+// Fail makes the next call fail with f; calls fail once per Fail.
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, f)
+}
+
+// Requests returns the recorded calls.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset forgets recorded calls and pending failures and goes back to
+// one-image replies.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests, s.failures = nil, nil
+	s.images, s.text = 1, ""
+}
+
+// PNG is a small opaque image, usable as a product photo and returned as
+// every generated image.
+func PNG() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+type generateRequest struct {
+	Contents []struct {
+		Parts []struct {
+			Text       string `json:"text"`
+			InlineData *struct {
+				Data string `json:"data"`
+			} `json:"inlineData"`
+		} `json:"parts"`
+	} `json:"contents"`
+	GenerationConfig struct {
+		ResponseModalities []string `json:"responseModalities"`
+		ImageConfig        *struct {
+			AspectRatio string `json:"aspectRatio"`
+		} `json:"imageConfig"`
+	} `json:"generationConfig"`
+}
+
+type part struct {
+	Text       string `json:"text,omitempty"`
+	InlineData *blob  `json:"inlineData,omitempty"`
+}
+
+type blob struct {
+	Data     string `json:"data"`
+	MimeType string `json:"mimeType"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-goog-api-key") != APIKey {
+		writeError(w, Failure{Code: http.StatusForbidden, Message: "API key not valid"})
+		return
+	}
+	rest, ok := strings.CutSuffix(r.URL.Path, ":generateContent")
+	if !ok || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body generateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, Failure{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	req := Request{Model: rest[strings.LastIndexByte(rest, '/')+1:]}
+	if n := len(body.Contents); n > 0 {
+		var texts []string
+		for _, p := range body.Contents[n-1].Parts {
+			if p.Text != "" {
+				texts = append(texts, p.Text)
+			}
+			if p.InlineData != nil {
+				req.Images++
+			}
+		}
+		req.Prompt = strings.Join(texts, "\n")
+	}
+	if cfg := body.GenerationConfig; cfg.ImageConfig != nil {
+		req.AspectRatio = cfg.ImageConfig.AspectRatio
+	}
+	for _, m := range body.GenerationConfig.ResponseModalities {
+		req.WantImage = req.WantImage || m == "IMAGE"
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		s.mu.Unlock()
+		writeError(w, f)
+		return
+	}
+	images, text := s.images, s.text
+	s.mu.Unlock()
+
+	var parts []part
+	if text != "" {
+		parts = append(parts, part{Text: text})
+	}
+	data := base64.StdEncoding.EncodeToString(PNG())
+	for range images {
+		parts = append(parts, part{InlineData: &blob{Data: data, MimeType: "image/png"}})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"candidates":    []any{map[string]any{"content": map[string]any{"role": "model", "parts": parts}}},
+		"usageMetadata": map[string]int{"promptTokenCount": 10, "candidatesTokenCount": 10 * images, "totalTokenCount": 10 + 10*images},
+	})
+}
+
+func writeError(w http.ResponseWriter, f Failure) {
+	writeJSON(w, f.Code, map[string]any{"error": map[string]any{"code": f.Code, "message": f.Message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
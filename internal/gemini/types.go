@@ -14,4 +14,20 @@ type ImageInput struct {
 type Response struct {
 	Text   string
 	Images []string
+	Usage  Usage
+}
+
+// Usage is the token accounting reported by the API for one call.
+type Usage struct {
+	PromptTokens int `json:"promptTokenCount"`
+	OutputTokens int `json:"candidatesTokenCount"`
+	TotalTokens  int `json:"totalTokenCount"`
+}
+
+// EditRequest is a mask-guided edit: only the white area of Mask may change.
+type EditRequest struct {
+	Image       ImageInput
+	Mask        ImageInput
+	Instruction string
+	AspectRatio string
 }
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/users"
+)
+
+type AccessMode string
+
+const (
+	// AccessOpen serves everyone.
+	AccessOpen AccessMode = "open"
+	// AccessAllowlist serves admins, listed users and earlier invitees.
+	AccessAllowlist AccessMode = "allowlist"
+	// AccessInvite additionally lets anyone in through "/start <code>".
+	AccessInvite AccessMode = "invite"
+)
+
+// ParseAccessMode maps ACCESS_MODE to a mode; empty means open.
+func ParseAccessMode(s string) (AccessMode, error) {
+	switch mode := AccessMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return AccessOpen, nil
+	case AccessOpen, AccessAllowlist, AccessInvite:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("access mode %q: want open, allowlist or invite", s)
+	}
+}
+
+type AccessOptions struct {
+	Mode AccessMode
+	// Allow lists user IDs and @usernames served in allowlist/invite mode.
+	Allow []string
+}
+
+type accessPolicy struct {
+	mode      AccessMode
+	ids       map[int64]bool
+	usernames map[string]bool
+}
+
+func newAccessPolicy(opts AccessOptions) accessPolicy {
+	p := accessPolicy{
+		mode:      opts.Mode,
+		ids:       make(map[int64]bool),
+		usernames: make(map[string]bool),
+	}
+	if p.mode == "" {
+		p.mode = AccessOpen
+	}
+	for _, entry := range opts.Allow {
+		entry = strings.TrimSpace(entry)
+		if id, err := strconv.ParseInt(entry, 10, 64); err == nil {
+			p.ids[id] = true
+		} else if name := strings.TrimPrefix(entry, "@"); name != "" {
+			p.usernames[strings.ToLower(name)] = true
+		}
+	}
+	return p
+}
+
+func (h *Handler) hasAccess(userID int64, username string) bool {
+	switch {
+	case h.access.mode == AccessOpen, h.isAdmin(userID), h.access.ids[userID]:
+		return true
+	case username != "" && h.access.usernames[strings.ToLower(username)]:
+		return true
+	default:
+		return h.users.IsAllowed(userID)
+	}
+}
+
+// admitUpdate lets an outsider in by redeeming "/start <code>" in invite mode
+// and answers everything else with a short refusal. It reports whether the
+// update should be handled.
+func (h *Handler) admitUpdate(ctx context.Context, update telegram.Update) (bool, error) {
+	msg := update.Message
+	if msg != nil && h.access.mode == AccessInvite && msg.IsCommand() && msg.Command() == "start" {
+		// Share deep links are not invites; outsiders get the refusal below.
+		if code := strings.TrimSpace(msg.CommandArguments()); code != "" && !strings.HasPrefix(code, deepLinkPrefix) {
+			return h.redeemInvite(ctx, msg, code)
+		}
+	}
+
+	text := "🔒 Bu bot faqat ruxsat berilgan foydalanuvchilar uchun."
+	if h.access.mode == AccessInvite {
+		text = "🔒 Bu bot yopiq. Taklif kodingiz bo'lsa: /start <kod>"
+	}
+	switch {
+	case update.CallbackQuery != nil:
+		return false, h.tg.AnswerCallback(ctx, update.CallbackQuery.ID, text, true)
+	case update.PreCheckoutQuery != nil:
+		return false, h.tg.AnswerPreCheckout(ctx, update.PreCheckoutQuery.ID, false, text)
+	case msg != nil && msg.Chat.IsPrivate():
+		return false, h.tg.SendText(ctx, msg.Chat.ID, text)
+	}
+	return false, nil
+}
+
+func (h *Handler) redeemInvite(ctx context.Context, msg *tgbotapi.Message, code string) (bool, error) {
+	chatID := msg.Chat.ID
+	userID := msg.From.ID
+	if h.invites == nil {
+		return false, h.tg.SendText(ctx, chatID, "❌ Taklif kodlari hozircha qabul qilinmaydi.")
+	}
+
+	inv, err := h.invites.Redeem(code, userID)
+	switch {
+	case errors.Is(err, users.ErrInviteInvalid):
+		return false, h.tg.SendText(ctx, chatID, "❌ Taklif kodi noto'g'ri yoki bekor qilingan.")
+	case errors.Is(err, users.ErrInviteUsedUp):
+		return false, h.tg.SendText(ctx, chatID, "❌ Bu taklif kodi allaqachon ishlatilgan.")
+	case err != nil:
+		h.logger.Error("invite redeem failed", "user_id", userID, "err", err)
+		return false, h.tg.SendText(ctx, chatID, "❌ Kodni tekshirib bo'lmadi. Birozdan so'ng urinib ko'ring.")
+	}
+	if err := h.users.SetAllowed(userID, inv.Code); err != nil {
+		h.logger.Error("invite grant failed", "user_id", userID, "code", inv.Code, "err", err)
+		return false, h.tg.SendText(ctx, chatID, "❌ Kodni saqlab bo'lmadi. Birozdan so'ng urinib ko'ring.")
+	}
+	h.logger.Info("invite redeemed", "user_id", userID, "code", inv.Code, "uses", inv.Uses, "max_uses", inv.MaxUses)
+	_ = h.tg.SendText(ctx, chatID, "✅ Taklif kodi qabul qilindi. Xush kelibsiz!")
+	return true, nil
+}
+
+// handleInvite is the admin-only "/invite [foydalanish soni] [izoh]".
+func (h *Handler) handleInvite(ctx context.Context, chatID int64, userID int64, args string) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+	if h.invites == nil {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Taklif kodlari o'chirilgan.")
+	}
+
+	fields := strings.Fields(args)
+	uses := 1
+	if len(fields) > 0 {
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			if n < 1 {
+				return h.tg.SendText(ctx, chatID, "Foydalanish: /invite [foydalanish soni] [izoh]")
+			}
+			uses = n
+			fields = fields[1:]
+		}
+	}
+
+	inv, err := h.invites.Create(userID, uses, strings.Join(fields, " "))
+	if err != nil {
+		h.logger.Error("invite create failed", "admin_id", userID, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Kod yaratishda xatolik.")
+	}
+	h.logger.Info("invite created", "admin_id", userID, "code", inv.Code, "max_uses", uses)
+
+	text := fmt.Sprintf("🎟 Taklif kodi: %s (%d marta)\nFoydalanuvchi botga yuborsin: /start %s", inv.Code, inv.MaxUses, inv.Code)
+	if name := h.tg.Username(); name != "" {
+		text += fmt.Sprintf("\nYoki havola: https://t.me/%s?start=%s", name, inv.Code)
+	}
+	if h.access.mode != AccessInvite {
+		text += "\n\n⚠️ ACCESS_MODE=invite emas — kodlar hozir qabul qilinmaydi."
+	}
+	return h.tg.SendText(ctx, chatID, text)
+}
+
+// handleInvites is the admin-only "/invites": active codes and their uses.
+func (h *Handler) handleInvites(ctx context.Context, chatID int64, userID int64) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+	if h.invites == nil {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Taklif kodlari o'chirilgan.")
+	}
+
+	active, err := h.invites.Active()
+	if err != nil {
+		h.logger.Error("invite list failed", "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Kodlarni o'qib bo'lmadi.")
+	}
+	if len(active) == 0 {
+		return h.tg.SendText(ctx, chatID, "Faol taklif kodlari yo'q. Yangi kod: /invite [soni] [izoh]")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎟 Faol kodlar (rejim: %s):\n", h.access.mode)
+	for _, inv := range active {
+		fmt.Fprintf(&b, "• %s — %d/%d", inv.Code, inv.Uses, inv.MaxUses)
+		if inv.Note != "" {
+			fmt.Fprintf(&b, " (%s)", inv.Note)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nBekor qilish: /revoke <kod>")
+	return h.tg.SendText(ctx, chatID, b.String())
+}
+
+// handleRevoke is the admin-only "/revoke <kod>". Users who already redeemed
+// the code keep their access; use /ban for them.
+func (h *Handler) handleRevoke(ctx context.Context, chatID int64, userID int64, args string) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+	if h.invites == nil {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Taklif kodlari o'chirilgan.")
+	}
+
+	code := strings.TrimSpace(args)
+	if code == "" {
+		return h.tg.SendText(ctx, chatID, "Foydalanish: /revoke <kod>")
+	}
+	inv, err := h.invites.Revoke(code)
+	switch {
+	case errors.Is(err, users.ErrInviteInvalid):
+		return h.tg.SendText(ctx, chatID, "❌ Bunday kod yo'q.")
+	case err != nil:
+		h.logger.Error("invite revoke failed", "admin_id", userID, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Kodni bekor qilishda xatolik.")
+	}
+	h.logger.Info("invite revoked", "admin_id", userID, "code", inv.Code)
+	return h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ %s bekor qilindi (%d marta ishlatilgan).", inv.Code, inv.Uses))
+}
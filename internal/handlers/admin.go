@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pro-banana-ai-bot/internal/gemini"
+	"pro-banana-ai-bot/internal/ratelimit"
+	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/users"
+)
+
+// broadcastInterval keeps broadcasts well under Telegram's ~30 messages/s limit.
+const broadcastInterval = 50 * time.Millisecond
+
+const unknownCommandText = "❌ Noma'lum buyruq. /help ni ishlating."
+
+// rejectBanned answers whatever a banned user sent without doing any work.
+func (h *Handler) rejectBanned(ctx context.Context, update telegram.Update) error {
+	switch {
+	case update.CallbackQuery != nil:
+		return h.tg.AnswerCallback(ctx, update.CallbackQuery.ID, "⛔ Sizga bot xizmati cheklangan.", true)
+	case update.PreCheckoutQuery != nil:
+		return h.tg.AnswerPreCheckout(ctx, update.PreCheckoutQuery.ID, false, "Sizga bot xizmati cheklangan.")
+	case update.Message != nil && update.Message.IsCommand() && update.Message.Chat.IsPrivate():
+		return h.tg.SendText(ctx, update.Message.Chat.ID, "⛔ Sizga bot xizmati cheklangan.")
+	}
+	return nil
+}
+
+func (h *Handler) handleStats(ctx context.Context, chatID int64, userID int64) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+
+	st := h.users.Stats(time.Now())
+	api := gemini.ReadStats()
+
+	var b strings.Builder
+	b.WriteString("📊 Statistika\n\n")
+	fmt.Fprintf(&b, "👥 Foydalanuvchilar: %d\n", st.Total)
+	fmt.Fprintf(&b, "• faol (24 soat): %d\n", st.Active24h)
+	fmt.Fprintf(&b, "• faol (7 kun): %d\n", st.Active7d)
+	fmt.Fprintf(&b, "• bloklangan: %d\n\n", st.Banned)
+	fmt.Fprintf(&b, "🎨 Generatsiyalar: %d, xatolar: %d\n\n", st.Generations, st.Failures)
+	b.WriteString("🔢 Gemini (ishga tushgandan beri):\n")
+	fmt.Fprintf(&b, "• so'rovlar: %d, xatolar: %d\n", api.Requests, api.Failures)
+	fmt.Fprintf(&b, "• tokenlar: %d kirish + %d chiqish = %d", api.PromptTokens, api.OutputTokens, api.PromptTokens+api.OutputTokens)
+	return h.tg.SendText(ctx, chatID, b.String())
+}
+
+// handleBroadcast is the admin-only "/broadcast [dry] <text>". A dry run
+// shows the message and recipient count to the admin and sends nothing else.
+func (h *Handler) handleBroadcast(ctx context.Context, chatID int64, userID int64, args string) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+
+	text := strings.TrimSpace(args)
+	dry := false
+	if rest, ok := strings.CutPrefix(text, "dry"); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\n') {
+		dry = true
+		text = strings.TrimSpace(rest)
+	}
+	if text == "" {
+		return h.tg.SendText(ctx, chatID, "Foydalanish: /broadcast <matn>\nSinov (hech kimga yubormaydi): /broadcast dry <matn>")
+	}
+
+	var recipients []int64
+	for _, id := range h.users.Recipients() {
+		if u, ok := h.users.Get(id); ok && h.hasAccess(u.ID, u.Username) {
+			recipients = append(recipients, id)
+		}
+	}
+	if dry {
+		_ = h.tg.SendText(ctx, chatID, text)
+		return h.tg.SendText(ctx, chatID, fmt.Sprintf("🧪 Sinov: yuqoridagi xabar %d ta foydalanuvchiga yuboriladi (~%s).",
+			len(recipients), formatWait(time.Duration(len(recipients))*broadcastInterval)))
+	}
+
+	if !h.broadcasting.CompareAndSwap(false, true) {
+		return h.tg.SendText(ctx, chatID, "⏳ Boshqa xabar tarqatilmoqda, tugashini kuting.")
+	}
+	h.logger.Info("broadcast started", "admin_id", userID, "recipients", len(recipients))
+	_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("📣 %d ta foydalanuvchiga yuborilmoqda…", len(recipients)))
+
+	// The broadcast outlives the command's context.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer h.broadcasting.Store(false)
+
+		ticker := time.NewTicker(broadcastInterval)
+		defer ticker.Stop()
+
+		sent, failed := 0, 0
+		for _, id := range recipients {
+			<-ticker.C
+			if err := h.tg.SendText(ctx, id, text); err != nil {
+				failed++
+				h.logger.Debug("broadcast delivery failed", "user_id", id, "err", err)
+				continue
+			}
+			sent++
+		}
+		h.logger.Info("broadcast finished", "admin_id", userID, "sent", sent, "failed", failed)
+		_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ Tarqatish tugadi: %d yuborildi, %d yetkazilmadi.", sent, failed))
+	}()
+	return nil
+}
+
+// handleBan is the admin-only "/ban <user_id> [sabab]" and "/unban <user_id>".
+func (h *Handler) handleBan(ctx context.Context, chatID int64, userID int64, args string, ban bool) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		if ban {
+			return h.tg.SendText(ctx, chatID, "Foydalanish: /ban <user_id|@username> [sabab]")
+		}
+		return h.tg.SendText(ctx, chatID, "Foydalanish: /unban <user_id|@username>")
+	}
+	target, ok := h.lookupUserID(fields[0])
+	if !ok {
+		return h.tg.SendText(ctx, chatID, "❌ Foydalanuvchi topilmadi.")
+	}
+	if ban && h.isAdmin(target) {
+		return h.tg.SendText(ctx, chatID, "❌ Adminni bloklab bo'lmaydi.")
+	}
+
+	reason := strings.Join(fields[1:], " ")
+	if err := h.users.SetBanned(target, ban, reason); err != nil {
+		h.logger.Error("ban update failed", "admin_id", userID, "target", target, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Saqlashda xatolik.")
+	}
+	if !ban {
+		h.logger.Info("user unbanned", "admin_id", userID, "target", target)
+		return h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ %d blokdan chiqarildi.", target))
+	}
+
+	canceled := h.jobs.CancelUser(target)
+	h.logger.Info("user banned", "admin_id", userID, "target", target, "reason", reason, "jobs_canceled", canceled)
+	return h.tg.SendText(ctx, chatID, fmt.Sprintf("⛔ %d bloklandi. Navbatdan olib tashlandi: %d ta generatsiya.", target, canceled))
+}
+
+// handleUserInfo is the admin-only "/user <user_id|@username>".
+func (h *Handler) handleUserInfo(ctx context.Context, chatID int64, userID int64, args string) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+
+	arg := strings.TrimSpace(args)
+	if arg == "" {
+		return h.tg.SendText(ctx, chatID, "Foydalanish: /user <user_id|@username>")
+	}
+	target, ok := h.lookupUserID(arg)
+	if !ok {
+		return h.tg.SendText(ctx, chatID, "❌ Foydalanuvchi topilmadi.")
+	}
+	u, ok := h.users.Get(target)
+	if !ok {
+		return h.tg.SendText(ctx, chatID, "❌ Foydalanuvchi botdan foydalanmagan.")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "👤 %d", u.ID)
+	if u.Username != "" {
+		fmt.Fprintf(&b, " (@%s)", u.Username)
+	}
+	b.WriteString("\n")
+	if u.Banned {
+		b.WriteString("⛔ Bloklangan")
+		if u.BanReason != "" {
+			fmt.Fprintf(&b, ": %s", u.BanReason)
+		}
+		b.WriteString("\n")
+	}
+	if u.Allowed {
+		fmt.Fprintf(&b, "🎟 Taklif kodi: %s\n", u.InviteCode)
+	}
+	fmt.Fprintf(&b, "Birinchi: %s\nOxirgi: %s\n", u.FirstSeen.Format("02.01.2006 15:04"), u.LastSeen.Format("02.01.2006 15:04"))
+	fmt.Fprintf(&b, "Xabarlar: %d, generatsiyalar: %d, xatolar: %d\n", u.Updates, u.Generations, u.Failures)
+
+	if h.limiter != nil {
+		key := strconv.FormatInt(u.ID, 10)
+		fmt.Fprintf(&b, "\n📏 Limitlar (%s, bugun):\n", h.limiter.Tier(key))
+		for _, budget := range []ratelimit.Budget{ratelimit.BudgetChat, ratelimit.BudgetImage, ratelimit.BudgetPreview} {
+			q := h.limiter.Status(key, budget)
+			fmt.Fprintf(&b, "• %s: %d/%d, hozir %d/%d\n", budget, q.Used, q.Daily, q.Tokens, q.Burst)
+		}
+	}
+	if h.ledger != nil {
+		if balance, err := h.ledger.Balance(u.ID); err == nil {
+			fmt.Fprintf(&b, "\n💳 Balans: %d kredit\n", balance)
+		}
+	}
+
+	if len(u.Recent) > 0 {
+		b.WriteString("\nOxirgi generatsiyalar:\n")
+		for i := len(u.Recent) - 1; i >= 0; i-- {
+			a := u.Recent[i]
+			fmt.Fprintf(&b, "• %s %s — %s\n", a.At.Format("02.01 15:04"), a.Kind, activityResultLabel(a.Result))
+		}
+	}
+	return h.tg.SendText(ctx, chatID, strings.TrimRight(b.String(), "\n"))
+}
+
+func (h *Handler) lookupUserID(arg string) (int64, bool) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return id, true
+	}
+	if u, ok := h.users.FindByUsername(arg); ok {
+		return u.ID, true
+	}
+	return 0, false
+}
+
+func activityResultLabel(result string) string {
+	switch result {
+	case users.ResultOK:
+		return "✅"
+	case users.ResultFailed:
+		return "❌ xato"
+	case users.ResultCanceled:
+		return "bekor qilingan"
+	case users.ResultTimeout:
+		return "⌛ vaqt tugadi"
+	default:
+		return result
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/gemini"
+	"pro-banana-ai-bot/internal/imaging"
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
+)
+
+const bgUsage = "🖼 Fonni almashtirish:\n" +
+	"Rasmni caption bilan yuboring yoki rasmga javob (reply) qiling:\n" +
+	"/bg white — oq fon\n" +
+	"/bg #F5F0E6 exact — aniq rang (lokal tekshiruv bilan)\n" +
+	"/bg gradient #ffffff #d0d8e0 radial — gradient\n" +
+	"/bg marble kitchen counter, morning light — sahna"
+
+// handleBackgroundCommand resolves the photo for a text /bg command: the replied-to
+// photo first, then the last photo saved in the preview wizard.
+func (h *Handler) handleBackgroundCommand(ctx context.Context, chatID int64, userID int64, msg *tgbotapi.Message) error {
+	bg, ok := h.parseBackground(ctx, chatID, msg.CommandArguments())
+	if !ok {
+		return nil
+	}
+
+	fileID := ""
+	if reply := msg.ReplyToMessage; reply != nil && len(reply.Photo) > 0 {
+		fileID = reply.Photo[len(reply.Photo)-1].FileID
+	}
+	if fileID == "" {
+		fileID = strings.TrimSpace(h.preview.Get(chatID, userID).LastPhotoFileID)
+	}
+	if fileID == "" {
+		return h.tg.SendText(ctx, chatID, "📷 Rasmni `/bg <fon>` caption bilan yuboring yoki rasmga reply qiling.\n\n"+bgUsage)
+	}
+
+	if !h.allow(ctx, chatID, userID, ratelimit.BudgetImage, 1) {
+		return nil
+	}
+	return h.enqueueGeneration(ctx, chatID, userID, "background", 0, func(ctx context.Context) error {
+		return h.replaceBackground(ctx, chatID, userID, bg, fileID)
+	})
+}
+
+// parseBackground reads a /bg spec before anything is spent on it; ok is
+// false when the user was shown the usage instead.
+func (h *Handler) parseBackground(ctx context.Context, chatID int64, spec string) (preview.Background, bool) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		_ = h.tg.SendText(ctx, chatID, bgUsage)
+		return preview.Background{}, false
+	}
+	bg, err := preview.ParseBackground(spec)
+	if err != nil {
+		_ = h.tg.SendText(ctx, chatID, "❌ Fon tavsifini tushunmadim.\n\n"+bgUsage)
+		return preview.Background{}, false
+	}
+	return bg, true
+}
+
+func (h *Handler) replaceBackground(ctx context.Context, chatID int64, userID int64, bg preview.Background, fileID string) error {
+	h.tg.SendTyping(ctx, chatID)
+	_ = h.tg.SendText(ctx, chatID, "🎨 Fon almashtirilmoqda: "+bg.String())
+
+	file, err := h.tg.DownloadFile(ctx, fileID)
+	if err != nil {
+		h.logger.Error("background photo download failed", "err", err)
+		_ = h.tg.SendText(ctx, chatID, "❌ Rasmni yuklashda xatolik yuz berdi.")
+		return errNotDelivered
+	}
+
+	resp, err := h.gem.Chat(ctx, nil, preview.BuildBackgroundPrompt(bg), []gemini.ImageInput{{DataBase64: file.Base64, MimeType: file.MimeType}}, gemini.ChatOptions{WantImage: true})
+	if canceled(ctx) {
+		return nil
+	}
+	if err != nil {
+		h.logger.Error("background replace failed", "err", err)
+		_ = h.tg.SendText(ctx, chatID, "❌ Fonni almashtirishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		return errNotDelivered
+	}
+	if len(resp.Images) == 0 {
+		_ = h.tg.SendText(ctx, chatID, "❌ Rasm qaytmadi. Boshqa rasm yuboring yoki tavsifni qisqartiring.")
+		return errNotDelivered
+	}
+
+	caption := "✅ Tayyor! Fon: " + bg.String()
+	if bg.Kind != "solid" || !bg.Exact {
+		return h.sendGeminiResponse(ctx, chatID, userID, gemini.Response{Text: caption, Images: resp.Images}, true)
+	}
+
+	// Exact colour: recolour locally and send as PNG documents so Telegram does not recompress it.
+	for i, img := range resp.Images {
+		sendCaption := ""
+		if i == 0 {
+			sendCaption = caption + " (exact)"
+		}
+		png, err := imaging.RecolorBackgroundDataURL(img, bg.Colors[0], imaging.RecolorOptions{})
+		if err != nil {
+			h.logger.Error("background recolor failed", "err", err)
+			png = img
+		}
+		if err := h.tg.SendDocumentDataURL(ctx, chatID, png, fmt.Sprintf("background_%02d.png", i+1), sendCaption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
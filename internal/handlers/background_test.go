@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"pro-banana-ai-bot/internal/gemini/geminitest"
+	"pro-banana-ai-bot/internal/mediagroup"
+)
+
+func TestBackgroundAlbumSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		requests int
+		reply    string
+	}{
+		{name: "empty", caption: "/bg", reply: bgUsage},
+		{name: "only exact", caption: "/bg exact", reply: "❌ Fon tavsifini tushunmadim."},
+		{name: "bad gradient", caption: "/bg gradient #fff", reply: "❌ Fon tavsifini tushunmadim."},
+		{name: "colour", caption: "/bg white", requests: 2, reply: "🎨 Fon almashtirilmoqda"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := newHarness(t, nil)
+			hs.tg.AddFile("a", geminitest.PNG())
+			hs.tg.AddFile("b", geminitest.PNG())
+
+			hs.h.HandleMediaGroup(context.Background(), mediagroup.Group{
+				ChatID:  testUser,
+				UserID:  testUser,
+				Caption: tt.caption,
+				FileIDs: []string{"a", "b"},
+			})
+			hs.finishJobs()
+
+			if got := len(hs.gem.Requests()); got != tt.requests {
+				t.Errorf("Gemini got %d requests, want %d", got, tt.requests)
+			}
+			texts := hs.sentTexts()
+			if len(texts) == 0 || !strings.HasPrefix(texts[0], tt.reply) {
+				t.Errorf("replies = %q, want the first to start with %q", texts, tt.reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"pro-banana-ai-bot/internal/gemini"
+	"pro-banana-ai-bot/internal/preview"
+)
+
+// maxBatchItems matches Telegram's album limit.
+const maxBatchItems = 10
+
+type batchProgress struct {
+	mu     sync.Mutex
+	total  int
+	done   int
+	failed int
+}
+
+func (p *batchProgress) text() string {
+	if p.done+p.failed < p.total {
+		return fmt.Sprintf("📦 Batch: %d/%d tayyor (xato: %d)…", p.done, p.total, p.failed)
+	}
+	if p.failed == 0 {
+		return fmt.Sprintf("✅ Batch tugadi: %d/%d tayyor.", p.done, p.total)
+	}
+	return fmt.Sprintf("✅ Batch tugadi: %d/%d tayyor, %d ta xato.", p.done, p.total, p.failed)
+}
+
+// runBatch generates one preview set per product photo with a shared option set.
+func (h *Handler) runBatch(ctx context.Context, bill *charge, chatID int64, userID int64, opts preview.Options, fileIDs []string) error {
+	opts.Bundle = false
+	prompt, out := preview.BuildPrompt(opts)
+
+	asFiles := h.users.SendsAsFiles(userID)
+	progress := &batchProgress{total: len(fileIDs)}
+	progressID, err := h.tg.SendTextMessage(ctx, chatID, fmt.Sprintf("📦 Batch: %d ta mahsulot, har biriga %d ta rasm. Boshlandi…", len(fileIDs), out.Count))
+	if err != nil {
+		return err
+	}
+
+	var sendMu sync.Mutex
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(h.batchConcurrency)
+	for i, fileID := range fileIDs {
+		i := i
+		fileID := fileID
+		eg.Go(func() error {
+			images, err := h.generateBatchItem(egCtx, prompt, out, fileID)
+			if canceled(ctx) {
+				bill.fail(out.Count)
+				return ctx.Err()
+			}
+
+			sendMu.Lock()
+			if err == nil {
+				caption := fmt.Sprintf("📦 Mahsulot %d/%d (%d ta)", i+1, len(fileIDs), len(images))
+				_, err = h.deliverPreview(ctx, chatID, caption, images, out, asFiles)
+			}
+			if err == nil {
+				bill.fail(out.Count - len(images))
+			} else {
+				bill.fail(out.Count)
+				h.logger.Error("batch item failed", "index", i, "err", err)
+				_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("❌ Mahsulot %d/%d: preview yaratib bo'lmadi.", i+1, len(fileIDs)))
+			}
+			sendMu.Unlock()
+
+			progress.mu.Lock()
+			if err != nil {
+				progress.failed++
+			} else {
+				progress.done++
+			}
+			_ = h.tg.EditText(ctx, chatID, progressID, progress.text())
+			progress.mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil && !canceled(ctx) {
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) generateBatchItem(ctx context.Context, prompt string, out preview.OutputPreset, fileID string) ([]string, error) {
+	file, err := h.tg.DownloadFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.gem.Chat(ctx, nil, prompt, []gemini.ImageInput{{DataBase64: file.Base64, MimeType: file.MimeType}}, gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Images) == 0 {
+		return nil, errors.New("model returned no images")
+	}
+	return resp.Images, nil
+}
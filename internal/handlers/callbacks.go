@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/callback"
+)
+
+// callbackActions lists the button actions by their one-byte code. Codes are
+// baked into buttons users already have: append new actions, never reorder
+// or reuse a slot.
+var callbackActions = []string{
+	"", // 0 is never issued
+	"menu",
+	"mode",
+	"preset",
+	"cat",
+	"style",
+	"human",
+	"bundle",
+	"files",
+	"frame",
+	"frames_reset",
+	"note",
+	"await_photo",
+	"reset",
+	"close",
+	"prompt",
+	"generate",
+	actionRegenerate,
+	actionVary,
+	actionFrame,
+	actionAsFiles,
+	actionBuy,
+	actionHistoryPage,
+	actionHistorySend,
+	actionHistoryRerun,
+	actionPresetSave,
+	actionPresetApply,
+	actionPresetDelete,
+	actionPresetShare,
+}
+
+const actionBuy = "buy"
+
+// Prefixes of the plain-text callback_data sent before the codec; buttons
+// still on screen keep working.
+const (
+	legacyPreviewPrefix = "pv"
+	legacyBuyPrefix     = "buy"
+)
+
+const staleButtonText = "⌛ Bu tugma eskirgan, /preview ni qayta bosing."
+
+var callbackCodes = func() map[string]byte {
+	m := make(map[string]byte, len(callbackActions))
+	for i, a := range callbackActions {
+		m[a] = byte(i)
+	}
+	return m
+}()
+
+// cb builds callback_data for an action only ownerID may press. An action
+// missing from callbackActions is a programming error, so it panics.
+func (h *Handler) cb(ownerID int64, action string, args ...string) string {
+	code, ok := callbackCodes[action]
+	if !ok || code == 0 {
+		panic("handlers: callback action " + strconv.Quote(action) + " is not in callbackActions")
+	}
+	return h.callbacks.Encode(callback.Data{Action: code, Owner: ownerID, Args: args})
+}
+
+// parseCallback decodes callback_data into its owner, action and arguments.
+func (h *Handler) parseCallback(data string) (ownerID int64, action string, args []string, err error) {
+	data = strings.TrimSpace(data)
+	if ownerID, action, args, ok := parseLegacyCallback(data); ok {
+		return ownerID, action, args, nil
+	}
+
+	d, err := h.callbacks.Decode(data)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if int(d.Action) >= len(callbackActions) || d.Action == 0 {
+		return 0, "", nil, callback.ErrUnknownVersion
+	}
+	return d.Owner, callbackActions[d.Action], d.Args, nil
+}
+
+// parseLegacyCallback reads "pv:<owner>:<action>:<args>" and
+// "buy:<owner>:<pack>".
+func parseLegacyCallback(data string) (int64, string, []string, bool) {
+	parts := strings.Split(data, ":")
+	if len(parts) < 3 {
+		return 0, "", nil, false
+	}
+	ownerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", nil, false
+	}
+	switch parts[0] {
+	case legacyPreviewPrefix:
+		return ownerID, parts[2], parts[3:], true
+	case legacyBuyPrefix:
+		return ownerID, actionBuy, parts[2:], true
+	}
+	return 0, "", nil, false
+}
+
+// handleCallbackQuery decodes a button press, checks who pressed it and
+// routes it to the feature that issued the button.
+func (h *Handler) handleCallbackQuery(ctx context.Context, q *tgbotapi.CallbackQuery) error {
+	if q.Message == nil {
+		return nil
+	}
+	ownerID, action, args, err := h.parseCallback(q.Data)
+	if err != nil {
+		if !errors.Is(err, callback.ErrUnknownVersion) && !errors.Is(err, callback.ErrExpired) {
+			h.logger.Warn("bad callback data", "user_id", q.From.ID, "data", q.Data, "err", err)
+		}
+		return h.tg.AnswerCallback(ctx, q.ID, staleButtonText, true)
+	}
+	if ownerID != q.From.ID {
+		return h.tg.AnswerCallback(ctx, q.ID, "Bu menyu siz uchun emas.", true)
+	}
+
+	switch {
+	case action == actionBuy:
+		return h.handleBuyCallback(ctx, q, args)
+	case isRecipeAction(action):
+		// Result buttons outlive the wizard, so they do not need its state.
+		return h.handleRecipeCallback(ctx, q, ownerID, action, args)
+	case isHistoryAction(action):
+		return h.handleHistoryCallback(ctx, q, ownerID, action, args)
+	default:
+		return h.handleCallback(ctx, q, ownerID, action, args)
+	}
+}
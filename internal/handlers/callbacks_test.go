@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCallbackActionsRoundTrip(t *testing.T) {
+	h := New(Options{})
+	for _, action := range callbackActions[1:] {
+		t.Run(action, func(t *testing.T) {
+			data := h.cb(42, action, "arg", strings.Repeat("z", 70))
+			owner, got, args, err := h.parseCallback(data)
+			if err != nil {
+				t.Fatalf("parseCallback: %v", err)
+			}
+			if owner != 42 || got != action || !slices.Equal(args, []string{"arg", strings.Repeat("z", 70)}) {
+				t.Fatalf("parseCallback = %d %q %q", owner, got, args)
+			}
+		})
+	}
+}
+
+func TestParseLegacyCallback(t *testing.T) {
+	h := New(Options{})
+	tests := []struct {
+		data   string
+		owner  int64
+		action string
+		args   []string
+	}{
+		{data: "pv:7:mode:vertical", owner: 7, action: "mode", args: []string{"vertical"}},
+		{data: "pv:7:generate", owner: 7, action: "generate", args: []string{}},
+		{data: "buy:8:2", owner: 8, action: actionBuy, args: []string{"2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			owner, action, args, err := h.parseCallback(tt.data)
+			if err != nil {
+				t.Fatalf("parseCallback: %v", err)
+			}
+			if owner != tt.owner || action != tt.action || !slices.Equal(args, tt.args) {
+				t.Fatalf("parseCallback = %d %q %q", owner, action, args)
+			}
+		})
+	}
+}
+
+func TestCallbackUnknownActionPanics(t *testing.T) {
+	h := New(Options{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("cb with an unlisted action did not panic")
+		}
+	}()
+	h.cb(1, "not_in_table")
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/credits"
+	"pro-banana-ai-bot/internal/telegram"
+)
+
+const (
+	invoicePrefix = "credits:v1"
+	starsCurrency = "XTR"
+)
+
+// charge is one job's debit. Work the job fails to deliver is marked with fail
+// and refunded in a single ledger entry by settle.
+type charge struct {
+	h      *Handler
+	chatID int64
+	userID int64
+	jobKey string
+	amount int
+
+	mu      sync.Mutex
+	failed  int
+	settled bool
+}
+
+func (c *charge) fail(n int) {
+	if c == nil || n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.failed = min(c.failed+n, c.amount)
+	c.mu.Unlock()
+}
+
+func (c *charge) failAll() {
+	if c == nil {
+		return
+	}
+	c.fail(c.amount)
+}
+
+func (c *charge) settle(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.settled {
+		return
+	}
+	c.settled = true
+	if c.failed == 0 {
+		return
+	}
+
+	tx, err := c.h.ledger.Refund(c.userID, c.jobKey, c.failed, "not delivered")
+	if err != nil {
+		c.h.logger.Error("credit refund failed", "user_id", c.userID, "job", c.jobKey, "err", err)
+		return
+	}
+	_ = c.h.tg.SendText(ctx, c.chatID, fmt.Sprintf("↩️ %d kredit qaytarildi. Balans: %d", tx.Amount, tx.Balance))
+}
+
+// debit charges credits for a job before it is queued; ok is false when the
+// user was told they cannot afford it. A nil charge means billing is off.
+func (h *Handler) debit(ctx context.Context, chatID int64, userID int64, kind string, amount int) (*charge, bool) {
+	if h.ledger == nil || amount <= 0 {
+		return nil, true
+	}
+	h.ensureWelcomeCredits(userID)
+
+	jobKey := newJobKey(kind)
+	_, err := h.ledger.Debit(userID, amount, jobKey, kind)
+	switch {
+	case errors.Is(err, credits.ErrInsufficient):
+		balance, _ := h.ledger.Balance(userID)
+		_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("💳 Kredit yetarli emas: kerak %d, balansingiz %d.\n/buy orqali to'ldiring.", amount, balance))
+		return nil, false
+	case err != nil:
+		h.logger.Error("credit debit failed", "user_id", userID, "err", err)
+		_ = h.tg.SendText(ctx, chatID, "❌ Kreditlarni tekshirib bo'lmadi. Birozdan so'ng urinib ko'ring.")
+		return nil, false
+	}
+	return &charge{h: h, chatID: chatID, userID: userID, jobKey: jobKey, amount: amount}, true
+}
+
+func (h *Handler) ensureWelcomeCredits(userID int64) {
+	if h.ledger == nil || h.welcomeCredits <= 0 {
+		return
+	}
+	ref := strconv.FormatInt(userID, 10)
+	if ok, err := h.ledger.Credited(credits.KindWelcome, ref); err == nil && ok {
+		return
+	}
+	if _, err := h.ledger.Credit(userID, h.welcomeCredits, credits.KindWelcome, ref, "welcome"); err != nil {
+		h.logger.Error("welcome credits failed", "user_id", userID, "err", err)
+	}
+}
+
+func (h *Handler) isAdmin(userID int64) bool {
+	return h.admins[userID]
+}
+
+func (h *Handler) handleBalance(ctx context.Context, chatID int64, userID int64) error {
+	if h.ledger == nil {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Kreditlar tizimi o'chirilgan — generatsiyalar bepul.")
+	}
+	h.ensureWelcomeCredits(userID)
+
+	balance, err := h.ledger.Balance(userID)
+	if err != nil {
+		h.logger.Error("credit balance failed", "user_id", userID, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Balansni o'qib bo'lmadi.")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "💳 Balans: %d kredit\n(1 kredit = 1 preview rasm)\n", balance)
+
+	history, err := h.ledger.History(userID, 5)
+	if err != nil {
+		h.logger.Error("credit history failed", "user_id", userID, "err", err)
+	}
+	if len(history) > 0 {
+		b.WriteString("\nOxirgi amallar:\n")
+		for _, t := range history {
+			fmt.Fprintf(&b, "• %s %+d (%s)\n", t.CreatedAt.Format("02.01 15:04"), t.Amount, creditKindLabel(t.Kind))
+		}
+	}
+	b.WriteString("\nTo'ldirish: /buy")
+	return h.tg.SendText(ctx, chatID, b.String())
+}
+
+func creditKindLabel(kind string) string {
+	switch kind {
+	case credits.KindDebit:
+		return "generatsiya"
+	case credits.KindRefund:
+		return "qaytarildi"
+	case credits.KindPurchase:
+		return "xarid"
+	case credits.KindGrant:
+		return "admin"
+	case credits.KindWelcome:
+		return "bonus"
+	default:
+		return kind
+	}
+}
+
+func (h *Handler) handleBuy(ctx context.Context, chatID int64, userID int64) error {
+	if h.ledger == nil || len(h.creditPacks) == 0 {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Kredit sotib olish hozircha mavjud emas.")
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(h.creditPacks))
+	for i, p := range h.creditPacks {
+		label := fmt.Sprintf("%d kredit — %d ⭐", p.Credits, p.Stars)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, h.cb(userID, actionBuy, strconv.Itoa(i))),
+		))
+	}
+	_, err := h.tg.SendTextWithKeyboard(ctx, chatID, "⭐ Kredit paketini tanlang (Telegram Stars bilan to'lov):", tgbotapi.NewInlineKeyboardMarkup(rows...))
+	return err
+}
+
+func (h *Handler) handleBuyCallback(ctx context.Context, q *tgbotapi.CallbackQuery, args []string) error {
+	idx := -1
+	if len(args) == 1 {
+		idx, _ = strconv.Atoi(args[0])
+	}
+	if h.ledger == nil || idx < 0 || idx >= len(h.creditPacks) {
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Paket topilmadi.", true)
+		return nil
+	}
+
+	pack := h.creditPacks[idx]
+	_ = h.tg.AnswerCallback(ctx, q.ID, "Hisob yuborilyapti…", false)
+	return h.tg.SendInvoice(ctx, q.Message.Chat.ID, telegram.Invoice{
+		Title:       fmt.Sprintf("%d kredit", pack.Credits),
+		Description: fmt.Sprintf("Pro Banana: %d ta preview rasm uchun kredit.", pack.Credits),
+		Payload:     invoicePayload(q.From.ID, pack),
+		Currency:    starsCurrency,
+		Prices:      []telegram.LabeledPrice{{Label: fmt.Sprintf("%d kredit", pack.Credits), Amount: pack.Stars}},
+	})
+}
+
+// handlePreCheckout approves only invoices this bot issued for a pack that
+// still exists at the same price.
+func (h *Handler) handlePreCheckout(ctx context.Context, q *tgbotapi.PreCheckoutQuery) error {
+	if _, err := h.validateInvoice(q.From.ID, q.Currency, q.TotalAmount, q.InvoicePayload); err != nil {
+		h.logger.Warn("pre-checkout rejected", "user_id", q.From.ID, "payload", q.InvoicePayload, "err", err)
+		return h.tg.AnswerPreCheckout(ctx, q.ID, false, "Paket o'zgargan. /buy ni qayta bosing.")
+	}
+	return h.tg.AnswerPreCheckout(ctx, q.ID, true, "")
+}
+
+func (h *Handler) handleSuccessfulPayment(ctx context.Context, chatID int64, userID int64, p *tgbotapi.SuccessfulPayment) error {
+	pack, err := parseInvoicePayload(p.InvoicePayload, userID)
+	if err != nil || h.ledger == nil {
+		h.logger.Error("payment not credited", "user_id", userID, "charge_id", p.TelegramPaymentChargeID, "payload", p.InvoicePayload, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ To'lov qabul qilindi, lekin kredit qo'shilmadi. Admin bilan bog'laning.")
+	}
+
+	// The charge ID keeps redelivered updates from crediting twice.
+	tx, err := h.ledger.Credit(userID, pack.Credits, credits.KindPurchase, p.TelegramPaymentChargeID, fmt.Sprintf("%d XTR", p.TotalAmount))
+	if err != nil {
+		h.logger.Error("payment credit failed", "user_id", userID, "charge_id", p.TelegramPaymentChargeID, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Kredit qo'shishda xatolik. Admin bilan bog'laning.")
+	}
+	h.logger.Info("payment credited", "user_id", userID, "credits", pack.Credits, "stars", p.TotalAmount, "charge_id", p.TelegramPaymentChargeID)
+	return h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ To'lov qabul qilindi: +%d kredit. Balans: %d", pack.Credits, tx.Balance))
+}
+
+// handleGrant is the admin-only "/grant <user_id> <amount> [note]".
+func (h *Handler) handleGrant(ctx context.Context, chatID int64, userID int64, msg *tgbotapi.Message) error {
+	if !h.isAdmin(userID) {
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+	if h.ledger == nil {
+		return h.tg.SendText(ctx, chatID, "ℹ️ Kreditlar tizimi o'chirilgan (CREDITS_ENABLED).")
+	}
+
+	fields := strings.Fields(msg.CommandArguments())
+	if len(fields) < 2 {
+		return h.tg.SendText(ctx, chatID, "Foydalanish: /grant <user_id> <miqdor> [izoh]")
+	}
+	target, err1 := strconv.ParseInt(fields[0], 10, 64)
+	amount, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || amount <= 0 {
+		return h.tg.SendText(ctx, chatID, "❌ user_id va musbat miqdor kiriting.")
+	}
+	note := strings.Join(fields[2:], " ")
+	if note == "" {
+		note = fmt.Sprintf("admin %d", userID)
+	}
+
+	ref := fmt.Sprintf("%d:%d", chatID, msg.MessageID)
+	tx, err := h.ledger.Credit(target, amount, credits.KindGrant, ref, note)
+	if err != nil {
+		h.logger.Error("credit grant failed", "admin_id", userID, "target", target, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Kredit berishda xatolik.")
+	}
+	h.logger.Info("credits granted", "admin_id", userID, "target", target, "amount", amount)
+	return h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ %d foydalanuvchiga +%d kredit. Yangi balans: %d", target, amount, tx.Balance))
+}
+
+func (h *Handler) validateInvoice(userID int64, currency string, total int, payload string) (credits.Pack, error) {
+	pack, err := parseInvoicePayload(payload, userID)
+	if err != nil {
+		return credits.Pack{}, err
+	}
+	if currency != starsCurrency || total != pack.Stars {
+		return credits.Pack{}, fmt.Errorf("amount %d %s does not match pack", total, currency)
+	}
+	for _, p := range h.creditPacks {
+		if p == pack {
+			return pack, nil
+		}
+	}
+	return credits.Pack{}, errors.New("pack no longer offered")
+}
+
+func invoicePayload(userID int64, p credits.Pack) string {
+	return fmt.Sprintf("%s:%d:%d:%d", invoicePrefix, userID, p.Credits, p.Stars)
+}
+
+func parseInvoicePayload(payload string, userID int64) (credits.Pack, error) {
+	rest, ok := strings.CutPrefix(payload, invoicePrefix+":")
+	if !ok {
+		return credits.Pack{}, errors.New("unknown payload")
+	}
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return credits.Pack{}, errors.New("malformed payload")
+	}
+	owner, err1 := strconv.ParseInt(parts[0], 10, 64)
+	creditsN, err2 := strconv.Atoi(parts[1])
+	stars, err3 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return credits.Pack{}, errors.New("malformed payload")
+	}
+	if owner != userID {
+		return credits.Pack{}, errors.New("payload issued for another user")
+	}
+	return credits.Pack{Credits: creditsN, Stars: stars}, nil
+}
+
+func newJobKey(kind string) string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return kind + "-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"pro-banana-ai-bot/internal/credits"
+	"pro-banana-ai-bot/internal/storage"
+	"pro-banana-ai-bot/internal/telegram/telegramtest"
+)
+
+func newLedger(t *testing.T) *credits.Ledger {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	ledger, err := credits.NewLedger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ledger
+}
+
+func TestPaymentCreditedAfterCheckout(t *testing.T) {
+	tests := []struct {
+		name   string
+		access AccessOptions
+		// revoke shuts the user out between pre-checkout and payment.
+		revoke  func(h *Handler) error
+		refusal string
+	}{
+		{
+			name:    "banned",
+			revoke:  func(h *Handler) error { return h.users.SetBanned(testUser, true, "test") },
+			refusal: "Sizga bot xizmati cheklangan.",
+		},
+		{
+			name:   "removed from allowlist",
+			access: AccessOptions{Mode: AccessAllowlist, Allow: []string{strconv.Itoa(testUser)}},
+			revoke: func(h *Handler) error {
+				delete(h.access.ids, testUser)
+				return nil
+			},
+			refusal: "🔒 Bu bot faqat ruxsat berilgan foydalanuvchilar uchun.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack := credits.Pack{Credits: 30, Stars: 25}
+			hs := newHarness(t, func(o *Options) {
+				o.Ledger = newLedger(t)
+				o.CreditPacks = []credits.Pack{pack}
+				o.Access = tt.access
+			})
+			payload := invoicePayload(testUser, pack)
+
+			hs.send(telegramtest.PreCheckout(testUser, payload, pack.Stars))
+			answers := hs.tg.Calls("answerPreCheckoutQuery")
+			if len(answers) != 1 || answers[0].Params.Get("ok") != "true" {
+				t.Fatalf("pre-checkout answers = %+v", answers)
+			}
+
+			if err := tt.revoke(hs.h); err != nil {
+				t.Fatal(err)
+			}
+			hs.send(telegramtest.Payment(testUser, payload, pack.Stars, "charge-1"))
+			if got, _ := hs.ledger.Balance(testUser); got != pack.Credits {
+				t.Errorf("balance = %d, want %d", got, pack.Credits)
+			}
+
+			// The user is refused before they can pay again.
+			hs.send(telegramtest.PreCheckout(testUser, payload, pack.Stars))
+			answers = hs.tg.Calls("answerPreCheckoutQuery")
+			if len(answers) != 2 || answers[1].Params.Get("ok") == "true" || answers[1].Params.Get("error_message") != tt.refusal {
+				t.Errorf("pre-checkout answers after revoke = %+v", answers)
+			}
+		})
+	}
+}
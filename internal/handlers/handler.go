@@ -2,35 +2,117 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"golang.org/x/sync/errgroup"
 
+	"pro-banana-ai-bot/internal/callback"
+	"pro-banana-ai-bot/internal/credits"
 	"pro-banana-ai-bot/internal/gemini"
+	"pro-banana-ai-bot/internal/jobs"
 	"pro-banana-ai-bot/internal/mediagroup"
 	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
 	"pro-banana-ai-bot/internal/session"
 	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/users"
 )
 
+// TelegramClient is the part of the Bot API the handlers use. *telegram.Client
+// implements it; tests can point one at telegramtest.Server instead of
+// Telegram.
+type TelegramClient interface {
+	Username() string
+	SendTyping(ctx context.Context, chatID int64)
+	SendText(ctx context.Context, chatID int64, text string) error
+	SendTextMessage(ctx context.Context, chatID int64, text string) (int, error)
+	EditText(ctx context.Context, chatID int64, messageID int, text string) error
+	SendTextWithKeyboard(ctx context.Context, chatID int64, text string, kb tgbotapi.InlineKeyboardMarkup) (int, error)
+	EditTextWithKeyboard(ctx context.Context, chatID int64, messageID int, text string, kb tgbotapi.InlineKeyboardMarkup) error
+	AnswerCallback(ctx context.Context, callbackID string, text string, showAlert bool) error
+	SendInvoice(ctx context.Context, chatID int64, inv telegram.Invoice) error
+	AnswerPreCheckout(ctx context.Context, queryID string, ok bool, errorMessage string) error
+	SendDocumentDataURL(ctx context.Context, chatID int64, dataURL string, filename string, caption string) error
+	SendAlbum(ctx context.Context, chatID int64, files []telegram.MediaFile, caption string, asDocuments bool) ([]string, error)
+	DownloadFile(ctx context.Context, fileID string) (telegram.Download, error)
+}
+
+var _ TelegramClient = (*telegram.Client)(nil)
+
 type Options struct {
-	Telegram *telegram.Client
+	Telegram TelegramClient
 	Gemini   *gemini.Client
 	Sessions *session.Store
 	Logger   *slog.Logger
 	Preview  *preview.Store
+	// Recipes remembers delivered preview sets for the regenerate buttons;
+	// defaults to an in-memory store.
+	Recipes *preview.Recipes
+	// Results keeps original images for "Send as files"; nil hides the button.
+	Results *session.BlobStore
+	// History records preview runs for /history; defaults to an in-memory store.
+	History *preview.History
+	// Presets keeps users' saved wizard settings; defaults to an in-memory store.
+	Presets *preview.Presets
+	// Callbacks keeps button payloads too long for callback_data; defaults
+	// to an in-memory store.
+	Callbacks callback.Store
+	// Jobs runs image generations; defaults to a small private queue.
+	Jobs *jobs.Queue
+	// Limiter enforces per-user budgets; nil disables limits.
+	Limiter *ratelimit.Limiter
+	// Users tracks activity and bans; defaults to an in-memory store.
+	Users *users.Store
+	// Access restricts who may use the bot; the zero value is open.
+	Access  AccessOptions
+	Invites *users.Invites
+
+	// Ledger enables paid previews (1 credit per image); nil keeps them free.
+	Ledger         *credits.Ledger
+	CreditPacks    []credits.Pack
+	WelcomeCredits int
+	AdminIDs       []int64
+
+	// BatchConcurrency bounds parallel generations in batch catalog mode.
+	BatchConcurrency int
+	// CutoutPadding is the transparent margin (px) around cutout PNGs.
+	CutoutPadding int
 }
 
 type Handler struct {
-	tg         *telegram.Client
+	tg         TelegramClient
 	gem        *gemini.Client
 	sessions   *session.Store
 	logger     *slog.Logger
 	aggregator *mediagroup.Aggregator
 	preview    *preview.Store
+	recipes    *preview.Recipes
+	results    *session.BlobStore
+	history    *preview.History
+	presets    *preview.Presets
+	callbacks  *callback.Codec
+	jobs       *jobs.Queue
+	limiter    *ratelimit.Limiter
+	users      *users.Store
+	access     accessPolicy
+	invites    *users.Invites
+
+	ledger         *credits.Ledger
+	creditPacks    []credits.Pack
+	welcomeCredits int
+	admins         map[int64]bool
+
+	batchConcurrency int
+	cutoutPadding    int
+
+	broadcasting atomic.Bool
 }
 
 func New(opts Options) *Handler {
@@ -41,15 +123,71 @@ func New(opts Options) *Handler {
 
 	pv := opts.Preview
 	if pv == nil {
-		pv = preview.NewStore()
+		pv = preview.NewStore(preview.StoreOptions{Logger: logger})
+	}
+
+	recipes := opts.Recipes
+	if recipes == nil {
+		recipes, _ = preview.NewRecipes(preview.RecipeOptions{Logger: logger})
+	}
+
+	history := opts.History
+	if history == nil {
+		history, _ = preview.NewHistory(preview.HistoryOptions{Logger: logger})
+	}
+
+	presets := opts.Presets
+	if presets == nil {
+		presets, _ = preview.NewPresets(preview.PresetOptions{Logger: logger})
+	}
+
+	callbacks := opts.Callbacks
+	if callbacks == nil {
+		callbacks = callback.NewMemoryStore(48*time.Hour, 50000)
+	}
+
+	queue := opts.Jobs
+	if queue == nil {
+		queue = jobs.New(jobs.Options{Workers: 2, Logger: logger})
+	}
+
+	batchConcurrency := opts.BatchConcurrency
+	if batchConcurrency < 1 {
+		batchConcurrency = 2
+	}
+
+	userStore := opts.Users
+	if userStore == nil {
+		userStore, _ = users.NewStore(users.Options{Logger: logger})
+	}
+
+	admins := make(map[int64]bool, len(opts.AdminIDs))
+	for _, id := range opts.AdminIDs {
+		admins[id] = true
 	}
 
 	return &Handler{
-		tg:       opts.Telegram,
-		gem:      opts.Gemini,
-		sessions: opts.Sessions,
-		logger:   logger,
-		preview:  pv,
+		tg:               opts.Telegram,
+		gem:              opts.Gemini,
+		sessions:         opts.Sessions,
+		logger:           logger,
+		preview:          pv,
+		recipes:          recipes,
+		results:          opts.Results,
+		history:          history,
+		presets:          presets,
+		callbacks:        callback.New(callbacks),
+		jobs:             queue,
+		limiter:          opts.Limiter,
+		users:            userStore,
+		access:           newAccessPolicy(opts.Access),
+		invites:          opts.Invites,
+		ledger:           opts.Ledger,
+		creditPacks:      opts.CreditPacks,
+		welcomeCredits:   opts.WelcomeCredits,
+		admins:           admins,
+		batchConcurrency: batchConcurrency,
+		cutoutPadding:    opts.CutoutPadding,
 	}
 }
 
@@ -58,8 +196,29 @@ func (h *Handler) SetMediaGroupAggregator(ag *mediagroup.Aggregator) {
 }
 
 func (h *Handler) HandleUpdate(ctx context.Context, update telegram.Update) error {
-	if update.CallbackQuery != nil {
-		return h.handleCallback(ctx, update.CallbackQuery)
+	// A successful payment has already charged the user, so it is credited
+	// even after a ban or lost access; both are refused at pre-checkout
+	// instead.
+	paid := update.Message != nil && update.Message.SuccessfulPayment != nil
+	if from := update.SentFrom(); from != nil {
+		if !paid && h.users.IsBanned(from.ID) && !h.isAdmin(from.ID) {
+			return h.rejectBanned(ctx, update)
+		}
+		if !paid && !h.hasAccess(from.ID, from.UserName) {
+			if ok, err := h.admitUpdate(ctx, update); !ok {
+				return err
+			}
+		}
+		// Only users let in are recorded, so strangers do not grow the store.
+		h.users.Seen(from.ID, from.UserName)
+	}
+
+	if update.PreCheckoutQuery != nil {
+		return h.handlePreCheckout(ctx, update.PreCheckoutQuery)
+	}
+
+	if q := update.CallbackQuery; q != nil {
+		return h.handleCallbackQuery(ctx, q)
 	}
 
 	if update.Message == nil {
@@ -71,6 +230,10 @@ func (h *Handler) HandleUpdate(ctx context.Context, update telegram.Update) erro
 	userID := msg.From.ID
 	username := msg.From.UserName
 
+	if msg.SuccessfulPayment != nil {
+		return h.handleSuccessfulPayment(ctx, chatID, userID, msg.SuccessfulPayment)
+	}
+
 	if msg.IsCommand() {
 		return h.handleCommand(ctx, chatID, userID, username, msg)
 	}
@@ -86,6 +249,27 @@ func (h *Handler) HandleUpdate(ctx context.Context, update telegram.Update) erro
 	return nil
 }
 
+const busyText = "⏳ Bot hozir band. Birozdan so'ng qayta yuboring."
+
+// RejectBusy tells the sender that their update was dropped because the bot
+// is overloaded, so it does not look ignored.
+func (h *Handler) RejectBusy(ctx context.Context, update telegram.Update) error {
+	switch {
+	case update.CallbackQuery != nil:
+		return h.tg.AnswerCallback(ctx, update.CallbackQuery.ID, busyText, false)
+	case update.PreCheckoutQuery != nil:
+		return h.tg.AnswerPreCheckout(ctx, update.PreCheckoutQuery.ID, false, busyText)
+	case update.Message != nil && update.Message.Chat.IsPrivate():
+		return h.tg.SendText(ctx, update.Message.Chat.ID, busyText)
+	}
+	return nil
+}
+
+// RejectBusyGroup is RejectBusy for an album dropped as a whole.
+func (h *Handler) RejectBusyGroup(ctx context.Context, group mediagroup.Group) error {
+	return h.tg.SendText(ctx, group.ChatID, busyText)
+}
+
 func (h *Handler) HandleMediaGroup(ctx context.Context, group mediagroup.Group) {
 	caption := strings.TrimSpace(group.Caption)
 	if caption == "" {
@@ -99,6 +283,47 @@ func (h *Handler) HandleMediaGroup(ctx context.Context, group mediagroup.Group)
 				h.logger.Error("preview processing failed", "err", err)
 			}
 			return
+		case "bg":
+			bg, ok := h.parseBackground(ctx, group.ChatID, args)
+			if !ok || !h.allow(ctx, group.ChatID, group.UserID, ratelimit.BudgetImage, len(group.FileIDs)) {
+				return
+			}
+			timeout := h.jobs.Timeout() * time.Duration(len(group.FileIDs))
+			err := h.enqueueGeneration(ctx, group.ChatID, group.UserID, "background", timeout, func(ctx context.Context) error {
+				var failed error
+				for _, fileID := range group.FileIDs {
+					err := h.replaceBackground(ctx, group.ChatID, group.UserID, bg, fileID)
+					if errors.Is(err, errNotDelivered) {
+						failed = err
+						continue
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return failed
+			})
+			if err != nil {
+				h.logger.Error("background processing failed", "err", err)
+			}
+			return
+		}
+	}
+
+	if strings.TrimSpace(group.Caption) == "" {
+		if st := h.preview.Get(group.ChatID, group.UserID); st.AwaitingPhoto {
+			updated := h.preview.Update(group.ChatID, group.UserID, func(st *preview.UIState) {
+				st.LastPhotoFileID = group.FileIDs[0]
+				st.BundleFileIDs = append([]string(nil), group.FileIDs...)
+				st.Bundle = true
+				st.AwaitingPhoto = false
+				st.AwaitingCustom = false
+				st.Menu = "main"
+			})
+			if err := h.renderPreviewUI(ctx, group.ChatID, group.UserID, updated.MessageID, true); err != nil {
+				h.logger.Error("preview render failed", "err", err)
+			}
+			return
 		}
 	}
 	if err := h.processPhotos(ctx, group.ChatID, group.UserID, group.Username, caption, group.FileIDs); err != nil {
@@ -109,7 +334,10 @@ func (h *Handler) HandleMediaGroup(ctx context.Context, group mediagroup.Group)
 func (h *Handler) handleCommand(ctx context.Context, chatID int64, userID int64, username string, msg *tgbotapi.Message) error {
 	switch msg.Command() {
 	case "start":
-		return h.tg.SendText(chatID,
+		if code, ok := strings.CutPrefix(strings.TrimSpace(msg.CommandArguments()), deepLinkPrefix); ok {
+			return h.startFromLink(ctx, chatID, userID, code)
+		}
+		return h.tg.SendText(ctx, chatID,
 			"🍌 Pro Banana AI Bot\n\n"+
 				"Assalomu alaykum! Menga xabar yoki rasm yuboring.\n\n"+
 				"Buyruqlar:\n"+
@@ -117,68 +345,119 @@ func (h *Handler) handleCommand(ctx context.Context, chatID int64, userID int64,
 				"/help - Yordam\n"+
 				"/preview - Marketplace preview (wizard)\n"+
 				"/cover - 1 ta cover (wizard)\n"+
-				"/cancel - Preview wizardni bekor qilish\n"+
+				"/cancel - Wizard va navbatdagi generatsiyalarni bekor qilish\n"+
 				"/image <tavsif> - Rasm yaratish\n"+
+				"/bg <rang|gradient|sahna> - Fonni almashtirish\n"+
+				"/files - Natijalarni fayl (original sifat) qilib yuborish\n"+
+				"/history - Oxirgi preview generatsiyalar\n"+
+				"/balance - Kredit balansi\n"+
+				"/buy - Kredit sotib olish (⭐ Stars)\n"+
 				"/clear - Suhbat tarixini tozalash",
 		)
 	case "help":
-		return h.tg.SendText(chatID,
+		return h.tg.SendText(ctx, chatID,
 			"🍌 Yordam\n\n"+
 				"Matn yuboring — javob beraman.\n"+
 				"Rasm yuboring — tahlil/tahrir qilaman.\n"+
 				"/preview — marketplace uchun pro preview (web'dagidek presetlar bilan).\n"+
+				"/preview preset=<nom> — saqlangan ⭐ preset bilan (wizard'dagi ⭐ Presets menyusi).\n"+
+				"/preview code=<kod> — ulashilgan sozlamalar bilan (⭐ Presets → 🔗 Share).\n"+
 				"/cover — marketplace cover (1 ta rasm).\n"+
-				"/cancel — preview wizardni bekor qilish.\n"+
+				"Albom + /preview caption — bundle: barcha mahsulotlar bitta kadrda (to'plam/kit).\n"+
+				"Albom + /cover batch caption — har bir mahsulotga alohida cover.\n"+
+				"/cancel — wizard va navbatdagi/ishlayotgan generatsiyalarni bekor qilish.\n"+
 				"/image <tavsif> — rasm yaratish.\n"+
+				"/bg <rang|gradient|sahna> — fonni almashtirish (rasm caption'i yoki reply).\n"+
+				"/files on|off — natijalarni siqilmagan fayl (document) qilib yuborish, marketplace uchun.\n"+
+				"/history — oxirgi preview'lar: natijani qayta yuborish yoki shu sozlamalar bilan yangi rasm.\n"+
+				"/balance — kredit balansi, /buy — Telegram Stars bilan to'ldirish.\n"+
 				"/clear — suhbat tarixini tozalash.",
 		)
 	case "preview":
-		return h.startPreviewWizard(chatID, userID, msg.CommandArguments(), false)
+		return h.startPreviewWizard(ctx, chatID, userID, msg.CommandArguments(), false)
 	case "cover":
-		return h.startPreviewWizard(chatID, userID, msg.CommandArguments(), true)
+		return h.startPreviewWizard(ctx, chatID, userID, msg.CommandArguments(), true)
 	case "cancel":
 		h.preview.Update(chatID, userID, func(st *preview.UIState) {
 			st.AwaitingCustom = false
+			st.AwaitingPresetName = false
 			st.AwaitingPhoto = false
 			st.Menu = "main"
 		})
-		return h.tg.SendText(chatID, "✅ Bekor qilindi.")
+		if n := h.jobs.CancelUser(userID); n > 0 {
+			return h.tg.SendText(ctx, chatID, fmt.Sprintf("✅ Bekor qilindi (%d ta generatsiya to'xtatildi).", n))
+		}
+		return h.tg.SendText(ctx, chatID, "✅ Bekor qilindi.")
+	case "bg":
+		return h.handleBackgroundCommand(ctx, chatID, userID, msg)
+	case "files":
+		return h.handleFiles(ctx, chatID, userID, msg.CommandArguments())
+	case "history":
+		return h.handleHistory(ctx, chatID, userID, msg.CommandArguments())
+	case "balance":
+		return h.handleBalance(ctx, chatID, userID)
+	case "buy":
+		return h.handleBuy(ctx, chatID, userID)
+	case "grant":
+		return h.handleGrant(ctx, chatID, userID, msg)
+	case "stats":
+		return h.handleStats(ctx, chatID, userID)
+	case "broadcast":
+		return h.handleBroadcast(ctx, chatID, userID, msg.CommandArguments())
+	case "ban":
+		return h.handleBan(ctx, chatID, userID, msg.CommandArguments(), true)
+	case "unban":
+		return h.handleBan(ctx, chatID, userID, msg.CommandArguments(), false)
+	case "user":
+		return h.handleUserInfo(ctx, chatID, userID, msg.CommandArguments())
+	case "invite":
+		return h.handleInvite(ctx, chatID, userID, msg.CommandArguments())
+	case "invites":
+		return h.handleInvites(ctx, chatID, userID)
+	case "revoke":
+		return h.handleRevoke(ctx, chatID, userID, msg.CommandArguments())
 	case "clear":
 		h.sessions.Clear(userID)
-		return h.tg.SendText(chatID, "✅ Suhbat tarixi tozalandi!")
+		return h.tg.SendText(ctx, chatID, "✅ Suhbat tarixi tozalandi!")
 	case "image":
 		prompt := strings.TrimSpace(msg.CommandArguments())
 		if prompt == "" {
-			return h.tg.SendText(chatID, "❌ Iltimos, rasm tavsifini kiriting!\nMisol: /image banana in space")
+			return h.tg.SendText(ctx, chatID, "❌ Iltimos, rasm tavsifini kiriting!\nMisol: /image banana in space")
 		}
 
-		h.tg.SendTyping(chatID)
-		_ = h.tg.SendText(chatID, "🎨 Rasm yaratilmoqda, biroz kuting...")
-
-		images, err := h.gem.GenerateImage(ctx, prompt)
-		if err != nil {
-			h.logger.Error("image generation failed", "err", err)
-			return h.tg.SendText(chatID, "❌ Rasm yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		if !h.allow(ctx, chatID, userID, ratelimit.BudgetImage, 1) {
+			return nil
 		}
+		return h.enqueueGeneration(ctx, chatID, userID, "image", 0, func(ctx context.Context) error {
+			return h.generateImage(ctx, chatID, userID, prompt)
+		})
+	default:
+		return h.tg.SendText(ctx, chatID, unknownCommandText)
+	}
+}
 
-		if len(images) == 0 {
-			return h.tg.SendText(chatID, "❌ Rasm yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
-		}
+func (h *Handler) generateImage(ctx context.Context, chatID int64, userID int64, prompt string) error {
+	h.tg.SendTyping(ctx, chatID)
+	_ = h.tg.SendText(ctx, chatID, "🎨 Rasm yaratilmoqda, biroz kuting...")
 
-		caption := fmt.Sprintf("✅ Tayyor! Rasm: %q", prompt)
-		for i, img := range images {
-			sendCaption := ""
-			if i == 0 {
-				sendCaption = caption
-			}
-			if err := h.tg.SendPhotoDataURL(chatID, img, sendCaption); err != nil {
-				return err
-			}
-		}
+	images, err := h.gem.GenerateImage(ctx, prompt)
+	if canceled(ctx) {
 		return nil
-	default:
-		return h.tg.SendText(chatID, "❌ Noma'lum buyruq. /help ni ishlating.")
 	}
+	if err != nil {
+		h.logger.Error("image generation failed", "err", err)
+		_ = h.tg.SendText(ctx, chatID, "❌ Rasm yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		return errNotDelivered
+	}
+
+	if len(images) == 0 {
+		_ = h.tg.SendText(ctx, chatID, "❌ Rasm yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		return errNotDelivered
+	}
+
+	caption := fmt.Sprintf("✅ Tayyor! Rasm: %q", prompt)
+	_, err = h.sendImages(ctx, chatID, images, caption, "image", h.users.SendsAsFiles(userID))
+	return err
 }
 
 func (h *Handler) handleText(ctx context.Context, chatID int64, userID int64, username string, text string) error {
@@ -187,22 +466,30 @@ func (h *Handler) handleText(ctx context.Context, chatID int64, userID int64, us
 		return nil
 	}
 
-	if st := h.preview.Get(chatID, userID); st.AwaitingCustom {
+	st := h.preview.Get(chatID, userID)
+	if st.AwaitingPresetName {
+		return h.savePresetNamed(ctx, chatID, userID, text)
+	}
+	if st.AwaitingCustom {
 		updated := h.preview.Update(chatID, userID, func(st *preview.UIState) {
 			st.Custom = text
 			st.AwaitingCustom = false
 			st.Menu = "main"
 		})
-		_ = h.tg.SendText(chatID, "✅ Note saqlandi.")
+		_ = h.tg.SendText(ctx, chatID, "✅ Note saqlandi.")
 		if updated.MessageID != 0 {
-			if err := h.renderPreviewUI(chatID, userID, updated.MessageID, true); err == nil {
+			if err := h.renderPreviewUI(ctx, chatID, userID, updated.MessageID, true); err == nil {
 				return nil
 			}
 		}
-		return h.renderPreviewUI(chatID, userID, 0, false)
+		return h.renderPreviewUI(ctx, chatID, userID, 0, false)
 	}
 
-	h.tg.SendTyping(chatID)
+	if !h.allow(ctx, chatID, userID, ratelimit.BudgetChat, 1) {
+		return nil
+	}
+
+	h.tg.SendTyping(ctx, chatID)
 
 	history := h.sessions.Snapshot(userID, username)
 	geminiHistory := toGeminiHistory(history)
@@ -210,7 +497,7 @@ func (h *Handler) handleText(ctx context.Context, chatID int64, userID int64, us
 	resp, err := h.gem.Chat(ctx, geminiHistory, text, nil, gemini.ChatOptions{})
 	if err != nil {
 		h.logger.Error("gemini chat failed", "err", err)
-		return h.tg.SendText(chatID, "❌ Xatolik yuz berdi. Iltimos, qayta urinib ko'ring.")
+		return h.tg.SendText(ctx, chatID, "❌ Xatolik yuz berdi. Iltimos, qayta urinib ko'ring.")
 	}
 
 	h.sessions.Append(userID, username,
@@ -218,7 +505,7 @@ func (h *Handler) handleText(ctx context.Context, chatID int64, userID int64, us
 		session.HistoryMessage{Role: "model", Content: resp.Text, ImageURLs: resp.Images},
 	)
 
-	return h.sendGeminiResponse(chatID, resp, false)
+	return h.sendGeminiResponse(ctx, chatID, userID, resp, false)
 }
 
 func (h *Handler) handlePhoto(ctx context.Context, chatID int64, userID int64, username string, msg *tgbotapi.Message) error {
@@ -242,18 +529,27 @@ func (h *Handler) handlePhoto(ctx context.Context, chatID int64, userID int64, u
 		switch cmd {
 		case "preview", "cover":
 			return h.handlePreview(ctx, chatID, userID, username, cmd, args, []string{fileID})
+		case "bg":
+			bg, ok := h.parseBackground(ctx, chatID, args)
+			if !ok || !h.allow(ctx, chatID, userID, ratelimit.BudgetImage, 1) {
+				return nil
+			}
+			return h.enqueueGeneration(ctx, chatID, userID, "background", 0, func(ctx context.Context) error {
+				return h.replaceBackground(ctx, chatID, userID, bg, fileID)
+			})
 		}
 	}
 
 	if st := h.preview.Get(chatID, userID); st.AwaitingPhoto || (rawCaption == "" && st.MessageID != 0 && !st.AwaitingCustom) {
 		updated := h.preview.Update(chatID, userID, func(st *preview.UIState) {
 			st.LastPhotoFileID = fileID
+			st.BundleFileIDs = nil
 			st.AwaitingPhoto = false
 			st.AwaitingCustom = false
 			st.Menu = "main"
 		})
 		_ = username
-		return h.renderPreviewUI(chatID, userID, updated.MessageID, true)
+		return h.renderPreviewUI(ctx, chatID, userID, updated.MessageID, true)
 	}
 
 	caption := rawCaption
@@ -265,37 +561,21 @@ func (h *Handler) handlePhoto(ctx context.Context, chatID int64, userID int64, u
 }
 
 func (h *Handler) processPhotos(ctx context.Context, chatID int64, userID int64, username, caption string, fileIDs []string) error {
-	h.tg.SendTyping(chatID)
-
-	type downloaded struct {
-		Base64 string
-		Mime   string
+	if !h.allow(ctx, chatID, userID, ratelimit.BudgetChat, 1) {
+		return nil
 	}
 
-	downloads := make([]downloaded, len(fileIDs))
-	eg, egCtx := errgroup.WithContext(ctx)
-	for i, fileID := range fileIDs {
-		i := i
-		fileID := fileID
-		eg.Go(func() error {
-			data, mimeType, err := h.tg.DownloadFileBase64(egCtx, fileID)
-			if err != nil {
-				return err
-			}
-			downloads[i] = downloaded{Base64: data, Mime: mimeType}
-			return nil
-		})
-	}
-	if err := eg.Wait(); err != nil {
+	h.tg.SendTyping(ctx, chatID)
+
+	images, _, err := h.downloadImages(ctx, fileIDs)
+	if err != nil {
 		h.logger.Error("photo download failed", "err", err)
-		return h.tg.SendText(chatID, "❌ Rasmni yuklashda xatolik yuz berdi.")
+		return h.tg.SendText(ctx, chatID, "❌ Rasmni yuklashda xatolik yuz berdi.")
 	}
 
-	images := make([]gemini.ImageInput, 0, len(downloads))
-	imageURLs := make([]string, 0, len(downloads))
-	for _, d := range downloads {
-		images = append(images, gemini.ImageInput{DataBase64: d.Base64, MimeType: d.Mime})
-		imageURLs = append(imageURLs, fmt.Sprintf("data:%s;base64,%s", d.Mime, d.Base64))
+	imageURLs := make([]string, 0, len(images))
+	for _, img := range images {
+		imageURLs = append(imageURLs, fmt.Sprintf("data:%s;base64,%s", img.MimeType, img.DataBase64))
 	}
 
 	history := h.sessions.Snapshot(userID, username)
@@ -305,7 +585,7 @@ func (h *Handler) processPhotos(ctx context.Context, chatID int64, userID int64,
 	resp, err := h.gem.Chat(ctx, geminiHistory, caption, images, gemini.ChatOptions{WantImage: wantImage})
 	if err != nil {
 		h.logger.Error("gemini photo prompt failed", "err", err)
-		return h.tg.SendText(chatID, "❌ Xatolik yuz berdi. Iltimos, qayta urinib ko'ring.")
+		return h.tg.SendText(ctx, chatID, "❌ Xatolik yuz berdi. Iltimos, qayta urinib ko'ring.")
 	}
 
 	h.sessions.Append(userID, username,
@@ -313,28 +593,83 @@ func (h *Handler) processPhotos(ctx context.Context, chatID int64, userID int64,
 		session.HistoryMessage{Role: "model", Content: resp.Text, ImageURLs: resp.Images},
 	)
 
-	return h.sendGeminiResponse(chatID, resp, wantImage)
+	return h.sendGeminiResponse(ctx, chatID, userID, resp, wantImage)
 }
 
-func (h *Handler) sendGeminiResponse(chatID int64, resp gemini.Response, preferImage bool) error {
+// downloadImages fetches Telegram files in parallel and returns them with
+// their file_unique_ids.
+func (h *Handler) downloadImages(ctx context.Context, fileIDs []string) ([]gemini.ImageInput, []string, error) {
+	images := make([]gemini.ImageInput, len(fileIDs))
+	uniqueIDs := make([]string, len(fileIDs))
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i, fileID := range fileIDs {
+		i := i
+		fileID := fileID
+		eg.Go(func() error {
+			file, err := h.tg.DownloadFile(egCtx, fileID)
+			if err != nil {
+				return err
+			}
+			images[i] = gemini.ImageInput{DataBase64: file.Base64, MimeType: file.MimeType}
+			uniqueIDs[i] = file.UniqueID
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return images, uniqueIDs, nil
+}
+
+func (h *Handler) sendGeminiResponse(ctx context.Context, chatID int64, userID int64, resp gemini.Response, preferImage bool) error {
 	if len(resp.Images) == 0 {
 		if preferImage && looksLikeToolCall(resp.Text) {
-			return h.tg.SendText(chatID, "❌ Rasmni tahrir qilib qaytara olmadim. Iltimos, rasm(lar)ni qayta yuboring yoki tavsifni qisqartiring.")
+			return h.tg.SendText(ctx, chatID, "❌ Rasmni tahrir qilib qaytara olmadim. Iltimos, rasm(lar)ni qayta yuboring yoki tavsifni qisqartiring.")
 		}
-		return h.tg.SendText(chatID, resp.Text)
+		return h.tg.SendText(ctx, chatID, resp.Text)
 	}
 
-	caption := resp.Text
-	for i, img := range resp.Images {
-		sendCaption := ""
-		if i == 0 {
-			sendCaption = caption
-		}
-		if err := h.tg.SendPhotoDataURL(chatID, img, sendCaption); err != nil {
-			return err
+	_, err := h.sendImages(ctx, chatID, resp.Images, resp.Text, "image", h.users.SendsAsFiles(userID))
+	return err
+}
+
+// sendImages delivers images as albums, or as documents named
+// <name>_NN.<ext> when asFiles is set, and returns their Telegram file IDs.
+func (h *Handler) sendImages(ctx context.Context, chatID int64, images []string, caption string, name string, asFiles bool) ([]string, error) {
+	files := make([]telegram.MediaFile, len(images))
+	for i, img := range images {
+		files[i] = telegram.MediaFile{DataURL: img}
+		if asFiles {
+			files[i].Filename = fmt.Sprintf("%s_%02d%s", name, i+1, dataURLExt(img))
 		}
 	}
-	return nil
+	return h.tg.SendAlbum(ctx, chatID, files, caption, asFiles)
+}
+
+func dataURLExt(dataURL string) string {
+	meta, _, _ := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ";")
+	if exts, _ := mime.ExtensionsByType(meta); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".jpg"
+}
+
+func (h *Handler) handleFiles(ctx context.Context, chatID int64, userID int64, args string) error {
+	on := !h.users.SendsAsFiles(userID)
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on", "1", "yes":
+		on = true
+	case "off", "0", "no":
+		on = false
+	}
+	if err := h.users.SetSendAsFiles(userID, on); err != nil {
+		h.logger.Error("save delivery preference failed", "err", err, "user_id", userID)
+		return h.tg.SendText(ctx, chatID, "❌ Sozlamani saqlab bo'lmadi. Qayta urinib ko'ring.")
+	}
+	if on {
+		return h.tg.SendText(ctx, chatID, "📎 Natijalar endi fayl (original sifat, siqilmagan) ko'rinishida yuboriladi.\nO'chirish: /files off")
+	}
+	return h.tg.SendText(ctx, chatID, "🖼 Natijalar endi albom (rasm) ko'rinishida yuboriladi.\nFayl qilib olish: /files on")
 }
 
 func toGeminiHistory(history []session.HistoryMessage) []gemini.Message {
@@ -382,11 +717,10 @@ func parseLeadingCommand(text string) (cmd string, args string, ok bool) {
 }
 
 func (h *Handler) handlePreview(ctx context.Context, chatID int64, userID int64, username, cmd, args string, fileIDs []string) error {
-	_ = ctx
 	_ = username
 
 	if len(fileIDs) == 0 {
-		return h.tg.SendText(chatID, "❌ Rasm topilmadi.")
+		return h.tg.SendText(ctx, chatID, "❌ Rasm topilmadi.")
 	}
 
 	defaults := preview.Options{
@@ -400,7 +734,27 @@ func (h *Handler) handlePreview(ctx context.Context, chatID int64, userID int64,
 		defaults.VisualStyle = "high_key_clean"
 	}
 
-	opts := preview.ParseArgs(args, defaults)
+	opts := h.previewOptions(ctx, chatID, userID, args, defaults)
+	if opts.Batch {
+		if len(fileIDs) > maxBatchItems {
+			fileIDs = fileIDs[:maxBatchItems]
+		}
+		_, out := preview.BuildPrompt(opts)
+		images := out.Count * len(fileIDs)
+		if !h.allow(ctx, chatID, userID, ratelimit.BudgetPreview, images) {
+			return nil
+		}
+		timeout := h.jobs.Timeout() * time.Duration(len(fileIDs))
+		return h.enqueueCharged(ctx, chatID, userID, "batch", images, timeout, func(ctx context.Context, bill *charge) error {
+			return h.runBatch(ctx, bill, chatID, userID, opts, fileIDs)
+		})
+	}
+	if len(fileIDs) > 1 {
+		opts.Bundle = true
+		if len(fileIDs) > preview.MaxBundleProducts {
+			_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("ℹ️ Bundle uchun faqat birinchi %d ta rasm ishlatiladi.", preview.MaxBundleProducts))
+		}
+	}
 
 	updated := h.preview.Update(chatID, userID, func(st *preview.UIState) {
 		st.Mode = opts.Mode
@@ -413,9 +767,18 @@ func (h *Handler) handlePreview(ctx context.Context, chatID int64, userID int64,
 		if strings.TrimSpace(opts.Custom) != "" {
 			st.Custom = opts.Custom
 		}
+		if len(opts.FrameIDs) > 0 {
+			st.SelectFrames(opts.FrameIDs)
+		}
 		st.LastPhotoFileID = fileIDs[0]
+		st.AsFiles = h.users.SendsAsFiles(userID)
+		st.Bundle = opts.Bundle
+		st.BundleFileIDs = nil
+		if len(fileIDs) > 1 {
+			st.BundleFileIDs = append([]string(nil), fileIDs...)
+		}
 		st.AwaitingPhoto = false
 		st.Menu = "main"
 	})
-	return h.renderPreviewUI(chatID, userID, updated.MessageID, true)
+	return h.renderPreviewUI(ctx, chatID, userID, updated.MessageID, true)
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/telegram/telegramtest"
+)
+
+func TestRejectBusy(t *testing.T) {
+	tests := []struct {
+		name   string
+		update telegram.Update
+		method string
+		param  string
+	}{
+		{name: "message", update: telegramtest.Text(testUser, "salom"), method: "sendMessage", param: "text"},
+		{name: "callback", update: telegramtest.Callback(testUser, 1, "x"), method: "answerCallbackQuery", param: "text"},
+		{name: "pre-checkout", update: telegramtest.PreCheckout(testUser, "x", 1), method: "answerPreCheckoutQuery", param: "error_message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := newHarness(t, nil)
+			if err := hs.h.RejectBusy(context.Background(), tt.update); err != nil {
+				t.Fatal(err)
+			}
+			calls := hs.tg.Calls(tt.method)
+			if len(calls) != 1 || calls[0].Params.Get(tt.param) != busyText {
+				t.Errorf("%s calls = %+v", tt.method, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/telegram"
+)
+
+// Callback actions of the /history list. Page takes a page number, the
+// others a run ID.
+const (
+	actionHistoryPage  = "hp"
+	actionHistorySend  = "hs"
+	actionHistoryRerun = "hn"
+)
+
+const historyPageSize = 5
+
+func isHistoryAction(action string) bool {
+	switch action {
+	case actionHistoryPage, actionHistorySend, actionHistoryRerun:
+		return true
+	}
+	return false
+}
+
+// recordRun adds a delivered preview set to the user's history.
+func (h *Handler) recordRun(chatID int64, userID int64, run previewRun, uniqueIDs []string, out preview.OutputPreset, sent []string) {
+	_, err := h.history.Record(preview.Run{
+		ChatID:         chatID,
+		UserID:         userID,
+		Options:        run.opts,
+		InputFileIDs:   run.fileIDs,
+		InputUniqueIDs: uniqueIDs,
+		OutputFileIDs:  sent,
+		AsDocuments:    run.asFiles || out.Mode == "cutout",
+	})
+	if err != nil {
+		h.logger.Error("record preview run failed", "user_id", userID, "err", err)
+	}
+}
+
+func (h *Handler) handleHistory(ctx context.Context, chatID int64, userID int64, args string) error {
+	page, _ := strconv.Atoi(strings.TrimSpace(args))
+	text, kb := h.historyPage(userID, max(page-1, 0))
+	_, err := h.tg.SendTextWithKeyboard(ctx, chatID, text, kb)
+	return err
+}
+
+// historyPage renders one page (0-based) of the user's runs.
+func (h *Handler) historyPage(userID int64, page int) (string, tgbotapi.InlineKeyboardMarkup) {
+	runs, total := h.history.List(userID, page*historyPageSize, historyPageSize)
+	if len(runs) == 0 && page > 0 {
+		page = (total - 1) / historyPageSize
+		runs, total = h.history.List(userID, page*historyPageSize, historyPageSize)
+	}
+	if len(runs) == 0 {
+		return "🕘 Hali preview generatsiyalari yo'q. /preview bilan boshlang.", tgbotapi.NewInlineKeyboardMarkup()
+	}
+
+	pages := (total + historyPageSize - 1) / historyPageSize
+	var b strings.Builder
+	fmt.Fprintf(&b, "🕘 Oxirgi generatsiyalar (%d/%d):\n\n", page+1, pages)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, run := range runs {
+		n := page*historyPageSize + i + 1
+		fmt.Fprintf(&b, "%d. %s — %s, %d ta\n", n, run.CreatedAt.Format("02.01 15:04"), runSummary(run.Options), len(run.OutputFileIDs))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📤 %d", n), h.cb(userID, actionHistorySend, run.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🔁 %d yangi rasm bilan", n), h.cb(userID, actionHistoryRerun, run.ID)),
+		))
+	}
+	b.WriteString("\n📤 — natijalarni qayta yuborish, 🔁 — shu sozlamalar bilan yangi rasm.")
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("⬅", h.cb(userID, actionHistoryPage, strconv.Itoa(page-1))))
+	}
+	if page+1 < pages {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("➡", h.cb(userID, actionHistoryPage, strconv.Itoa(page+1))))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// runSummary describes a run's settings in one line.
+func runSummary(opts preview.Options) string {
+	var parts []string
+	switch opts.Mode {
+	case "vertical":
+		parts = append(parts, "Vertical v"+opts.VerticalCount)
+	case "cutout":
+		parts = append(parts, "Cutout")
+	default:
+		parts = append(parts, "Grid "+opts.GridPreset)
+	}
+	if opts.VisualStyle != "" {
+		parts = append(parts, "style="+opts.VisualStyle)
+	}
+	if opts.ProductType != "" {
+		parts = append(parts, "cat="+opts.ProductType)
+	}
+	if opts.HumanUsage {
+		parts = append(parts, "human")
+	}
+	if opts.Bundle {
+		parts = append(parts, fmt.Sprintf("bundle=%d", opts.BundleSize))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *Handler) handleHistoryCallback(ctx context.Context, q *tgbotapi.CallbackQuery, ownerID int64, action string, args []string) error {
+	chatID := q.Message.Chat.ID
+	if len(args) == 0 {
+		return h.tg.AnswerCallback(ctx, q.ID, "OK", false)
+	}
+
+	if action == actionHistoryPage {
+		page, _ := strconv.Atoi(args[0])
+		_ = h.tg.AnswerCallback(ctx, q.ID, "", false)
+		text, kb := h.historyPage(ownerID, max(page, 0))
+		return h.tg.EditTextWithKeyboard(ctx, chatID, q.Message.MessageID, text, kb)
+	}
+
+	run, ok := h.history.Get(ownerID, args[0])
+	if !ok {
+		return h.tg.AnswerCallback(ctx, q.ID, "⌛ Bu yozuv tarixda yo'q, /history ni qayta oching.", true)
+	}
+
+	if action == actionHistoryRerun {
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Sozlamalar yuklandi.", false)
+		return h.openPreviewWizard(ctx, chatID, ownerID, run.Options)
+	}
+
+	files := make([]telegram.MediaFile, 0, len(run.OutputFileIDs))
+	for _, id := range run.OutputFileIDs {
+		if id != "" {
+			files = append(files, telegram.MediaFile{FileID: id})
+		}
+	}
+	if len(files) == 0 {
+		return h.tg.AnswerCallback(ctx, q.ID, "Bu generatsiyaning natijalari saqlanmagan.", true)
+	}
+
+	_ = h.tg.AnswerCallback(ctx, q.ID, "Yuborilmoqda…", false)
+	caption := fmt.Sprintf("🕘 %s — %s", run.CreatedAt.Format("02.01 15:04"), runSummary(run.Options))
+	if _, err := h.tg.SendAlbum(ctx, chatID, files, caption, run.AsDocuments); err != nil {
+		h.logger.Error("history resend failed", "user_id", ownerID, "run", run.ID, "err", err)
+		return h.tg.SendText(ctx, chatID, "❌ Natijalarni qayta yuborib bo'lmadi. 🔁 bilan shu sozlamalarda qayta yarating.")
+	}
+	return nil
+}
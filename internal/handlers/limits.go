@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"pro-banana-ai-bot/internal/ratelimit"
+)
+
+// limitMessage is the user-facing explanation of a denied request.
+func limitMessage(d ratelimit.Decision, now time.Time) string {
+	wait := formatWait(d.RetryAt.Sub(now))
+	if d.Daily {
+		return fmt.Sprintf("📅 Kunlik limit tugadi (%d ta). Limit %s dan keyin yangilanadi (%s UTC).", d.Cap, wait, d.RetryAt.UTC().Format("15:04"))
+	}
+	return fmt.Sprintf("⏳ Juda tez! %s dan keyin qayta urinib ko'ring.", wait)
+}
+
+func formatWait(d time.Duration) string {
+	if d < time.Second {
+		d = time.Second
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d soniya", int(math.Ceil(d.Seconds())))
+	case d < time.Hour:
+		return fmt.Sprintf("%d daqiqa", int(math.Ceil(d.Minutes())))
+	default:
+		h := int(d.Hours())
+		m := int(d.Minutes()) % 60
+		if m == 0 {
+			return fmt.Sprintf("%d soat", h)
+		}
+		return fmt.Sprintf("%d soat %d daqiqa", h, m)
+	}
+}
+
+// allow checks the user's budget and explains a refusal in chat.
+func (h *Handler) allow(ctx context.Context, chatID int64, userID int64, budget ratelimit.Budget, images int) bool {
+	if h.limiter == nil {
+		return true
+	}
+	d := h.limiter.Allow(strconv.FormatInt(userID, 10), budget, images)
+	if d.Allowed {
+		return true
+	}
+	h.logger.Info("rate limited", "user_id", userID, "budget", budget, "daily", d.Daily)
+	_ = h.tg.SendText(ctx, chatID, limitMessage(d, time.Now()))
+	return false
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/preview"
+)
+
+// Wizard actions of the presets menu; apply and delete take the preset name.
+const (
+	actionPresetSave   = "preset_save"
+	actionPresetApply  = "preset_apply"
+	actionPresetDelete = "preset_delete"
+	actionPresetShare  = "preset_share"
+)
+
+// deepLinkPrefix marks "/start pv_<code>" deep links that open the wizard
+// with shared settings.
+const deepLinkPrefix = "pv_"
+
+// maxStartParam is Telegram's limit for the t.me ?start= parameter.
+const maxStartParam = 64
+
+// previewOptions parses /preview arguments. With "code=<code>" or
+// "preset=<name>" the shared or saved settings replace defaults and the
+// other arguments adjust them.
+func (h *Handler) previewOptions(ctx context.Context, chatID int64, userID int64, args string, defaults preview.Options) preview.Options {
+	if code := preview.CodeArg(args); code != "" {
+		shared, err := preview.DecodeCode(code)
+		if err != nil {
+			_ = h.tg.SendText(ctx, chatID, "❌ Sozlamalar kodi noto'g'ri. Standart sozlamalar ishlatildi.")
+		} else {
+			defaults = shared
+		}
+	}
+	opts := preview.ParseArgs(args, defaults)
+	if opts.Preset == "" {
+		return opts
+	}
+	p, ok := h.presets.Get(userID, opts.Preset)
+	if !ok {
+		_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("❌ %q preset topilmadi. Saqlanganlari: /preview → ⭐ Presets.", opts.Preset))
+		return opts
+	}
+	return preview.ParseArgs(args, p.Options)
+}
+
+// savePresetNamed saves the wizard settings under the name the user typed.
+func (h *Handler) savePresetNamed(ctx context.Context, chatID int64, userID int64, text string) error {
+	name := preview.PresetName(text)
+	if name == "" {
+		return h.tg.SendText(ctx, chatID, "❌ Nomda harf yoki raqam bo'lsin (masalan: sneakers). Qayta yuboring (bekor qilish: /cancel).")
+	}
+
+	st := h.preview.Get(chatID, userID)
+	_, err := h.presets.Save(userID, name, st.Settings())
+	switch {
+	case errors.Is(err, preview.ErrPresetLimit):
+		_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("❌ Ko'pi bilan %d ta preset saqlanadi. Keraksizini 🗑 bilan o'chiring.", preview.MaxPresets))
+	case err != nil:
+		h.logger.Error("save preset failed", "user_id", userID, "err", err)
+		_ = h.tg.SendText(ctx, chatID, "❌ Presetni saqlab bo'lmadi. Qayta urinib ko'ring.")
+	default:
+		_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("⭐ %q saqlandi. Keyingi safar: /preview preset=%s", name, name))
+	}
+
+	updated := h.preview.Update(chatID, userID, func(st *preview.UIState) {
+		st.AwaitingPresetName = false
+		st.Menu = "presets"
+	})
+	if updated.MessageID != 0 {
+		if err := h.renderPreviewUI(ctx, chatID, userID, updated.MessageID, true); err == nil {
+			return nil
+		}
+	}
+	return h.renderPreviewUI(ctx, chatID, userID, 0, false)
+}
+
+// startFromLink opens the wizard from a "/start pv_<code>" deep link.
+func (h *Handler) startFromLink(ctx context.Context, chatID int64, userID int64, code string) error {
+	opts, err := preview.DecodeCode(code)
+	if err != nil {
+		return h.tg.SendText(ctx, chatID, "❌ Havoladagi sozlamalar kodi noto'g'ri. /preview bilan boshlang.")
+	}
+	return h.openPreviewWizard(ctx, chatID, userID, opts)
+}
+
+// shareText describes how to reuse opts: the code, the command and, when it
+// fits Telegram's limit, a deep link.
+func (h *Handler) shareText(title string, opts preview.Options) string {
+	code := preview.EncodeCode(opts)
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔗 %s\n\nKod: %s\n\nBotda: /preview code=%s", title, code, code)
+	if name := h.tg.Username(); name != "" {
+		if len(deepLinkPrefix+code) <= maxStartParam {
+			fmt.Fprintf(&b, "\nHavola: https://t.me/%s?start=%s%s", name, deepLinkPrefix, code)
+		} else {
+			b.WriteString("\nHavola uchun sozlamalar juda uzun — note'ni qisqartiring yoki kodni ulashing.")
+		}
+	}
+	fmt.Fprintf(&b, "\nWeb: ?code=%s", code)
+	return b.String()
+}
+
+func (h *Handler) presetsKeyboard(ownerID int64, presets []preview.Preset) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range presets {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("⭐ "+p.Name, h.cb(ownerID, actionPresetApply, p.Name)),
+			tgbotapi.NewInlineKeyboardButtonData("🔗", h.cb(ownerID, actionPresetShare, p.Name)),
+			tgbotapi.NewInlineKeyboardButtonData("🗑", h.cb(ownerID, actionPresetDelete, p.Name)),
+		})
+	}
+	rows = append(rows,
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("💾 Save current", h.cb(ownerID, actionPresetSave)),
+			tgbotapi.NewInlineKeyboardButtonData("🔗 Share current", h.cb(ownerID, actionPresetShare)),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("⬅ Back", h.cb(ownerID, "menu", "main")),
+		},
+	)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
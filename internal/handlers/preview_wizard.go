@@ -9,12 +9,13 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"pro-banana-ai-bot/internal/gemini"
+	"pro-banana-ai-bot/internal/imaging"
 	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
+	"pro-banana-ai-bot/internal/telegram"
 )
 
-const previewCallbackPrefix = "pv"
-
-func (h *Handler) startPreviewWizard(chatID int64, userID int64, args string, cover bool) error {
+func (h *Handler) startPreviewWizard(ctx context.Context, chatID int64, userID int64, args string, cover bool) error {
 	defaults := preview.Options{
 		Mode:          "grid",
 		GridPreset:    "3x3",
@@ -26,29 +27,29 @@ func (h *Handler) startPreviewWizard(chatID int64, userID int64, args string, co
 		defaults.VisualStyle = "high_key_clean"
 	}
 
-	opts := preview.ParseArgs(args, defaults)
+	opts := h.previewOptions(ctx, chatID, userID, args, defaults)
+	if strings.TrimSpace(opts.Custom) == "" {
+		opts.Custom = h.preview.Get(chatID, userID).Custom
+	}
+	return h.openPreviewWizard(ctx, chatID, userID, opts)
+}
+
+// openPreviewWizard sends a fresh wizard set to opts, waiting for a product
+// photo.
+func (h *Handler) openPreviewWizard(ctx context.Context, chatID int64, userID int64, opts preview.Options) error {
+	asFiles := h.users.SendsAsFiles(userID)
 	st := h.preview.Update(chatID, userID, func(st *preview.UIState) {
 		st.LastPhotoFileID = ""
+		st.AsFiles = asFiles
+		st.BundleFileIDs = nil
 		st.AwaitingCustom = false
-		st.Mode = opts.Mode
-		st.GridPreset = opts.GridPreset
-		st.VerticalCount = opts.VerticalCount
-		st.AspectRatio = opts.AspectRatio
-		st.ProductType = opts.ProductType
-		st.VisualStyle = opts.VisualStyle
-		st.HumanUsage = opts.HumanUsage
-		if strings.TrimSpace(opts.Custom) != "" {
-			st.Custom = opts.Custom
-		}
-		for i := 0; i < 9; i++ {
-			st.SelectedFrames[i] = true
-		}
-		st.LastSelectedOrder = []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
+		st.AwaitingPresetName = false
+		st.ApplyOptions(opts)
 		st.Menu = "main"
 		st.AwaitingPhoto = true
 	})
 
-	msgID, err := h.tg.SendTextWithKeyboard(chatID, previewUIText(st), previewUIKeyboard(userID, st))
+	msgID, err := h.tg.SendTextWithKeyboard(ctx, chatID, previewUIText(st), h.previewUIKeyboard(userID, st, nil))
 	if err != nil {
 		return err
 	}
@@ -56,34 +57,31 @@ func (h *Handler) startPreviewWizard(chatID int64, userID int64, args string, co
 	return nil
 }
 
-func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) error {
-	if q == nil || q.Message == nil {
-		return nil
-	}
-	data := strings.TrimSpace(q.Data)
-	if !strings.HasPrefix(data, previewCallbackPrefix+":") {
-		return nil
-	}
+func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery, ownerID int64, action string, args []string) error {
+	chatID := q.Message.Chat.ID
+	msgID := q.Message.MessageID
 
-	parts := strings.Split(data, ":")
-	if len(parts) < 3 {
+	// Expired or evicted state would otherwise come back as fresh defaults.
+	if _, ok := h.preview.Lookup(chatID, ownerID); !ok {
+		_ = h.tg.AnswerCallback(ctx, q.ID, "⌛ Menyu eskirgan, /preview ni qayta bosing.", true)
 		return nil
 	}
 
-	ownerID, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return nil
-	}
-	if ownerID != q.From.ID {
-		_ = h.tg.AnswerCallback(q.ID, "Bu menyu siz uchun emas.", true)
-		return nil
+	var preset preview.Preset
+	var presetFound bool
+	switch action {
+	case actionPresetApply:
+		if len(args) >= 1 {
+			preset, presetFound = h.presets.Get(ownerID, args[0])
+		}
+	case actionPresetDelete:
+		if len(args) >= 1 {
+			if err := h.presets.Delete(ownerID, args[0]); err != nil {
+				h.logger.Error("delete preset failed", "user_id", ownerID, "err", err)
+			}
+		}
 	}
 
-	action := parts[2]
-	args := parts[3:]
-	chatID := q.Message.Chat.ID
-	msgID := q.Message.MessageID
-
 	updated := h.preview.Update(chatID, ownerID, func(st *preview.UIState) {
 		st.MessageID = msgID
 
@@ -94,9 +92,10 @@ func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery)
 			}
 		case "mode":
 			if len(args) >= 1 {
-				if args[0] == "vertical" {
-					st.Mode = "vertical"
-				} else {
+				switch args[0] {
+				case "vertical", "cutout":
+					st.Mode = args[0]
+				default:
 					st.Mode = "grid"
 				}
 				st.Menu = "main"
@@ -134,6 +133,12 @@ func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery)
 		case "human":
 			st.HumanUsage = !st.HumanUsage
 			st.Menu = "main"
+		case "bundle":
+			st.Bundle = !st.Bundle
+			st.Menu = "main"
+		case "files":
+			st.AsFiles = !st.AsFiles
+			st.Menu = "main"
 		case "frame":
 			if len(args) >= 1 {
 				if idx, err := strconv.Atoi(args[0]); err == nil {
@@ -149,13 +154,27 @@ func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery)
 			st.Menu = "frames"
 		case "note":
 			st.AwaitingCustom = true
+			st.AwaitingPresetName = false
 			st.Menu = "main"
+		case actionPresetSave:
+			st.AwaitingPresetName = true
+			st.AwaitingCustom = false
+			st.Menu = "presets"
+		case actionPresetApply:
+			if presetFound {
+				st.ApplyOptions(preset.Options)
+				st.Menu = "main"
+			}
+		case actionPresetDelete:
+			st.Menu = "presets"
 		case "await_photo":
 			st.AwaitingPhoto = true
 			st.Menu = "main"
 		case "reset":
 			lastPhoto := st.LastPhotoFileID
+			bundlePhotos := st.BundleFileIDs
 			msgID := st.MessageID
+			asFiles := st.AsFiles
 			*st = preview.UIState{}
 			st.Mode = "grid"
 			st.GridPreset = "3x3"
@@ -170,11 +189,15 @@ func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery)
 			}
 			st.LastSelectedOrder = []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
 			st.LastPhotoFileID = lastPhoto
+			st.BundleFileIDs = bundlePhotos
+			st.Bundle = len(bundlePhotos) > 1
 			st.MessageID = msgID
+			st.AsFiles = asFiles
 			st.AwaitingPhoto = true
 			st.Menu = "main"
 		case "close":
 			st.AwaitingCustom = false
+			st.AwaitingPresetName = false
 			st.AwaitingPhoto = false
 			st.Menu = "main"
 		}
@@ -182,46 +205,75 @@ func (h *Handler) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery)
 
 	switch action {
 	case "note":
-		_ = h.tg.AnswerCallback(q.ID, "Note yuboring (bekor qilish: /cancel).", false)
-		_ = h.tg.SendText(chatID, "📝 Qo'shimcha note yuboring (bekor qilish: /cancel).")
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Note yuboring (bekor qilish: /cancel).", false)
+		_ = h.tg.SendText(ctx, chatID, "📝 Qo'shimcha note yuboring (bekor qilish: /cancel).")
+	case actionPresetSave:
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Preset nomini yuboring.", false)
+	case actionPresetApply:
+		if !presetFound {
+			_ = h.tg.AnswerCallback(ctx, q.ID, "Preset topilmadi.", true)
+		} else {
+			_ = h.tg.AnswerCallback(ctx, q.ID, "⭐ "+preset.Name+" qo'llandi", false)
+		}
+	case actionPresetDelete:
+		_ = h.tg.AnswerCallback(ctx, q.ID, "🗑 O'chirildi", false)
+	case actionPresetShare:
+		if len(args) == 0 {
+			_ = h.tg.AnswerCallback(ctx, q.ID, "", false)
+			_ = h.tg.SendText(ctx, chatID, h.shareText("Joriy sozlamalar", updated.Settings()))
+		} else if p, ok := h.presets.Get(ownerID, args[0]); ok {
+			_ = h.tg.AnswerCallback(ctx, q.ID, "", false)
+			_ = h.tg.SendText(ctx, chatID, h.shareText("⭐ "+p.Name, p.Options))
+		} else {
+			_ = h.tg.AnswerCallback(ctx, q.ID, "Preset topilmadi.", true)
+		}
 	case "prompt":
-		_ = h.tg.AnswerCallback(q.ID, "Prompt yuborilyapti…", false)
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Prompt yuborilyapti…", false)
 		st := h.preview.Get(chatID, ownerID)
 		prompt, _ := preview.BuildPrompt(st.PromptOptions())
-		_ = h.tg.SendText(chatID, prompt)
+		_ = h.tg.SendText(ctx, chatID, prompt)
 	case "generate":
-		_ = h.tg.AnswerCallback(q.ID, "Generating…", false)
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Generating…", false)
 		if strings.TrimSpace(updated.LastPhotoFileID) == "" {
 			h.preview.Update(chatID, ownerID, func(st *preview.UIState) { st.AwaitingPhoto = true })
-			_ = h.tg.SendText(chatID, "📷 Mahsulot rasmini yuboring.")
-		} else {
-			if err := h.generateFromPreviewState(ctx, chatID, ownerID, q.From.UserName, updated.LastPhotoFileID); err != nil {
+			_ = h.tg.SendText(ctx, chatID, "📷 Mahsulot rasmini yuboring.")
+		} else if run := wizardRun(updated); h.allow(ctx, chatID, ownerID, ratelimit.BudgetPreview, run.count()) {
+			// The job renders exactly what was billed, even if the wizard
+			// changes while it waits in the queue.
+			err := h.enqueueCharged(ctx, chatID, ownerID, "preview", run.count(), 0, func(ctx context.Context, bill *charge) error {
+				return h.generateFromWizard(ctx, bill, chatID, ownerID, run)
+			})
+			if err != nil {
 				return err
 			}
 		}
 	default:
-		_ = h.tg.AnswerCallback(q.ID, "OK", false)
+		_ = h.tg.AnswerCallback(ctx, q.ID, "OK", false)
 	}
 
-	return h.renderPreviewUI(chatID, ownerID, msgID, true)
+	return h.renderPreviewUI(ctx, chatID, ownerID, msgID, true)
 }
 
-func (h *Handler) renderPreviewUI(chatID int64, userID int64, messageID int, edit bool) error {
+func (h *Handler) renderPreviewUI(ctx context.Context, chatID int64, userID int64, messageID int, edit bool) error {
 	st := h.preview.Get(chatID, userID)
 	if messageID == 0 {
 		messageID = st.MessageID
 	}
 
+	var presets []preview.Preset
+	if st.Menu == "presets" {
+		presets = h.presets.List(userID)
+	}
 	text := previewUIText(st)
-	kb := previewUIKeyboard(userID, st)
+	kb := h.previewUIKeyboard(userID, st, presets)
 
 	if edit && messageID != 0 {
-		if err := h.tg.EditTextWithKeyboard(chatID, messageID, text, kb); err == nil {
+		if err := h.tg.EditTextWithKeyboard(ctx, chatID, messageID, text, kb); err == nil {
 			return nil
 		}
 	}
 
-	msgID, err := h.tg.SendTextWithKeyboard(chatID, text, kb)
+	msgID, err := h.tg.SendTextWithKeyboard(ctx, chatID, text, kb)
 	if err != nil {
 		return err
 	}
@@ -229,49 +281,140 @@ func (h *Handler) renderPreviewUI(chatID int64, userID int64, messageID int, edi
 	return nil
 }
 
-func (h *Handler) generateFromPreviewState(ctx context.Context, chatID int64, userID int64, username string, fileID string) error {
-	st := h.preview.Get(chatID, userID)
-	opts := st.PromptOptions()
+// wizardRun captures the wizard's settings and photos at the time of the
+// click.
+func wizardRun(st preview.UIState) previewRun {
+	run := previewRun{
+		opts:    st.PromptOptions(),
+		fileIDs: []string{st.LastPhotoFileID},
+		asFiles: st.AsFiles,
+		title:   "✅ Tayyor! preview",
+	}
+	if run.opts.Bundle {
+		run.fileIDs = st.BundlePhotos()
+	}
+	return run
+}
+
+func (h *Handler) generateFromWizard(ctx context.Context, bill *charge, chatID int64, userID int64, run previewRun) error {
+	if err := h.runPreview(ctx, bill, chatID, userID, run); err != nil {
+		return err
+	}
+
+	h.preview.Update(chatID, userID, func(st *preview.UIState) {
+		st.AwaitingPhoto = false
+		st.Menu = "main"
+	})
+	return nil
+}
+
+// previewRun is one preview generation: the options to render, the product
+// photos to render them from and how to deliver the result.
+type previewRun struct {
+	opts    preview.Options
+	fileIDs []string
+	asFiles bool
+	title   string
+}
+
+// count is how many images the run renders and is billed for.
+func (r previewRun) count() int {
+	_, out := preview.BuildPrompt(r.opts)
+	return out.Count
+}
+
+// runPreview generates and delivers a preview set, then offers follow-up
+// actions backed by a stored recipe.
+func (h *Handler) runPreview(ctx context.Context, bill *charge, chatID int64, userID int64, run previewRun) error {
+	opts := run.opts
 	prompt, out := preview.BuildPrompt(opts)
 
-	h.tg.SendTyping(chatID)
-	_ = h.tg.SendText(chatID, fmt.Sprintf("🎨 %d ta preview tayyorlanmoqda, biroz kuting...", out.Count))
+	chatOpts := gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio}
+	if opts.Bundle {
+		for i := range run.fileIDs {
+			chatOpts.ImageLabels = append(chatOpts.ImageLabels, fmt.Sprintf("Product #%d (photo #%d):", i+1, i+1))
+		}
+	}
+
+	h.tg.SendTyping(ctx, chatID)
+	_ = h.tg.SendText(ctx, chatID, fmt.Sprintf("🎨 %d ta preview tayyorlanmoqda, biroz kuting...", out.Count))
 
-	base64Data, mimeType, err := h.tg.DownloadFileBase64(ctx, fileID)
+	images, uniqueIDs, err := h.downloadImages(ctx, run.fileIDs)
 	if err != nil {
 		h.logger.Error("preview photo download failed", "err", err)
-		return h.tg.SendText(chatID, "❌ Rasmni yuklashda xatolik yuz berdi.")
+		_ = h.tg.SendText(ctx, chatID, "❌ Rasmni yuklashda xatolik yuz berdi.")
+		return errNotDelivered
 	}
 
-	resp, err := h.gem.Chat(ctx, nil, prompt, []gemini.ImageInput{{DataBase64: base64Data, MimeType: mimeType}}, gemini.ChatOptions{WantImage: true, AspectRatio: out.AspectRatio})
+	resp, err := h.gem.Chat(ctx, nil, prompt, images, chatOpts)
+	if canceled(ctx) {
+		return ctx.Err()
+	}
 	if err != nil {
 		h.logger.Error("preview generation failed", "err", err)
-		return h.tg.SendText(chatID, "❌ Preview yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		_ = h.tg.SendText(ctx, chatID, "❌ Preview yaratishda xatolik yuz berdi. Qayta urinib ko'ring.")
+		return errNotDelivered
 	}
 
 	if len(resp.Images) == 0 {
-		return h.tg.SendText(chatID, "❌ Preview rasm(lar)i chiqarmadi. Boshqa rasm yuboring yoki tavsifni qisqartiring.")
+		_ = h.tg.SendText(ctx, chatID, "❌ Preview rasm(lar)i chiqarmadi. Boshqa rasm yuboring yoki tavsifni qisqartiring.")
+		return errNotDelivered
 	}
+	bill.fail(out.Count - len(resp.Images))
 
-	h.preview.Update(chatID, userID, func(st *preview.UIState) {
-		st.LastPhotoFileID = fileID
-		st.AwaitingPhoto = false
-		st.Menu = "main"
-	})
-
-	caption := fmt.Sprintf("✅ Tayyor! preview (%d ta)", len(resp.Images))
+	caption := fmt.Sprintf("%s (%d ta)", run.title, len(resp.Images))
 	if opts.VisualStyle != "" {
 		caption += ", style=" + opts.VisualStyle
 	}
 	if opts.ProductType != "" {
 		caption += ", cat=" + opts.ProductType
 	}
+	if opts.Bundle {
+		caption += fmt.Sprintf(", bundle=%d", opts.BundleSize)
+	}
+
+	sent, err := h.deliverPreview(ctx, chatID, caption, resp.Images, out, run.asFiles)
+	if err != nil {
+		return err
+	}
+	h.recordRun(chatID, userID, run, uniqueIDs, out, sent)
+	h.offerRecipeActions(ctx, chatID, userID, run, out, resp.Images, sent)
+	return nil
+}
+
+// deliverPreview sends generated previews as an album, or as original
+// files when asFiles is set; cutout results are matted locally and always
+// sent as PNG documents so transparency survives. It returns the Telegram
+// file IDs of what was sent.
+func (h *Handler) deliverPreview(ctx context.Context, chatID int64, caption string, images []string, out preview.OutputPreset, asFiles bool) ([]string, error) {
+	if out.Mode != "cutout" {
+		return h.sendImages(ctx, chatID, images, caption, "preview", asFiles)
+	}
+
+	var sent []string
+	for i, img := range images {
+		sendCaption := ""
+		if i == 0 {
+			sendCaption = caption
+		}
 
-	_ = username // reserved for future per-user history if needed
-	return h.sendGeminiResponse(chatID, gemini.Response{
-		Text:   caption,
-		Images: resp.Images,
-	}, true)
+		png, err := imaging.CutoutDataURL(img, imaging.CutoutOptions{Padding: h.cutoutPadding})
+		if err != nil {
+			h.logger.Error("cutout matting failed", "err", err)
+			ids, err := h.tg.SendAlbum(ctx, chatID, []telegram.MediaFile{{DataURL: img}}, sendCaption, false)
+			if err != nil {
+				return sent, err
+			}
+			sent = append(sent, ids...)
+			continue
+		}
+		ids, err := h.tg.SendAlbum(ctx, chatID, []telegram.MediaFile{{DataURL: png, Filename: fmt.Sprintf("cutout_%02d.png", i+1)}}, sendCaption, true)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, ids...)
+	}
+	return sent, nil
 }
 
 func previewUIText(st preview.UIState) string {
@@ -280,9 +423,13 @@ func previewUIText(st preview.UIState) string {
 
 	mode := "Grid"
 	preset := st.GridPreset
-	if strings.ToLower(st.Mode) == "vertical" {
+	switch strings.ToLower(st.Mode) {
+	case "vertical":
 		mode = "Vertical"
 		preset = "v" + st.VerticalCount
+	case "cutout":
+		mode = "Cutout"
+		preset = "PNG"
 	}
 
 	category := "Auto"
@@ -312,17 +459,29 @@ func previewUIText(st preview.UIState) string {
 	b.WriteString(fmt.Sprintf("Category: %s\n", category))
 	b.WriteString(fmt.Sprintf("Style: %s\n", style))
 	b.WriteString(fmt.Sprintf("Human usage: %s\n", yesNo(st.HumanUsage)))
+	if st.AsFiles {
+		b.WriteString("Delivery: 📎 Files (original)\n")
+	}
 	b.WriteString(fmt.Sprintf("Frames: %d/%d\n", selected, out.Count))
+	if opts.Bundle {
+		b.WriteString(fmt.Sprintf("Bundle: %d ta mahsulot birga\n", opts.BundleSize))
+	}
 	if strings.TrimSpace(st.Custom) != "" {
 		b.WriteString("Note: " + truncateLine(st.Custom, 80) + "\n")
 	}
 	if strings.TrimSpace(st.LastPhotoFileID) == "" {
 		b.WriteString("Photo: (none)\n")
+	} else if n := len(st.BundleFileIDs); n > 1 {
+		b.WriteString(fmt.Sprintf("Photo: %d ta saved ✅\n", n))
 	} else {
 		b.WriteString("Photo: saved ✅\n")
 	}
-	if st.AwaitingCustom {
+	if st.AwaitingPresetName {
+		b.WriteString("\n💾 Preset nomini yuboring, masalan: sneakers (bekor qilish: /cancel).\n")
+	} else if st.AwaitingCustom {
 		b.WriteString("\n📝 Endi note yuboring (bekor qilish: /cancel).\n")
+	} else if st.AwaitingPhoto && st.Bundle {
+		b.WriteString("\n📷 Endi to'plamdagi mahsulotlar rasmlarini bitta albom qilib yuboring.\n")
 	} else if st.AwaitingPhoto {
 		b.WriteString("\n📷 Endi mahsulot rasmini yuboring.\n")
 	} else if strings.TrimSpace(st.LastPhotoFileID) != "" {
@@ -330,9 +489,17 @@ func previewUIText(st preview.UIState) string {
 		b.WriteString("📷 Rasmni almashtirish: yangi rasmni shunchaki yuboring (caption bo'sh) yoki `Photo`.\n")
 	}
 
+	if st.Menu == "presets" {
+		b.WriteString("\n⭐ Presetlar: nomini bosing — qo'llash, 🗑 — o'chirish, 💾 — joriy sozlamalarni saqlash.\n")
+		b.WriteString("Buyruq bilan: /preview preset=<nom>\n")
+	}
+
 	if st.Menu == "frames" {
 		b.WriteString("\nFrames list:\n")
 		frames := preview.FrameTemplates()
+		if opts.Bundle {
+			frames = preview.BundleFrameTemplates()
+		}
 		for i, f := range frames {
 			if i >= 9 {
 				break
@@ -344,96 +511,118 @@ func previewUIText(st preview.UIState) string {
 	return strings.TrimSpace(b.String())
 }
 
-func previewUIKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
+// previewUIKeyboard renders the wizard's current menu; presets are only
+// needed for the presets menu.
+func (h *Handler) previewUIKeyboard(ownerID int64, st preview.UIState, presets []preview.Preset) tgbotapi.InlineKeyboardMarkup {
 	switch st.Menu {
+	case "presets":
+		return h.presetsKeyboard(ownerID, presets)
 	case "category":
-		return categoryKeyboard(ownerID, st)
+		return h.categoryKeyboard(ownerID, st)
 	case "style":
-		return styleKeyboard(ownerID, st)
+		return h.styleKeyboard(ownerID, st)
 	case "frames":
-		return framesKeyboard(ownerID, st)
+		return h.framesKeyboard(ownerID, st)
 	default:
-		return mainKeyboard(ownerID, st)
+		return h.mainKeyboard(ownerID, st)
 	}
 }
 
-func mainKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
+func (h *Handler) mainKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
 	if strings.TrimSpace(st.LastPhotoFileID) == "" {
 		return tgbotapi.NewInlineKeyboardMarkup(
 			[]tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData("📷 Send photo", cb(ownerID, "await_photo")),
-				tgbotapi.NewInlineKeyboardButtonData("Close", cb(ownerID, "close")),
+				tgbotapi.NewInlineKeyboardButtonData("📷 Send photo", h.cb(ownerID, "await_photo")),
+				tgbotapi.NewInlineKeyboardButtonData("Close", h.cb(ownerID, "close")),
 			},
 			[]tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData("Reset", cb(ownerID, "reset")),
+				tgbotapi.NewInlineKeyboardButtonData("⭐ Presets", h.cb(ownerID, "menu", "presets")),
+				tgbotapi.NewInlineKeyboardButtonData("Reset", h.cb(ownerID, "reset")),
 			},
 		)
 	}
 
 	gridText := "Grid"
 	verticalText := "Vertical"
-	if strings.ToLower(st.Mode) != "vertical" {
-		gridText = "✅ Grid"
-	} else {
+	cutoutText := "Cutout"
+	switch strings.ToLower(st.Mode) {
+	case "vertical":
 		verticalText = "✅ Vertical"
+	case "cutout":
+		cutoutText = "✅ Cutout"
+	default:
+		gridText = "✅ Grid"
 	}
 
 	rows := [][]tgbotapi.InlineKeyboardButton{
 		{
-			tgbotapi.NewInlineKeyboardButtonData(gridText, cb(ownerID, "mode", "grid")),
-			tgbotapi.NewInlineKeyboardButtonData(verticalText, cb(ownerID, "mode", "vertical")),
+			tgbotapi.NewInlineKeyboardButtonData(gridText, h.cb(ownerID, "mode", "grid")),
+			tgbotapi.NewInlineKeyboardButtonData(verticalText, h.cb(ownerID, "mode", "vertical")),
+			tgbotapi.NewInlineKeyboardButtonData(cutoutText, h.cb(ownerID, "mode", "cutout")),
 		},
 	}
 
-	if strings.ToLower(st.Mode) == "vertical" {
+	switch strings.ToLower(st.Mode) {
+	case "cutout":
+		// single transparent PNG, no layout presets
+	case "vertical":
 		var presetRow []tgbotapi.InlineKeyboardButton
 		for _, v := range []string{"1", "2", "3", "4"} {
 			label := "v" + v
 			if st.VerticalCount == v {
 				label = "✅ " + label
 			}
-			presetRow = append(presetRow, tgbotapi.NewInlineKeyboardButtonData(label, cb(ownerID, "preset", "vertical", v)))
+			presetRow = append(presetRow, tgbotapi.NewInlineKeyboardButtonData(label, h.cb(ownerID, "preset", "vertical", v)))
 		}
 		rows = append(rows, presetRow)
-	} else {
+	default:
 		var presetRow []tgbotapi.InlineKeyboardButton
 		for _, g := range []string{"1x1", "2x2", "3x2", "3x3"} {
 			label := g
 			if st.GridPreset == g {
 				label = "✅ " + label
 			}
-			presetRow = append(presetRow, tgbotapi.NewInlineKeyboardButtonData(label, cb(ownerID, "preset", "grid", g)))
+			presetRow = append(presetRow, tgbotapi.NewInlineKeyboardButtonData(label, h.cb(ownerID, "preset", "grid", g)))
 		}
 		rows = append(rows, presetRow)
 	}
 
 	rows = append(rows,
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("Category", cb(ownerID, "menu", "category")),
-			tgbotapi.NewInlineKeyboardButtonData("Style", cb(ownerID, "menu", "style")),
+			tgbotapi.NewInlineKeyboardButtonData("Category", h.cb(ownerID, "menu", "category")),
+			tgbotapi.NewInlineKeyboardButtonData("Style", h.cb(ownerID, "menu", "style")),
+			tgbotapi.NewInlineKeyboardButtonData("⭐ Presets", h.cb(ownerID, "menu", "presets")),
 		},
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("Human: "+onOff(st.HumanUsage), cb(ownerID, "human")),
-			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Frames (%d)", countSelectedFrames(st)), cb(ownerID, "menu", "frames")),
+			tgbotapi.NewInlineKeyboardButtonData("Human: "+onOff(st.HumanUsage), h.cb(ownerID, "human")),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Frames (%d)", countSelectedFrames(st)), h.cb(ownerID, "menu", "frames")),
 		},
+	)
+	if len(st.BundleFileIDs) > 1 {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Bundle (%d): %s", len(st.BundlePhotos()), onOff(st.Bundle)), h.cb(ownerID, "bundle")),
+		})
+	}
+	rows = append(rows,
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("Note", cb(ownerID, "note")),
-			tgbotapi.NewInlineKeyboardButtonData("📄 Prompt", cb(ownerID, "prompt")),
+			tgbotapi.NewInlineKeyboardButtonData("Note", h.cb(ownerID, "note")),
+			tgbotapi.NewInlineKeyboardButtonData("📄 Prompt", h.cb(ownerID, "prompt")),
+			tgbotapi.NewInlineKeyboardButtonData("📎 Files: "+onOff(st.AsFiles), h.cb(ownerID, "files")),
 		},
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("📷 Photo", cb(ownerID, "await_photo")),
-			tgbotapi.NewInlineKeyboardButtonData("🎨 Generate", cb(ownerID, "generate")),
+			tgbotapi.NewInlineKeyboardButtonData("📷 Photo", h.cb(ownerID, "await_photo")),
+			tgbotapi.NewInlineKeyboardButtonData("🎨 Generate", h.cb(ownerID, "generate")),
 		},
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("Reset", cb(ownerID, "reset")),
-			tgbotapi.NewInlineKeyboardButtonData("Close", cb(ownerID, "close")),
+			tgbotapi.NewInlineKeyboardButtonData("Reset", h.cb(ownerID, "reset")),
+			tgbotapi.NewInlineKeyboardButtonData("Close", h.cb(ownerID, "close")),
 		},
 	)
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func categoryKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
+func (h *Handler) categoryKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 
 	var row []tgbotapi.InlineKeyboardButton
@@ -449,7 +638,7 @@ func categoryKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboard
 			label = "✅ " + label
 		}
 
-		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, cb(ownerID, "cat", cbKey)))
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, h.cb(ownerID, "cat", cbKey)))
 		if len(row) == 2 {
 			rows = append(rows, row)
 			row = nil
@@ -460,13 +649,13 @@ func categoryKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboard
 	}
 
 	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("⬅ Back", cb(ownerID, "menu", "main")),
+		tgbotapi.NewInlineKeyboardButtonData("⬅ Back", h.cb(ownerID, "menu", "main")),
 	})
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func styleKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
+func (h *Handler) styleKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	var row []tgbotapi.InlineKeyboardButton
 
@@ -482,7 +671,7 @@ func styleKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMar
 			label = "✅ " + label
 		}
 
-		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, cb(ownerID, "style", cbKey)))
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, h.cb(ownerID, "style", cbKey)))
 		if len(row) == 2 {
 			rows = append(rows, row)
 			row = nil
@@ -493,13 +682,13 @@ func styleKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMar
 	}
 
 	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("⬅ Back", cb(ownerID, "menu", "main")),
+		tgbotapi.NewInlineKeyboardButtonData("⬅ Back", h.cb(ownerID, "menu", "main")),
 	})
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func framesKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
+func (h *Handler) framesKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	for r := 0; r < 3; r++ {
 		var row []tgbotapi.InlineKeyboardButton
@@ -511,24 +700,20 @@ func framesKeyboard(ownerID int64, st preview.UIState) tgbotapi.InlineKeyboardMa
 			} else {
 				label = "⬜ " + label
 			}
-			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, cb(ownerID, "frame", strconv.Itoa(idx))))
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, h.cb(ownerID, "frame", strconv.Itoa(idx))))
 		}
 		rows = append(rows, row)
 	}
 
 	rows = append(rows,
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("Reset frames", cb(ownerID, "frames_reset")),
-			tgbotapi.NewInlineKeyboardButtonData("⬅ Back", cb(ownerID, "menu", "main")),
+			tgbotapi.NewInlineKeyboardButtonData("Reset frames", h.cb(ownerID, "frames_reset")),
+			tgbotapi.NewInlineKeyboardButtonData("⬅ Back", h.cb(ownerID, "menu", "main")),
 		},
 	)
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func cb(ownerID int64, parts ...string) string {
-	return fmt.Sprintf("%s:%d:%s", previewCallbackPrefix, ownerID, strings.Join(parts, ":"))
-}
-
 func onOff(v bool) string {
 	if v {
 		return "ON"
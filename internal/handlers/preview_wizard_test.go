@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pro-banana-ai-bot/internal/credits"
+	"pro-banana-ai-bot/internal/gemini/geminitest"
+	"pro-banana-ai-bot/internal/jobs"
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/session"
+	"pro-banana-ai-bot/internal/storage"
+	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/telegram/telegramtest"
+)
+
+const testUser = 42
+
+// harness wires a Handler to fake Telegram and Gemini servers.
+type harness struct {
+	t      *testing.T
+	h      *Handler
+	tg     *telegramtest.Server
+	gem    *geminitest.Server
+	jobs   *jobs.Queue
+	ledger *credits.Ledger
+}
+
+func newHarness(t *testing.T, configure func(*Options)) *harness {
+	t.Helper()
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+	gem := geminitest.NewServer()
+	t.Cleanup(gem.Close)
+
+	// Telegram's per-chat pacing would make every test take seconds.
+	tgOpts := tg.ClientOptions()
+	tgOpts.Pacing = telegram.PacingOptions{GlobalRate: 1000, ChatRate: 1000, ChatBurst: 100}
+	client, err := telegram.New(tgOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := jobs.New(jobs.Options{Workers: 1})
+	t.Cleanup(queue.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts := Options{
+		Telegram: client,
+		Gemini:   gem.NewClient(),
+		Sessions: session.NewStore(session.Options{}),
+		Preview:  preview.NewStore(preview.StoreOptions{Logger: logger}),
+		Jobs:     queue,
+		Logger:   logger,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	return &harness{t: t, h: New(opts), tg: tg, gem: gem, jobs: queue, ledger: opts.Ledger}
+}
+
+func (hs *harness) send(u telegram.Update) {
+	hs.t.Helper()
+	if err := hs.h.HandleUpdate(context.Background(), u); err != nil {
+		hs.t.Fatalf("HandleUpdate: %v", err)
+	}
+}
+
+// press taps the button labeled label on the most recent keyboard that has
+// one.
+func (hs *harness) press(label string) {
+	hs.t.Helper()
+	calls := hs.tg.Calls("sendMessage", "editMessageText")
+	for i := len(calls) - 1; i >= 0; i-- {
+		kb, ok := calls[i].Keyboard()
+		if !ok {
+			continue
+		}
+		for _, row := range kb.InlineKeyboard {
+			for _, btn := range row {
+				if btn.Text == label && btn.CallbackData != nil {
+					hs.send(telegramtest.Callback(testUser, calls[i].MessageID, *btn.CallbackData))
+					return
+				}
+			}
+		}
+	}
+	hs.t.Fatalf("no button %q on any keyboard", label)
+}
+
+// finishJobs waits for the running generation to end.
+func (hs *harness) finishJobs() {
+	hs.t.Helper()
+	if left := hs.jobs.Shutdown(5 * time.Second); len(left) > 0 {
+		hs.t.Fatalf("%d jobs did not finish", len(left))
+	}
+}
+
+// lastText is the text of the last message sent or edited in the chat.
+func (hs *harness) lastText() string {
+	calls := hs.tg.Calls("sendMessage", "editMessageText")
+	if len(calls) == 0 {
+		return ""
+	}
+	return calls[len(calls)-1].Text()
+}
+
+func (hs *harness) sentTexts() []string {
+	var out []string
+	for _, c := range hs.tg.Calls("sendMessage") {
+		out = append(out, c.Text())
+	}
+	return out
+}
+
+func albumSize(c telegramtest.Call) int {
+	var media []json.RawMessage
+	_ = json.Unmarshal([]byte(c.Params.Get("media")), &media)
+	return len(media)
+}
+
+func TestPreviewWizardDelivers(t *testing.T) {
+	tests := []struct {
+		name    string
+		buttons []string
+		method  string
+		calls   int
+		items   int
+		caption string
+		ar      string
+	}{
+		{name: "grid 2x2", buttons: []string{"2x2"}, method: "sendMediaGroup", calls: 1, items: 4, caption: "✅ Tayyor! preview (4 ta)", ar: "3:4"},
+		{name: "vertical v2", buttons: []string{"Vertical", "v2"}, method: "sendMediaGroup", calls: 1, items: 2, caption: "✅ Tayyor! preview (2 ta)", ar: "9:16"},
+		{name: "grid 1x1", buttons: []string{"1x1"}, method: "sendPhoto", calls: 1, items: 1, caption: "✅ Tayyor! preview (1 ta)", ar: "3:4"},
+		{name: "cutout", buttons: []string{"Cutout"}, method: "sendDocument", calls: 1, items: 1, caption: "✅ Tayyor! preview (1 ta)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := newHarness(t, nil)
+			hs.tg.AddFile("product", geminitest.PNG())
+			hs.gem.SetImages(tt.items, "")
+
+			hs.send(telegramtest.Text(testUser, "/preview"))
+			if !strings.Contains(hs.lastText(), "Product Shot Preview") {
+				t.Fatalf("/preview sent %q", hs.lastText())
+			}
+			hs.send(telegramtest.Photo(testUser, "product", ""))
+			for _, b := range tt.buttons {
+				hs.press(b)
+			}
+			hs.press("🎨 Generate")
+			hs.finishJobs()
+
+			reqs := hs.gem.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("Gemini got %d requests, want 1", len(reqs))
+			}
+			if !reqs[0].WantImage || reqs[0].Images != 1 {
+				t.Errorf("Gemini request = %+v, want one photo and an image reply", reqs[0])
+			}
+			if tt.ar != "" && reqs[0].AspectRatio != tt.ar {
+				t.Errorf("aspect ratio = %q, want %q", reqs[0].AspectRatio, tt.ar)
+			}
+
+			sent := hs.tg.Calls(tt.method)
+			if len(sent) != tt.calls {
+				t.Fatalf("%s called %d times, want %d", tt.method, len(sent), tt.calls)
+			}
+			if got := sent[0].Text(); !strings.HasPrefix(got, tt.caption) {
+				t.Errorf("caption = %q, want prefix %q", got, tt.caption)
+			}
+			if tt.method == "sendMediaGroup" && albumSize(sent[0]) != tt.items {
+				t.Errorf("album has %d items, want %d", albumSize(sent[0]), tt.items)
+			}
+		})
+	}
+}
+
+func TestPreviewWizardBilling(t *testing.T) {
+	tests := []struct {
+		name    string
+		images  int
+		fail    bool
+		want    int
+		message string
+	}{
+		{name: "all delivered", images: 4, want: 6},
+		{name: "partial refund", images: 3, want: 7},
+		{name: "generation failed", fail: true, want: 10, message: "❌ Preview yaratishda xatolik"},
+		{name: "no images", images: 0, want: 10, message: "❌ Preview rasm(lar)i chiqarmadi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := storage.Open(filepath.Join(t.TempDir(), "bot.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			ledger, err := credits.NewLedger(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hs := newHarness(t, func(o *Options) {
+				o.Ledger = ledger
+				o.WelcomeCredits = 10
+			})
+			hs.tg.AddFile("product", geminitest.PNG())
+			hs.gem.SetImages(tt.images, "")
+			if tt.fail {
+				hs.gem.Fail(geminitest.Failure{Code: 500, Message: "internal"})
+			}
+
+			hs.send(telegramtest.Text(testUser, "/preview 2x2"))
+			hs.send(telegramtest.Photo(testUser, "product", ""))
+			hs.press("🎨 Generate")
+			hs.finishJobs()
+
+			if got, _ := hs.ledger.Balance(testUser); got != tt.want {
+				t.Errorf("balance = %d, want %d", got, tt.want)
+			}
+			if tt.message != "" && !containsPrefix(hs.sentTexts(), tt.message) {
+				t.Errorf("no %q message in %q", tt.message, hs.sentTexts())
+			}
+			if albums := hs.tg.Calls("sendMediaGroup", "sendPhoto"); tt.message != "" && len(albums) > 0 {
+				t.Errorf("failed run still sent %d albums", len(albums))
+			}
+		})
+	}
+}
+
+func TestPreviewWizardButtonsOwnedByUser(t *testing.T) {
+	hs := newHarness(t, nil)
+	hs.send(telegramtest.Text(testUser, "/preview"))
+
+	wizard := hs.tg.Calls("sendMessage")[0]
+	kb, _ := wizard.Keyboard()
+	data := *kb.InlineKeyboard[0][0].CallbackData
+	hs.send(telegramtest.Callback(testUser+1, wizard.MessageID, data))
+
+	answers := hs.tg.Calls("answerCallbackQuery")
+	if len(answers) != 1 || answers[0].Params.Get("text") != "Bu menyu siz uchun emas." {
+		t.Fatalf("answers = %+v", answers)
+	}
+}
+
+func TestStartDeepLinkOpensWizard(t *testing.T) {
+	code := preview.EncodeCode(preview.Options{Mode: "vertical", VerticalCount: "2", VisualStyle: "dark_premium"})
+	tests := []struct {
+		name string
+		args string
+		want []string
+	}{
+		{name: "shared settings", args: deepLinkPrefix + code, want: []string{"Mode: Vertical (v2)", "Images: 2"}},
+		{name: "bad code", args: deepLinkPrefix + "!!", want: []string{"❌ Havoladagi sozlamalar kodi noto'g'ri"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := newHarness(t, nil)
+			hs.send(telegramtest.Text(testUser, "/start "+tt.args))
+			got := hs.lastText()
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("reply %q lacks %q", got, w)
+				}
+			}
+		})
+	}
+}
+
+func containsPrefix(list []string, prefix string) bool {
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
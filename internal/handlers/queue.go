@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/jobs"
+	"pro-banana-ai-bot/internal/users"
+)
+
+// queueStatus keeps one status message per queued job in sync with its
+// position. Position updates can arrive before the message is sent.
+type queueStatus struct {
+	mu     sync.Mutex
+	h      *Handler
+	chatID int64
+	msgID  int
+	shown  string
+	want   string
+}
+
+func (s *queueStatus) set(ctx context.Context, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.want = text
+	s.flushLocked(ctx)
+}
+
+func (s *queueStatus) attach(ctx context.Context, msgID int, shown string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgID = msgID
+	s.shown = shown
+	s.flushLocked(ctx)
+}
+
+func (s *queueStatus) flushLocked(ctx context.Context) {
+	if s.msgID == 0 || s.want == "" || s.want == s.shown {
+		return
+	}
+	if err := s.h.tg.EditText(ctx, s.chatID, s.msgID, s.want); err == nil {
+		s.shown = s.want
+	}
+}
+
+func queuePositionText(pos int) string {
+	return fmt.Sprintf("⏳ Navbatdasiz: %d-o'rin. Bekor qilish: /cancel", pos)
+}
+
+const restartAbortText = "♻️ Bot qayta ishga tushmoqda — generatsiya to'xtatildi. Birozdan so'ng qayta yuboring."
+
+// errNotDelivered marks a job that already told the user it failed; its
+// credits are refunded.
+var errNotDelivered = errors.New("generation not delivered")
+
+// enqueueGeneration runs fn on the generation queue. Callers return right
+// away; fn gets the job's own context, which /cancel cancels.
+func (h *Handler) enqueueGeneration(ctx context.Context, chatID int64, userID int64, kind string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	return h.enqueueCharged(ctx, chatID, userID, kind, 0, timeout, func(ctx context.Context, _ *charge) error {
+		return fn(ctx)
+	})
+}
+
+// enqueueCharged debits credits up front and refunds whatever the job does
+// not deliver: everything on error, cancel or timeout, or the parts fn
+// reports through bill.
+func (h *Handler) enqueueCharged(ctx context.Context, chatID int64, userID int64, kind string, credits int, timeout time.Duration, fn func(ctx context.Context, bill *charge) error) error {
+	bill, ok := h.debit(ctx, chatID, userID, kind, credits)
+	if !ok {
+		return nil
+	}
+	status := &queueStatus{h: h, chatID: chatID}
+	// Position and cancel notices arrive after this request has returned.
+	later := context.WithoutCancel(ctx)
+
+	_, pos, err := h.jobs.Submit(jobs.Job{
+		UserID:  userID,
+		Kind:    kind,
+		Timeout: timeout,
+		Run: func(ctx context.Context) {
+			// How the job ended is reported even when that is ctx ending.
+			notify := context.WithoutCancel(ctx)
+			defer bill.settle(notify)
+			err := fn(ctx, bill)
+			aborted := errors.Is(context.Cause(ctx), jobs.ErrClosed)
+			if err != nil || aborted {
+				bill.failAll()
+			}
+			if err != nil && ctx.Err() == nil && !errors.Is(err, errNotDelivered) {
+				h.logger.Error("generation job failed", "kind", kind, "user_id", userID, "err", err)
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				_ = h.tg.SendText(notify, chatID, "⌛ Generatsiya juda uzoq davom etdi va to'xtatildi. Qayta urinib ko'ring.")
+			}
+			if aborted {
+				_ = h.tg.SendText(notify, chatID, restartAbortText)
+			}
+			h.users.Record(userID, kind, jobResult(ctx, err))
+		},
+		OnPosition: func(pos int) {
+			if pos == 0 {
+				status.set(later, "▶️ Navbatingiz keldi, boshlanmoqda…")
+				return
+			}
+			status.set(later, queuePositionText(pos))
+		},
+		OnCancel: func(cause error) {
+			h.users.Record(userID, kind, users.ResultCanceled)
+			bill.failAll()
+			if errors.Is(cause, jobs.ErrClosed) {
+				status.set(later, restartAbortText)
+			} else {
+				status.set(later, "❌ Bekor qilindi.")
+			}
+			bill.settle(later)
+		},
+	})
+	if err != nil {
+		bill.failAll()
+		bill.settle(ctx)
+	}
+	switch {
+	case errors.Is(err, jobs.ErrUserQueueFull):
+		return h.tg.SendText(ctx, chatID, "⏳ Sizda allaqachon navbatda generatsiyalar bor. Tugashini kuting yoki /cancel bosing.")
+	case errors.Is(err, jobs.ErrQueueFull):
+		return h.tg.SendText(ctx, chatID, "⏳ Hozir navbat juda uzun. Birozdan so'ng qayta urinib ko'ring.")
+	case errors.Is(err, jobs.ErrClosed):
+		return h.tg.SendText(ctx, chatID, "❌ Bot qayta ishga tushmoqda, birozdan so'ng urinib ko'ring.")
+	case err != nil:
+		return err
+	}
+
+	if pos == 0 {
+		return nil
+	}
+	text := queuePositionText(pos)
+	msgID, err := h.tg.SendTextMessage(ctx, chatID, text)
+	if err != nil {
+		return err
+	}
+	status.attach(ctx, msgID, text)
+	return nil
+}
+
+func jobResult(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return users.ResultTimeout
+	case canceled(ctx):
+		return users.ResultCanceled
+	case err != nil:
+		return users.ResultFailed
+	default:
+		return users.ResultOK
+	}
+}
+
+// canceled reports whether a job was stopped by /cancel or shutdown; the user
+// already got a reply then, so error messages are skipped.
+func canceled(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
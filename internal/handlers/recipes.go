@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/ratelimit"
+)
+
+// Callback actions on delivered results; args[0] is the recipe ID.
+const (
+	actionRegenerate = "rg"
+	actionVary       = "rv"
+	actionFrame      = "rf"
+	actionAsFiles    = "rd"
+)
+
+const resultsGoneText = "⌛ Asl fayllar endi saqlanmagan. 🔁 Regenerate bilan qayta yarating."
+
+func isRecipeAction(action string) bool {
+	switch action {
+	case actionRegenerate, actionVary, actionFrame, actionAsFiles:
+		return true
+	}
+	return false
+}
+
+// offerRecipeActions stores how a preview set was made and sends the
+// follow-up buttons. Albums cannot carry a keyboard, so they go in a
+// separate message.
+func (h *Handler) offerRecipeActions(ctx context.Context, chatID int64, userID int64, run previewRun, out preview.OutputPreset, images []string, sent []string) {
+	rec := preview.Recipe{
+		ChatID:      chatID,
+		UserID:      userID,
+		Options:     run.opts,
+		FileIDs:     run.fileIDs,
+		SentFileIDs: sent,
+		AsFiles:     run.asFiles,
+	}
+	// Photos are recompressed by Telegram; keep the originals for "Send as files".
+	if h.results != nil && !run.asFiles && out.Mode != "cutout" {
+		for _, img := range images {
+			ref, err := h.results.Put(img)
+			if err != nil {
+				h.logger.Error("store result failed", "err", err)
+				rec.Outputs = nil
+				break
+			}
+			rec.Outputs = append(rec.Outputs, ref)
+		}
+	}
+
+	rec, err := h.recipes.Save(rec)
+	if err != nil {
+		h.logger.Error("save recipe failed", "err", err)
+		return
+	}
+	_, _ = h.tg.SendTextWithKeyboard(ctx, chatID, "🔁 Natija yoqdimi? Qayta yaratish yoki variantlar:", h.recipeKeyboard(userID, rec))
+}
+
+func (h *Handler) recipeKeyboard(ownerID int64, rec preview.Recipe) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{{
+		tgbotapi.NewInlineKeyboardButtonData("🔁 Regenerate all", h.cb(ownerID, actionRegenerate, rec.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("✨ More like this", h.cb(ownerID, actionVary, rec.ID)),
+	}}
+
+	if frames := rec.Frames(); len(frames) > 1 {
+		var row []tgbotapi.InlineKeyboardButton
+		for i := range frames {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🔄 %d", i+1), h.cb(ownerID, actionFrame, rec.ID, strconv.Itoa(i))))
+			if len(row) == 5 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(rec.Outputs) > 0 {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📎 Send as files", h.cb(ownerID, actionAsFiles, rec.ID)),
+		})
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (h *Handler) handleRecipeCallback(ctx context.Context, q *tgbotapi.CallbackQuery, ownerID int64, action string, args []string) error {
+	chatID := q.Message.Chat.ID
+	if len(args) == 0 {
+		return h.tg.AnswerCallback(ctx, q.ID, "OK", false)
+	}
+	rec, ok := h.recipes.Get(args[0])
+	if !ok || rec.UserID != ownerID {
+		return h.tg.AnswerCallback(ctx, q.ID, "⌛ Bu natija eskirgan, /preview ni qayta bosing.", true)
+	}
+
+	if action == actionAsFiles {
+		return h.resendAsFiles(ctx, q, rec)
+	}
+
+	run := previewRun{
+		opts:    rec.Options,
+		fileIDs: rec.FileIDs,
+		asFiles: rec.AsFiles,
+		title:   "🔁 Qayta: preview",
+	}
+	switch action {
+	case actionVary:
+		run.opts.Variation = true
+		run.title = "✨ Variatsiya"
+	case actionFrame:
+		frames := rec.Frames()
+		idx := -1
+		if len(args) >= 2 {
+			idx, _ = strconv.Atoi(args[1])
+		}
+		if idx < 0 || idx >= len(frames) {
+			return h.tg.AnswerCallback(ctx, q.ID, "Kadr topilmadi.", true)
+		}
+		run.opts = preview.SingleFrame(rec.Options, frames[idx])
+		run.title = fmt.Sprintf("🔄 Kadr %d", idx+1)
+	}
+
+	// Recipes outlive template updates; say so, as the result will differ.
+	if rec.PromptVersion != preview.PromptVersion {
+		_ = h.tg.AnswerCallback(ctx, q.ID, "ℹ️ Shablonlar yangilangan: natija avvalgisidan farq qilishi mumkin.", true)
+	} else {
+		_ = h.tg.AnswerCallback(ctx, q.ID, "Generating…", false)
+	}
+	_, out := preview.BuildPrompt(run.opts)
+	if !h.allow(ctx, chatID, ownerID, ratelimit.BudgetPreview, out.Count) {
+		return nil
+	}
+	return h.enqueueCharged(ctx, chatID, ownerID, "preview", out.Count, 0, func(ctx context.Context, bill *charge) error {
+		return h.runPreview(ctx, bill, chatID, ownerID, run)
+	})
+}
+
+// resendAsFiles sends the kept originals of a set as documents.
+func (h *Handler) resendAsFiles(ctx context.Context, q *tgbotapi.CallbackQuery, rec preview.Recipe) error {
+	if h.results == nil || len(rec.Outputs) == 0 {
+		return h.tg.AnswerCallback(ctx, q.ID, resultsGoneText, true)
+	}
+	images := make([]string, 0, len(rec.Outputs))
+	for _, ref := range rec.Outputs {
+		img, err := h.results.Get(ref)
+		if err != nil {
+			h.logger.Warn("result blob missing", "recipe", rec.ID, "err", err)
+			return h.tg.AnswerCallback(ctx, q.ID, resultsGoneText, true)
+		}
+		images = append(images, img)
+	}
+
+	_ = h.tg.AnswerCallback(ctx, q.ID, "Fayllar yuborilmoqda…", false)
+	_, err := h.sendImages(ctx, q.Message.Chat.ID, images, "📎 Original fayllar", "preview", true)
+	return err
+}
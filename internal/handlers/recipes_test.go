@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"testing"
+
+	"pro-banana-ai-bot/internal/gemini/geminitest"
+	"pro-banana-ai-bot/internal/preview"
+	"pro-banana-ai-bot/internal/telegram/telegramtest"
+)
+
+func TestRegenerateWarnsAboutTemplateChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		answer  string
+	}{
+		{name: "same templates", version: preview.PromptVersion, answer: "Generating…"},
+		{name: "templates changed", version: "0", answer: "ℹ️ Shablonlar yangilangan: natija avvalgisidan farq qilishi mumkin."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := newHarness(t, nil)
+			hs.tg.AddFile("product", geminitest.PNG())
+			rec, err := hs.h.recipes.Save(preview.Recipe{
+				ChatID:        testUser,
+				UserID:        testUser,
+				Options:       preview.Options{Mode: "grid", GridPreset: "1x1"},
+				PromptVersion: tt.version,
+				FileIDs:       []string{"product"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hs.send(telegramtest.Callback(testUser, 1, hs.h.cb(testUser, actionRegenerate, rec.ID)))
+			hs.finishJobs()
+
+			answers := hs.tg.Calls("answerCallbackQuery")
+			if len(answers) != 1 || answers[0].Params.Get("text") != tt.answer {
+				t.Errorf("answers = %+v, want %q", answers, tt.answer)
+			}
+			if n := len(hs.gem.Requests()); n != 1 {
+				t.Errorf("Gemini got %d requests, want the set regenerated once", n)
+			}
+		})
+	}
+}
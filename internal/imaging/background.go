@@ -0,0 +1,149 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseHexColor parses "#RGB" or "#RRGGBB".
+func ParseHexColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid hex colour %q", value)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex colour %q", value)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+type RecolorOptions struct {
+	// Tolerance is the RGB distance from the detected background colour that is replaced outright.
+	Tolerance float64
+	// Softness is the extra distance over which edge pixels are shifted partially.
+	Softness float64
+}
+
+func (o RecolorOptions) withDefaults() RecolorOptions {
+	if o.Tolerance <= 0 {
+		o.Tolerance = 40
+	}
+	if o.Softness <= 0 {
+		o.Softness = 40
+	}
+	return o
+}
+
+// RecolorBackground replaces the near-uniform background (flood-filled from the
+// image border) with the exact target colour; pixels on the product edge are
+// shifted proportionally so anti-aliasing stays smooth.
+func RecolorBackground(src image.Image, target color.NRGBA, opts RecolorOptions) *image.NRGBA {
+	opts = opts.withDefaults()
+	img := cloneNRGBA(toNRGBA(src))
+	bg := estimateBorderColor(img)
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dist := func(x, y int) float64 {
+		i := img.PixOffset(x, y)
+		dr := float64(img.Pix[i]) - float64(bg.R)
+		dg := float64(img.Pix[i+1]) - float64(bg.G)
+		db := float64(img.Pix[i+2]) - float64(bg.B)
+		return math.Sqrt(dr*dr + dg*dg + db*db)
+	}
+
+	filled := make([]bool, w*h)
+	var stack []image.Point
+	push := func(x, y int) {
+		if x < 0 || y < 0 || x >= w || y >= h || filled[y*w+x] {
+			return
+		}
+		if dist(x, y) > opts.Tolerance {
+			return
+		}
+		filled[y*w+x] = true
+		stack = append(stack, image.Point{X: x, Y: y})
+	}
+	for x := 0; x < w; x++ {
+		push(x, 0)
+		push(x, h-1)
+	}
+	for y := 0; y < h; y++ {
+		push(0, y)
+		push(w-1, y)
+	}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		push(p.X+1, p.Y)
+		push(p.X-1, p.Y)
+		push(p.X, p.Y+1)
+		push(p.X, p.Y-1)
+	}
+
+	shift := [3]float64{
+		float64(target.R) - float64(bg.R),
+		float64(target.G) - float64(bg.G),
+		float64(target.B) - float64(bg.B),
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			if filled[y*w+x] {
+				img.Pix[i], img.Pix[i+1], img.Pix[i+2] = target.R, target.G, target.B
+				continue
+			}
+			if !touchesFilled(filled, w, h, x, y) {
+				continue
+			}
+			d := dist(x, y)
+			if d > opts.Tolerance+opts.Softness {
+				continue
+			}
+			k := 1 - (d-opts.Tolerance)/opts.Softness
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = clamp8(float64(img.Pix[i+c]) + shift[c]*k)
+			}
+		}
+	}
+	return img
+}
+
+// RecolorBackgroundDataURL runs RecolorBackground on a data URL and returns a PNG data URL.
+func RecolorBackgroundDataURL(dataURL string, hex string, opts RecolorOptions) (string, error) {
+	target, err := ParseHexColor(hex)
+	if err != nil {
+		return "", err
+	}
+	img, err := DecodeDataURL(dataURL)
+	if err != nil {
+		return "", err
+	}
+	return EncodePNGDataURL(RecolorBackground(img, target, opts))
+}
+
+func touchesFilled(filled []bool, w, h, x, y int) bool {
+	for _, d := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		nx, ny := x+d[0], y+d[1]
+		if nx < 0 || ny < 0 || nx >= w || ny >= h {
+			continue
+		}
+		if filled[ny*w+nx] {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	out := image.NewNRGBA(img.Rect)
+	copy(out.Pix, img.Pix)
+	return out
+}
@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// MaxCutoutPadding caps Padding; the output grows by twice the padding on
+// each axis.
+const MaxCutoutPadding = 512
+
+type CutoutOptions struct {
+	// Padding is the transparent margin (px) kept around the trimmed product,
+	// at most MaxCutoutPadding.
+	Padding int
+	// Tolerance is the RGB distance from the key colour treated as fully transparent.
+	Tolerance float64
+	// Softness is the RGB distance over which alpha ramps from 0 to 255 (edge matting).
+	Softness float64
+}
+
+func (o CutoutOptions) withDefaults() CutoutOptions {
+	o.Padding = min(max(o.Padding, 0), MaxCutoutPadding)
+	if o.Tolerance <= 0 {
+		o.Tolerance = 60
+	}
+	if o.Softness <= 0 {
+		o.Softness = 50
+	}
+	return o
+}
+
+// Cutout removes a uniform key background and returns the product with alpha,
+// trimmed to its content bounds plus padding. The key colour is estimated from
+// the image border, so it works whatever key the model actually used.
+func Cutout(src image.Image, opts CutoutOptions) *image.NRGBA {
+	opts = opts.withDefaults()
+	img := toNRGBA(src)
+	key := estimateBorderColor(img)
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	kr, kg, kb := float64(key.R), float64(key.G), float64(key.B)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			r, g, b := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+
+			d := math.Sqrt((r-kr)*(r-kr) + (g-kg)*(g-kg) + (b-kb)*(b-kb))
+			alpha := (d - opts.Tolerance) / opts.Softness
+			if alpha <= 0 {
+				continue
+			}
+			if alpha > 1 {
+				alpha = 1
+			}
+
+			// Un-mix the key colour from semi-transparent edge pixels (despill).
+			if alpha < 1 {
+				r = (r - (1-alpha)*kr) / alpha
+				g = (g - (1-alpha)*kg) / alpha
+				b = (b - (1-alpha)*kb) / alpha
+			}
+
+			o := out.PixOffset(x, y)
+			out.Pix[o] = clamp8(r)
+			out.Pix[o+1] = clamp8(g)
+			out.Pix[o+2] = clamp8(b)
+			out.Pix[o+3] = clamp8(alpha * float64(img.Pix[i+3]))
+		}
+	}
+
+	return trimToContent(out, opts.Padding)
+}
+
+func trimToContent(img *image.NRGBA, padding int) *image.NRGBA {
+	const minAlpha = 8
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	minX, minY, maxX, maxY := w, h, -1, -1
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] < minAlpha {
+				continue
+			}
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+		}
+	}
+	if maxX < 0 {
+		return img
+	}
+
+	cw, ch := maxX-minX+1, maxY-minY+1
+	out := image.NewNRGBA(image.Rect(0, 0, cw+2*padding, ch+2*padding))
+	for y := 0; y < ch; y++ {
+		srcOff := img.PixOffset(minX, minY+y)
+		dstOff := out.PixOffset(padding, padding+y)
+		copy(out.Pix[dstOff:dstOff+cw*4], img.Pix[srcOff:srcOff+cw*4])
+	}
+	return out
+}
+
+// estimateBorderColor returns the per-channel median of the outermost pixels.
+func estimateBorderColor(img *image.NRGBA) color.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	var rs, gs, bs []int
+	add := func(x, y int) {
+		i := img.PixOffset(x, y)
+		rs = append(rs, int(img.Pix[i]))
+		gs = append(gs, int(img.Pix[i+1]))
+		bs = append(bs, int(img.Pix[i+2]))
+	}
+	for x := 0; x < w; x++ {
+		add(x, 0)
+		add(x, h-1)
+	}
+	for y := 1; y < h-1; y++ {
+		add(0, y)
+		add(w-1, y)
+	}
+	if len(rs) == 0 {
+		return color.NRGBA{A: 255}
+	}
+	return color.NRGBA{R: uint8(median(rs)), G: uint8(median(gs)), B: uint8(median(bs)), A: 255}
+}
+
+func median(v []int) int {
+	sort.Ints(v)
+	return v[len(v)/2]
+}
+
+func clamp8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// CutoutDataURL runs Cutout on a data URL image and returns a PNG data URL.
+func CutoutDataURL(dataURL string, opts CutoutOptions) (string, error) {
+	img, err := DecodeDataURL(dataURL)
+	if err != nil {
+		return "", err
+	}
+	return EncodePNGDataURL(Cutout(img, opts))
+}
@@ -0,0 +1,58 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// DecodeDataURL decodes a "data:<mime>;base64,..." URL (or bare base64) into an image.
+func DecodeDataURL(dataURL string) (image.Image, error) {
+	dataURL = strings.TrimSpace(dataURL)
+	if dataURL == "" {
+		return nil, errors.New("empty data url")
+	}
+	if idx := strings.IndexByte(dataURL, ','); idx >= 0 && strings.HasPrefix(dataURL, "data:") {
+		dataURL = dataURL[idx+1:]
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(dataURL)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+	return Decode(raw)
+}
+
+func Decode(raw []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	return img, nil
+}
+
+// EncodePNGDataURL encodes img as a PNG data URL.
+func EncodePNGDataURL(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", fmt.Errorf("encode png: %w", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if n, ok := src.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
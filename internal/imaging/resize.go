@@ -0,0 +1,60 @@
+package imaging
+
+import "image"
+
+// Resize scales src to w×h with bilinear filtering.
+func Resize(src image.Image, w, h int) *image.NRGBA {
+	img := toNRGBA(src)
+	sw, sh := img.Rect.Dx(), img.Rect.Dy()
+	if sw == w && sh == h {
+		return img
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if sw == 0 || sh == 0 || w == 0 || h == 0 {
+		return out
+	}
+
+	xScale := float64(sw) / float64(w)
+	yScale := float64(sh) / float64(h)
+	for y := 0; y < h; y++ {
+		fy := (float64(y)+0.5)*yScale - 0.5
+		y0 := clampInt(int(fy), 0, sh-1)
+		y1 := clampInt(y0+1, 0, sh-1)
+		wy := fy - float64(y0)
+		if wy < 0 {
+			wy = 0
+		}
+		for x := 0; x < w; x++ {
+			fx := (float64(x)+0.5)*xScale - 0.5
+			x0 := clampInt(int(fx), 0, sw-1)
+			x1 := clampInt(x0+1, 0, sw-1)
+			wx := fx - float64(x0)
+			if wx < 0 {
+				wx = 0
+			}
+
+			i00 := img.PixOffset(x0, y0)
+			i10 := img.PixOffset(x1, y0)
+			i01 := img.PixOffset(x0, y1)
+			i11 := img.PixOffset(x1, y1)
+			o := out.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := float64(img.Pix[i00+c])*(1-wx) + float64(img.Pix[i10+c])*wx
+				bottom := float64(img.Pix[i01+c])*(1-wx) + float64(img.Pix[i11+c])*wx
+				out.Pix[o+c] = clamp8(top*(1-wy) + bottom*wy)
+			}
+		}
+	}
+	return out
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,428 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/metrics"
+)
+
+var (
+	ErrClosed        = errors.New("jobs: queue closed")
+	ErrUserQueueFull = errors.New("jobs: too many queued jobs for user")
+	ErrQueueFull     = errors.New("jobs: queue is full")
+)
+
+var (
+	queuedGauge   = metrics.NewGauge("jobs_queued")
+	runningGauge  = metrics.NewGauge("jobs_running")
+	startedCount  = metrics.NewCounter("jobs_started")
+	canceledCount = metrics.NewCounter("jobs_canceled")
+	waitedCount   = metrics.NewCounter("jobs_saturated")
+)
+
+// saturationLogEvery rate-limits the "generation workers saturated" warning.
+const saturationLogEvery = 30 * time.Second
+
+type Job struct {
+	UserID int64
+	// Kind labels the job in logs ("preview", "image", ...).
+	Kind string
+	// Timeout overrides the queue default for this job.
+	Timeout time.Duration
+
+	Run func(ctx context.Context)
+	// OnPosition reports the 1-based place among waiting jobs whenever it
+	// changes; 0 means the job has started.
+	OnPosition func(pos int)
+	// OnCancel runs when the job is dropped from the queue before starting;
+	// cause is context.Canceled for CancelUser and ErrClosed for shutdown.
+	OnCancel func(cause error)
+}
+
+type Options struct {
+	// Workers bounds concurrently running jobs.
+	Workers int
+	// MaxPerUser bounds queued plus running jobs per user.
+	MaxPerUser int
+	// MaxQueued bounds waiting jobs across all users.
+	MaxQueued int
+	// Timeout is the default per-job budget, counted from start.
+	Timeout time.Duration
+	Logger  *slog.Logger
+}
+
+// Queue runs jobs with per-user FIFO order and round-robin fairness across
+// users, so one user's long batch cannot starve everyone else.
+type Queue struct {
+	mu         sync.Mutex
+	notifyMu   sync.Mutex
+	workers    int
+	maxPerUser int
+	maxQueued  int
+	timeout    time.Duration
+	logger     *slog.Logger
+	lastWarn   time.Time
+
+	nextID  uint64
+	ring    []int64 // users with waiting jobs, next to run first
+	pending map[int64][]*entry
+	running map[uint64]*entry
+	perUser map[int64]int
+	lastPos map[uint64]int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	id     uint64
+	job    Job
+	cancel context.CancelCauseFunc
+}
+
+func New(opts Options) *Queue {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	maxPerUser := opts.MaxPerUser
+	if maxPerUser < 1 {
+		maxPerUser = 3
+	}
+	maxQueued := opts.MaxQueued
+	if maxQueued < 1 {
+		maxQueued = 100
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 180 * time.Second
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return &Queue{
+		workers:    workers,
+		maxPerUser: maxPerUser,
+		maxQueued:  maxQueued,
+		timeout:    timeout,
+		logger:     logger,
+		pending:    make(map[int64][]*entry),
+		running:    make(map[uint64]*entry),
+		perUser:    make(map[int64]int),
+		lastPos:    make(map[uint64]int),
+	}
+}
+
+// Timeout is the default per-job budget.
+func (q *Queue) Timeout() time.Duration {
+	return q.timeout
+}
+
+// Submit enqueues job and returns its ID and 1-based waiting position; 0
+// means it started right away.
+func (q *Queue) Submit(job Job) (uint64, int, error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return 0, 0, ErrClosed
+	}
+	if q.perUser[job.UserID] >= q.maxPerUser {
+		q.mu.Unlock()
+		return 0, 0, ErrUserQueueFull
+	}
+	if len(q.running) >= q.workers && q.waitingLocked() >= q.maxQueued {
+		q.mu.Unlock()
+		return 0, 0, ErrQueueFull
+	}
+
+	q.nextID++
+	e := &entry{id: q.nextID, job: job}
+	q.perUser[job.UserID]++
+	if len(q.pending[job.UserID]) == 0 {
+		q.ring = append(q.ring, job.UserID)
+	}
+	q.pending[job.UserID] = append(q.pending[job.UserID], e)
+
+	started := q.dispatchLocked()
+	pos := q.positionLocked(e)
+	q.lastPos[e.id] = pos
+	warn := false
+	if pos > 0 {
+		waitedCount.Inc()
+		if time.Since(q.lastWarn) >= saturationLogEvery {
+			q.lastWarn = time.Now()
+			warn = true
+		}
+	}
+	waiting := q.waitingLocked()
+	q.mu.Unlock()
+
+	if warn {
+		q.logger.Warn("generation workers saturated", "workers", q.workers, "waiting", waiting)
+	}
+
+	q.logger.Info("job queued", "job_id", e.id, "kind", job.Kind, "user_id", job.UserID, "position", pos)
+	q.notify(started)
+	return e.id, pos, nil
+}
+
+// CancelUser cancels the user's running jobs and drops the waiting ones.
+func (q *Queue) CancelUser(userID int64) int {
+	q.mu.Lock()
+	var dropped []*entry
+	n := 0
+	for _, e := range q.running {
+		if e.job.UserID == userID {
+			e.cancel(context.Canceled)
+			n++
+		}
+	}
+	if waiting := q.pending[userID]; len(waiting) > 0 {
+		dropped = waiting
+		delete(q.pending, userID)
+		q.removeFromRingLocked(userID)
+		q.perUser[userID] -= len(waiting)
+		if q.perUser[userID] <= 0 {
+			delete(q.perUser, userID)
+		}
+		for _, e := range waiting {
+			delete(q.lastPos, e.id)
+		}
+		n += len(waiting)
+	}
+	q.mu.Unlock()
+
+	canceledCount.Add(int64(n))
+	for _, e := range dropped {
+		if e.job.OnCancel != nil {
+			e.job.OnCancel(context.Canceled)
+		}
+	}
+	q.notify(nil)
+	return n
+}
+
+// Close stops accepting jobs, cancels running ones and drops the queue.
+func (q *Queue) Close() {
+	q.Shutdown(0)
+}
+
+// Shutdown stops accepting jobs and drops the waiting ones, then gives
+// running jobs up to grace to finish before canceling them with cause
+// ErrClosed. It returns the jobs that did not complete, for callers that
+// can persist them.
+func (q *Queue) Shutdown(grace time.Duration) []Job {
+	q.mu.Lock()
+	q.closed = true
+	var dropped []*entry
+	for _, userID := range q.ring {
+		dropped = append(dropped, q.pending[userID]...)
+	}
+	q.ring = nil
+	q.pending = make(map[int64][]*entry)
+	q.lastPos = make(map[uint64]int)
+	q.updateGaugesLocked()
+	q.mu.Unlock()
+
+	unfinished := make([]Job, 0, len(dropped))
+	for _, e := range dropped {
+		unfinished = append(unfinished, e.job)
+		if e.job.OnCancel != nil {
+			e.job.OnCancel(ErrClosed)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	if grace > 0 {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return unfinished
+		case <-timer.C:
+		}
+	}
+
+	q.mu.Lock()
+	for _, e := range q.running {
+		e.cancel(ErrClosed)
+		unfinished = append(unfinished, e.job)
+	}
+	q.mu.Unlock()
+	<-done
+	return unfinished
+}
+
+// dispatchLocked starts waiting jobs while workers are free, taking one job
+// per user in ring order.
+func (q *Queue) dispatchLocked() []*entry {
+	var started []*entry
+	for len(q.running) < q.workers && len(q.ring) > 0 {
+		userID := q.ring[0]
+		q.ring = q.ring[1:]
+
+		waiting := q.pending[userID]
+		e := waiting[0]
+		if len(waiting) > 1 {
+			q.pending[userID] = waiting[1:]
+			q.ring = append(q.ring, userID)
+		} else {
+			delete(q.pending, userID)
+		}
+
+		// The timeout context sits under a cause context so Run can tell
+		// /cancel from shutdown through context.Cause.
+		base, cancel := context.WithCancelCause(context.Background())
+		ctx, stop := context.WithTimeout(base, q.jobTimeout(e.job))
+		e.cancel = func(cause error) {
+			cancel(cause)
+			stop()
+		}
+		q.running[e.id] = e
+		delete(q.lastPos, e.id)
+		started = append(started, e)
+
+		q.wg.Add(1)
+		go q.run(ctx, e)
+	}
+	q.updateGaugesLocked()
+	return started
+}
+
+func (q *Queue) run(ctx context.Context, e *entry) {
+	defer q.wg.Done()
+	defer e.cancel(nil)
+
+	startedCount.Inc()
+	began := time.Now()
+	e.job.Run(ctx)
+	q.logger.Info("job finished", "job_id", e.id, "kind", e.job.Kind, "user_id", e.job.UserID, "duration", time.Since(began), "err", ctx.Err())
+
+	q.mu.Lock()
+	delete(q.running, e.id)
+	q.perUser[e.job.UserID]--
+	if q.perUser[e.job.UserID] <= 0 {
+		delete(q.perUser, e.job.UserID)
+	}
+	var started []*entry
+	if !q.closed {
+		started = q.dispatchLocked()
+	}
+	q.updateGaugesLocked()
+	q.mu.Unlock()
+
+	q.notify(started)
+}
+
+// notify tells newly started jobs they are running and waiting jobs whose
+// position moved. Passes are serialized so status edits arrive in order.
+func (q *Queue) notify(started []*entry) {
+	q.notifyMu.Lock()
+	defer q.notifyMu.Unlock()
+
+	for _, e := range started {
+		if e.job.OnPosition != nil {
+			e.job.OnPosition(0)
+		}
+	}
+
+	type move struct {
+		fn  func(int)
+		pos int
+	}
+	var moves []move
+	q.mu.Lock()
+	for _, userID := range q.ring {
+		for _, e := range q.pending[userID] {
+			pos := q.positionLocked(e)
+			if q.lastPos[e.id] == pos {
+				continue
+			}
+			q.lastPos[e.id] = pos
+			if e.job.OnPosition != nil {
+				moves = append(moves, move{fn: e.job.OnPosition, pos: pos})
+			}
+		}
+	}
+	q.mu.Unlock()
+
+	for _, m := range moves {
+		m.fn(m.pos)
+	}
+}
+
+// positionLocked simulates round-robin dispatch: a job k-th in its user's
+// FIFO runs after up to k+1 jobs of users ahead in the ring and up to k of
+// users behind it.
+func (q *Queue) positionLocked(target *entry) int {
+	if _, ok := q.running[target.id]; ok {
+		return 0
+	}
+	owner := target.job.UserID
+	waiting := q.pending[owner]
+	k := -1
+	for i, e := range waiting {
+		if e.id == target.id {
+			k = i
+			break
+		}
+	}
+	if k < 0 {
+		return 0
+	}
+
+	pos := 1
+	ahead := true
+	for _, userID := range q.ring {
+		if userID == owner {
+			ahead = false
+			pos += k
+			continue
+		}
+		limit := k
+		if ahead {
+			limit = k + 1
+		}
+		pos += min(len(q.pending[userID]), limit)
+	}
+	return pos
+}
+
+func (q *Queue) removeFromRingLocked(userID int64) {
+	for i, id := range q.ring {
+		if id == userID {
+			q.ring = append(q.ring[:i], q.ring[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *Queue) waitingLocked() int {
+	waiting := 0
+	for _, list := range q.pending {
+		waiting += len(list)
+	}
+	return waiting
+}
+
+func (q *Queue) updateGaugesLocked() {
+	queuedGauge.Set(int64(q.waitingLocked()))
+	runningGauge.Set(int64(len(q.running)))
+}
+
+func (q *Queue) jobTimeout(job Job) time.Duration {
+	if job.Timeout > 0 {
+		return job.Timeout
+	}
+	return q.timeout
+}
@@ -0,0 +1,197 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gate blocks a job until it is closed or the job is canceled; cause
+// receives the job's context.Cause.
+type gate struct {
+	open  chan struct{}
+	cause chan error
+}
+
+func newGate() *gate {
+	return &gate{open: make(chan struct{}), cause: make(chan error, 1)}
+}
+
+func (g *gate) job(userID int64) Job {
+	return Job{UserID: userID, Run: func(ctx context.Context) {
+		select {
+		case <-g.open:
+			g.cause <- nil
+		case <-ctx.Done():
+			g.cause <- context.Cause(ctx)
+		}
+	}}
+}
+
+// recorder logs the order jobs ran in.
+type recorder struct {
+	mu  sync.Mutex
+	wg  sync.WaitGroup
+	ran []string
+}
+
+func (r *recorder) job(userID int64, label string) Job {
+	r.wg.Add(1)
+	return Job{UserID: userID, Run: func(context.Context) {
+		defer r.wg.Done()
+		r.mu.Lock()
+		r.ran = append(r.ran, label)
+		r.mu.Unlock()
+	}}
+}
+
+func (r *recorder) wait(t *testing.T) []string {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs did not finish")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+func TestQueueRoundRobin(t *testing.T) {
+	q := New(Options{Workers: 1, MaxPerUser: 10})
+	defer q.Close()
+
+	g := newGate()
+	if _, pos, err := q.Submit(g.job(0)); err != nil || pos != 0 {
+		t.Fatalf("blocker: position %d, err %v", pos, err)
+	}
+
+	var rec recorder
+	submits := []struct {
+		user  int64
+		label string
+		pos   int
+	}{
+		{user: 1, label: "a1", pos: 1},
+		{user: 1, label: "a2", pos: 2},
+		{user: 1, label: "a3", pos: 3},
+		{user: 2, label: "b1", pos: 2},
+		{user: 2, label: "b2", pos: 4},
+		{user: 3, label: "c1", pos: 3},
+	}
+	for _, s := range submits {
+		_, pos, err := q.Submit(rec.job(s.user, s.label))
+		if err != nil {
+			t.Fatalf("Submit %s: %v", s.label, err)
+		}
+		if pos != s.pos {
+			t.Errorf("Submit %s: position %d, want %d", s.label, pos, s.pos)
+		}
+	}
+
+	close(g.open)
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if got := rec.wait(t); !slices.Equal(got, want) {
+		t.Fatalf("run order %v, want %v", got, want)
+	}
+}
+
+func TestQueueCancelUser(t *testing.T) {
+	q := New(Options{Workers: 1, MaxPerUser: 10})
+	defer q.Close()
+
+	g := newGate()
+	if _, _, err := q.Submit(g.job(1)); err != nil {
+		t.Fatal(err)
+	}
+	var dropped []error
+	waiting := Job{
+		UserID:   1,
+		Run:      func(context.Context) { t.Error("canceled job ran") },
+		OnCancel: func(cause error) { dropped = append(dropped, cause) },
+	}
+	if _, _, err := q.Submit(waiting); err != nil {
+		t.Fatal(err)
+	}
+	var rec recorder
+	if _, _, err := q.Submit(rec.job(2, "other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := q.CancelUser(1); n != 2 {
+		t.Fatalf("CancelUser = %d, want 2", n)
+	}
+	if cause := <-g.cause; !errors.Is(cause, context.Canceled) {
+		t.Fatalf("running job cause = %v, want context.Canceled", cause)
+	}
+	if len(dropped) != 1 || !errors.Is(dropped[0], context.Canceled) {
+		t.Fatalf("OnCancel causes = %v, want [context.Canceled]", dropped)
+	}
+	if got := rec.wait(t); !slices.Equal(got, []string{"other"}) {
+		t.Fatalf("other user's jobs ran %v", got)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		users []int64
+		want  error
+	}{
+		{name: "per user", opts: Options{Workers: 1, MaxPerUser: 2}, users: []int64{1, 1, 1}, want: ErrUserQueueFull},
+		{name: "queue", opts: Options{Workers: 1, MaxPerUser: 5, MaxQueued: 1}, users: []int64{1, 2, 3}, want: ErrQueueFull},
+		{name: "within limits", opts: Options{Workers: 1, MaxPerUser: 2, MaxQueued: 2}, users: []int64{1, 1, 2}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New(tt.opts)
+			defer q.Close()
+
+			g := newGate()
+			var err error
+			for _, user := range tt.users {
+				_, _, err = q.Submit(g.job(user))
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("last Submit error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueShutdown(t *testing.T) {
+	q := New(Options{Workers: 1, MaxPerUser: 10})
+
+	g := newGate()
+	if _, _, err := q.Submit(g.job(1)); err != nil {
+		t.Fatal(err)
+	}
+	var dropped error
+	waiting := Job{UserID: 2, Run: func(context.Context) {}, OnCancel: func(cause error) { dropped = cause }}
+	if _, _, err := q.Submit(waiting); err != nil {
+		t.Fatal(err)
+	}
+
+	unfinished := q.Shutdown(10 * time.Millisecond)
+	if len(unfinished) != 2 {
+		t.Fatalf("Shutdown returned %d unfinished jobs, want 2", len(unfinished))
+	}
+	if !errors.Is(dropped, ErrClosed) {
+		t.Fatalf("waiting job OnCancel cause = %v, want ErrClosed", dropped)
+	}
+	if cause := <-g.cause; !errors.Is(cause, ErrClosed) {
+		t.Fatalf("running job cause = %v, want ErrClosed", cause)
+	}
+	if _, _, err := q.Submit(waiting); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Submit after shutdown = %v, want ErrClosed", err)
+	}
+}
@@ -107,6 +107,25 @@ func (a *Aggregator) flush(key string) {
 	}
 }
 
+// FlushAll hands every pending group to OnFlush now instead of waiting for
+// its debounce, e.g. on shutdown. It returns how many groups were flushed.
+func (a *Aggregator) FlushAll() int {
+	a.mu.Lock()
+	keys := make([]string, 0, len(a.groups))
+	for key, pg := range a.groups {
+		if pg.timer != nil {
+			pg.timer.Stop()
+		}
+		keys = append(keys, key)
+	}
+	a.mu.Unlock()
+
+	for _, key := range keys {
+		a.flush(key)
+	}
+	return len(keys)
+}
+
 func makeKey(chatID int64, mediaGroupID string) string {
 	return fmt.Sprintf("%d:%s", chatID, mediaGroupID)
 }
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// All counters are published under one expvar map so /debug/vars shows them together.
+var root = expvar.NewMap("pro_banana")
+
+type Counter struct {
+	v *expvar.Int
+}
+
+// NewCounter registers a counter; names must be unique per process.
+func NewCounter(name string) *Counter {
+	v := new(expvar.Int)
+	root.Set(name, v)
+	return &Counter{v: v}
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() int64 {
+	return c.v.Value()
+}
+
+type Gauge struct {
+	v *expvar.Int
+}
+
+func NewGauge(name string) *Gauge {
+	v := new(expvar.Int)
+	root.Set(name, v)
+	return &Gauge{v: v}
+}
+
+func (g *Gauge) Set(n int64) {
+	g.v.Set(n)
+}
+
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.v.Value()
+}
+
+// Handler serves all expvar variables as JSON (mount at /debug/vars).
+func Handler() http.Handler {
+	return expvar.Handler()
+}
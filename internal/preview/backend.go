@@ -0,0 +1,152 @@
+package preview
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+// Backend persists wizard state per (chat, user). Store reads it on a cache
+// miss while Flush may be writing, so implementations must be safe for
+// concurrent use.
+type Backend interface {
+	Load(key StateKey) (UIState, bool, error)
+	Save(key StateKey, st UIState) error
+	Delete(key StateKey) error
+	ForEach(fn func(key StateKey, st UIState) error) error
+}
+
+// errSkippedRecords reports records ForEach could not read; the others were
+// still visited.
+var errSkippedRecords = errors.New("preview: unreadable state records dropped")
+
+// MemoryBackend keeps state in a map; it is lost on restart.
+type MemoryBackend struct {
+	mu sync.Mutex
+	m  map[StateKey]UIState
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{m: make(map[StateKey]UIState)}
+}
+
+func (b *MemoryBackend) Load(key StateKey) (UIState, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.m[key]
+	if !ok {
+		return UIState{}, false, nil
+	}
+	return st.clone(), true, nil
+}
+
+func (b *MemoryBackend) Save(key StateKey, st UIState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.m[key] = st.clone()
+	return nil
+}
+
+func (b *MemoryBackend) Delete(key StateKey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.m, key)
+	return nil
+}
+
+func (b *MemoryBackend) ForEach(fn func(key StateKey, st UIState) error) error {
+	b.mu.Lock()
+	snapshot := make(map[StateKey]UIState, len(b.m))
+	for k, st := range b.m {
+		snapshot[k] = st.clone()
+	}
+	b.mu.Unlock()
+
+	for k, st := range snapshot {
+		if err := fn(k, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const boltBucket = "preview_state"
+
+// BoltBackend stores state as JSON in the embedded database so open wizards
+// survive restarts.
+type BoltBackend struct {
+	bucket *storage.Bucket
+}
+
+func NewBoltBackend(db *storage.DB) (*BoltBackend, error) {
+	bucket, err := db.Bucket(boltBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltBackend{bucket: bucket}, nil
+}
+
+func (b *BoltBackend) Load(key StateKey) (UIState, bool, error) {
+	var st UIState
+	ok, err := b.bucket.Get(key.String(), &st)
+	if err != nil || !ok {
+		return UIState{}, false, err
+	}
+	return st, true, nil
+}
+
+func (b *BoltBackend) Save(key StateKey, st UIState) error {
+	return b.bucket.Put(key.String(), st)
+}
+
+func (b *BoltBackend) Delete(key StateKey) error {
+	return b.bucket.Delete(key.String())
+}
+
+// ForEach skips records it cannot parse or decode so one bad record does not
+// stop the sweep for good; they are deleted afterwards and reported with
+// errSkippedRecords.
+func (b *BoltBackend) ForEach(fn func(key StateKey, st UIState) error) error {
+	var bad []string
+	var errs []error
+	err := b.bucket.ForEach(func(raw string, data []byte) error {
+		key, err := parseStateKey(raw)
+		if err != nil {
+			bad, errs = append(bad, raw), append(errs, err)
+			return nil
+		}
+		var st UIState
+		if err := json.Unmarshal(data, &st); err != nil {
+			bad, errs = append(bad, raw), append(errs, fmt.Errorf("decode preview state %s: %w", raw, err))
+			return nil
+		}
+		return fn(key, st)
+	})
+	if err != nil || len(bad) == 0 {
+		return err
+	}
+	for _, raw := range bad {
+		if err := b.bucket.Delete(raw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return fmt.Errorf("%w: %w", errSkippedRecords, errors.Join(errs...))
+}
+
+func parseStateKey(raw string) (StateKey, error) {
+	var k StateKey
+	if _, err := fmt.Sscanf(raw, "%d:%d", &k.ChatID, &k.UserID); err != nil {
+		return StateKey{}, fmt.Errorf("parse preview state key %q: %w", raw, err)
+	}
+	return k, nil
+}
+
+func (k StateKey) String() string {
+	return fmt.Sprintf("%d:%d", k.ChatID, k.UserID)
+}
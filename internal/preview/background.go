@@ -0,0 +1,207 @@
+package preview
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Background struct {
+	Kind      string   // "solid" | "gradient" | "scene"
+	Colors    []string // normalized "#RRGGBB"
+	Direction string   // gradient: "vertical" | "horizontal" | "radial" | "diagonal"
+	Scene     string
+	Exact     bool // enforce the exact solid colour locally after generation
+}
+
+var namedColors = map[string]string{
+	"white":   "#FFFFFF",
+	"oq":      "#FFFFFF",
+	"black":   "#000000",
+	"qora":    "#000000",
+	"gray":    "#808080",
+	"grey":    "#808080",
+	"kulrang": "#808080",
+	"red":     "#FF0000",
+	"qizil":   "#FF0000",
+	"green":   "#00A651",
+	"yashil":  "#00A651",
+	"blue":    "#0057FF",
+	"ko'k":    "#0057FF",
+	"yellow":  "#FFD500",
+	"sariq":   "#FFD500",
+	"beige":   "#F5F0E6",
+	"cream":   "#FFFDD0",
+	"pink":    "#FFC0CB",
+	"pushti":  "#FFC0CB",
+}
+
+var hexColorRegex = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// ParseBackground parses a /bg argument: a hex/named colour ("#fff", "white"),
+// a gradient ("gradient #fff #000 radial" or "#fff-#000"), or free scene text.
+// The "exact" token enables local colour enforcement for solid colours.
+func ParseBackground(raw string) (Background, error) {
+	var bg Background
+
+	var rest []string
+	for _, tok := range strings.Fields(strings.TrimSpace(raw)) {
+		switch strings.ToLower(tok) {
+		case "exact", "aniq":
+			bg.Exact = true
+			continue
+		}
+		rest = append(rest, tok)
+	}
+	if len(rest) == 0 {
+		return Background{}, errors.New("background spec is empty")
+	}
+
+	lower := make([]string, len(rest))
+	for i, tok := range rest {
+		lower[i] = strings.ToLower(tok)
+	}
+
+	if len(rest) == 1 {
+		if hex, ok := normalizeColor(lower[0]); ok {
+			bg.Kind = "solid"
+			bg.Colors = []string{hex}
+			return bg, nil
+		}
+		if parts := strings.Split(lower[0], "-"); len(parts) >= 2 {
+			if colors, ok := normalizeColors(parts); ok {
+				bg.Kind = "gradient"
+				bg.Colors = colors
+				bg.Direction = "vertical"
+				bg.Exact = false
+				return bg, nil
+			}
+		}
+	}
+
+	if lower[0] == "gradient" || lower[0] == "gradiyent" {
+		var colors []string
+		direction := "vertical"
+		for _, tok := range lower[1:] {
+			switch tok {
+			case "vertical", "horizontal", "radial", "diagonal":
+				direction = tok
+				continue
+			}
+			hex, ok := normalizeColor(tok)
+			if !ok {
+				return Background{}, fmt.Errorf("unknown gradient colour %q", tok)
+			}
+			colors = append(colors, hex)
+		}
+		if len(colors) < 2 {
+			return Background{}, errors.New("gradient needs at least 2 colours")
+		}
+		bg.Kind = "gradient"
+		bg.Colors = colors
+		bg.Direction = direction
+		bg.Exact = false
+		return bg, nil
+	}
+
+	bg.Kind = "scene"
+	bg.Scene = strings.Join(rest, " ")
+	bg.Exact = false
+	return bg, nil
+}
+
+func (bg Background) String() string {
+	switch bg.Kind {
+	case "solid":
+		return bg.Colors[0]
+	case "gradient":
+		return strings.Join(bg.Colors, "→") + " (" + bg.Direction + ")"
+	default:
+		return bg.Scene
+	}
+}
+
+// BuildBackgroundPrompt builds an identity-locked edit prompt that replaces only the background.
+func BuildBackgroundPrompt(bg Background) string {
+	var b strings.Builder
+	b.Grow(2048)
+
+	b.WriteString("TASK: Background replacement (image edit). Replace ONLY the background of the attached photo.\n\n")
+
+	b.WriteString("IDENTITY LOCK (STRICT):\n")
+	for _, line := range []string{
+		"The product must stay the exact same object: shape, proportions, materials, colors, label/branding unchanged.",
+		"Keep the product's position, scale, angle, and crop in the frame exactly as in the original.",
+		"Do not relight or recolor the product beyond subtle edge blending with the new background.",
+		"Do not add, remove, or redesign any product parts; do not add text, logos, or watermarks.",
+		"Keep the original image aspect ratio and framing.",
+	} {
+		b.WriteString("- " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("NEW BACKGROUND:\n")
+	switch bg.Kind {
+	case "solid":
+		b.WriteString(fmt.Sprintf("- Perfectly uniform solid colour %s filling the entire background.\n", bg.Colors[0]))
+		b.WriteString("- No gradient, no texture, no vignette, no floor line.\n")
+		if bg.Exact {
+			b.WriteString("- No cast shadows or reflections on the background.\n")
+		} else {
+			b.WriteString("- A soft, subtle contact shadow under the product is allowed.\n")
+		}
+	case "gradient":
+		b.WriteString(fmt.Sprintf("- Smooth %s gradient through these colours in order: %s.\n", bg.Direction, strings.Join(bg.Colors, ", ")))
+		b.WriteString("- Clean studio gradient, no banding, no texture.\n")
+		b.WriteString("- A soft, subtle contact shadow under the product is allowed.\n")
+	default:
+		b.WriteString("- Scene: " + bg.Scene + "\n")
+		b.WriteString("- Photorealistic, premium commercial look; match perspective and lighting direction to the product.\n")
+		b.WriteString("- The product stays the sharp hero; the scene supports it and never covers it.\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("NEGATIVE PROMPT (avoid):\n")
+	for _, line := range []string{
+		"changed product", "moved product", "resized product", "distorted product", "incorrect logo",
+		"invented branding", "text overlays", "watermark", "border", "frame", "extra objects covering the product",
+	} {
+		b.WriteString("- " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("OUTPUT RULES:\n")
+	b.WriteString("- Return exactly 1 image.\n")
+	b.WriteString("- Images only. No text, no JSON.\n")
+
+	return strings.TrimSpace(b.String())
+}
+
+func normalizeColor(value string) (string, bool) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if hex, ok := namedColors[value]; ok {
+		return hex, true
+	}
+	m := hexColorRegex.FindStringSubmatch(value)
+	if m == nil {
+		return "", false
+	}
+	hex := m[1]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	return "#" + strings.ToUpper(hex), true
+}
+
+func normalizeColors(values []string) ([]string, bool) {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		hex, ok := normalizeColor(v)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, hex)
+	}
+	return out, true
+}
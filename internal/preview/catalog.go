@@ -66,3 +66,11 @@ func FrameTemplates() []FrameTemplate {
 	}
 	return out
 }
+
+func BundleFrameTemplates() []FrameTemplate {
+	out := make([]FrameTemplate, 0, len(bundleFrameTemplates))
+	for _, t := range bundleFrameTemplates {
+		out = append(out, cloneFrameTemplate(t))
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package preview
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Share codes pack Options into a short URL-safe string for "/preview
+// code=...", t.me deep links and the web UI:
+//
+//	[version][flags][layout]{[len uvarint][string]} x4 [frame index]...
+//
+// flags holds human usage (bit 0), bundle (bit 1) and the mode (bits 2-3);
+// layout holds the grid preset index (low nibble) and vertical count (high
+// nibble). The strings are aspect ratio, category, style and note. Frame
+// indices point into the bundle or single-product set, following the bundle
+// flag, and are omitted when the default frames are selected.
+const codeVersion = 1
+
+// maxCodeNote caps the note carried by a code.
+const maxCodeNote = 500
+
+var ErrInvalidCode = errors.New("preview: invalid share code")
+
+var (
+	codeModes       = []string{"grid", "vertical", "cutout"}
+	codeGridPresets = []string{"1x1", "2x2", "3x2", "3x3"}
+)
+
+// EncodeCode returns the share code for opts; photos and delivery are not
+// part of it.
+func EncodeCode(opts Options) string {
+	var flags byte
+	if opts.HumanUsage {
+		flags |= 1
+	}
+	if opts.Bundle {
+		flags |= 2
+	}
+	mode := max(slices.Index(codeModes, strings.ToLower(opts.Mode)), 0)
+	flags |= byte(mode) << 2
+
+	grid := slices.Index(codeGridPresets, opts.GridPreset)
+	if grid < 0 {
+		grid = len(codeGridPresets) - 1 // 3x3, the default layout
+	}
+	vertical := verticalCounts[opts.VerticalCount]
+	raw := []byte{codeVersion, flags, byte(grid) | byte(vertical)<<4}
+
+	note := opts.Custom
+	if len(note) > maxCodeNote {
+		note = strings.ToValidUTF8(note[:maxCodeNote], "")
+	}
+	for _, s := range []string{opts.AspectRatio, opts.ProductType, opts.VisualStyle, note} {
+		raw = binary.AppendUvarint(raw, uint64(len(s)))
+		raw = append(raw, s...)
+	}
+
+	defaults := opts
+	defaults.FrameIDs, defaults.BundleSize = nil, MaxBundleProducts
+	if ids := normalizeFrameIDs(opts.FrameIDs); len(ids) > 0 && !slices.Equal(ids, OutputFrameIDs(defaults)) {
+		set := frameSet(opts.Bundle)
+		for _, id := range ids {
+			if idx := slices.IndexFunc(set, func(t FrameTemplate) bool { return t.ID == id }); idx >= 0 {
+				raw = append(raw, byte(idx))
+			}
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCode restores the Options a share code was made from. Categories and
+// styles this build does not know are dropped.
+func DecodeCode(code string) (Options, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(code))
+	if err != nil || len(raw) < 3 || raw[0] != codeVersion {
+		return Options{}, ErrInvalidCode
+	}
+
+	flags, layout := raw[1], raw[2]
+	mode := int(flags >> 2 & 3)
+	grid := int(layout & 0x0f)
+	vertical := int(layout >> 4)
+	if mode >= len(codeModes) || grid >= len(codeGridPresets) || vertical > 4 {
+		return Options{}, ErrInvalidCode
+	}
+	opts := Options{
+		Mode:          codeModes[mode],
+		GridPreset:    codeGridPresets[grid],
+		VerticalCount: "4",
+		HumanUsage:    flags&1 != 0,
+		Bundle:        flags&2 != 0,
+	}
+	if vertical > 0 {
+		opts.VerticalCount = string(rune('0' + vertical))
+	}
+
+	rest := raw[3:]
+	fields := make([]string, 4)
+	for i := range fields {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return Options{}, ErrInvalidCode
+		}
+		fields[i] = string(rest[n : n+int(size)])
+		rest = rest[n+int(size):]
+	}
+	opts.AspectRatio = normalizeAspectRatio(fields[0])
+	if _, ok := productTypes[fields[1]]; ok {
+		opts.ProductType = fields[1]
+	}
+	if _, ok := visualPresets[fields[2]]; ok {
+		opts.VisualStyle = fields[2]
+	}
+	opts.Custom = strings.TrimSpace(fields[3])
+
+	set := frameSet(opts.Bundle)
+	for _, idx := range rest {
+		if int(idx) >= len(set) {
+			return Options{}, ErrInvalidCode
+		}
+		opts.FrameIDs = append(opts.FrameIDs, set[idx].ID)
+	}
+	return opts, nil
+}
+
+// CodeArg returns the value of a "code=" token in command arguments, with
+// its case kept.
+func CodeArg(args string) string {
+	for _, tok := range strings.Fields(args) {
+		if len(tok) > len("code=") && strings.EqualFold(tok[:len("code=")], "code=") {
+			return tok[len("code="):]
+		}
+	}
+	return ""
+}
+
+func normalizeFrameIDs(ids []string) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.ToLower(strings.TrimSpace(id)); id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package preview
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Options
+		want Options
+	}{
+		{
+			name: "defaults",
+			in:   Options{},
+			want: Options{Mode: "grid", GridPreset: "3x3", VerticalCount: "4"},
+		},
+		{
+			name: "grid with style and note",
+			in: Options{
+				Mode: "grid", GridPreset: "2x2", AspectRatio: "4:5",
+				ProductType: "beauty", VisualStyle: "dark_premium",
+				HumanUsage: true, Custom: "pastel fon, suv tomchilari",
+			},
+			want: Options{
+				Mode: "grid", GridPreset: "2x2", VerticalCount: "4", AspectRatio: "4:5",
+				ProductType: "beauty", VisualStyle: "dark_premium",
+				HumanUsage: true, Custom: "pastel fon, suv tomchilari",
+			},
+		},
+		{
+			name: "vertical",
+			in:   Options{Mode: "vertical", VerticalCount: "2"},
+			want: Options{Mode: "vertical", GridPreset: "3x3", VerticalCount: "2"},
+		},
+		{
+			name: "cutout",
+			in:   Options{Mode: "cutout"},
+			want: Options{Mode: "cutout", GridPreset: "3x3", VerticalCount: "4"},
+		},
+		{
+			name: "custom frames",
+			in:   Options{Mode: "grid", GridPreset: "1x1", FrameIDs: []string{"surreal_fusion"}},
+			want: Options{Mode: "grid", GridPreset: "1x1", VerticalCount: "4", FrameIDs: []string{"surreal_fusion"}},
+		},
+		{
+			name: "bundle frames",
+			in:   Options{Mode: "grid", GridPreset: "1x1", Bundle: true, FrameIDs: []string{"bundle_gift_box"}},
+			want: Options{Mode: "grid", GridPreset: "1x1", VerticalCount: "4", Bundle: true, FrameIDs: []string{"bundle_gift_box"}},
+		},
+		{
+			name: "default frames omitted",
+			in:   Options{Mode: "grid", GridPreset: "2x2", FrameIDs: OutputFrameIDs(Options{Mode: "grid", GridPreset: "2x2"})},
+			want: Options{Mode: "grid", GridPreset: "2x2", VerticalCount: "4"},
+		},
+		{
+			name: "default bundle frames omitted",
+			in: Options{
+				Mode: "grid", GridPreset: "2x2", Bundle: true,
+				FrameIDs: OutputFrameIDs(Options{Mode: "grid", GridPreset: "2x2", Bundle: true, BundleSize: 2}),
+			},
+			want: Options{Mode: "grid", GridPreset: "2x2", VerticalCount: "4", Bundle: true},
+		},
+		{
+			name: "unknown category and style dropped",
+			in:   Options{ProductType: "spaceships", VisualStyle: "vaporwave", AspectRatio: "wide"},
+			want: Options{Mode: "grid", GridPreset: "3x3", VerticalCount: "4"},
+		},
+		{
+			name: "long note truncated",
+			in:   Options{Custom: strings.Repeat("a", maxCodeNote+100)},
+			want: Options{Mode: "grid", GridPreset: "3x3", VerticalCount: "4", Custom: strings.Repeat("a", maxCodeNote)},
+		},
+		{
+			name: "photos and delivery are not shared",
+			in:   Options{BundleSize: 3, Batch: true, Variation: true, Preset: "mine"},
+			want: Options{Mode: "grid", GridPreset: "3x3", VerticalCount: "4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := EncodeCode(tt.in)
+			got, err := DecodeCode(code)
+			if err != nil {
+				t.Fatalf("DecodeCode(%q): %v", code, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("round trip = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCodeMalformed(t *testing.T) {
+	valid := func(mutate func(raw []byte) []byte) string {
+		raw, _ := base64.RawURLEncoding.DecodeString(EncodeCode(Options{Mode: "grid", GridPreset: "1x1", Custom: "note"}))
+		return base64.RawURLEncoding.EncodeToString(mutate(raw))
+	}
+	tests := []struct {
+		name string
+		code string
+	}{
+		{name: "empty", code: ""},
+		{name: "bad base64", code: "!!!"},
+		{name: "short", code: base64.RawURLEncoding.EncodeToString([]byte{codeVersion, 0})},
+		{name: "wrong version", code: valid(func(raw []byte) []byte { raw[0] = codeVersion + 1; return raw })},
+		{name: "bad mode", code: valid(func(raw []byte) []byte { raw[1] |= 3 << 2; return raw })},
+		{name: "bad grid", code: valid(func(raw []byte) []byte { raw[2] = raw[2]&0xf0 | 0x0f; return raw })},
+		{name: "bad vertical", code: valid(func(raw []byte) []byte { raw[2] = raw[2]&0x0f | 5<<4; return raw })},
+		{name: "missing strings", code: valid(func(raw []byte) []byte { return raw[:3] })},
+		{name: "string overrun", code: valid(func(raw []byte) []byte { return raw[:len(raw)-1] })},
+		{name: "frame out of range", code: valid(func(raw []byte) []byte { return append(raw, byte(len(frameTemplates))) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := DecodeCode(tt.code); !errors.Is(err, ErrInvalidCode) {
+				t.Fatalf("DecodeCode(%q) = %+v, %v; want ErrInvalidCode", tt.code, got, err)
+			}
+		})
+	}
+}
+
+func TestCodeArg(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: "code=AbC-_1", want: "AbC-_1"},
+		{args: "3x3 CODE=xYz", want: "xYz"},
+		{args: "code=", want: ""},
+		{args: "style=gold", want: ""},
+		{args: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := CodeArg(tt.args); got != tt.want {
+			t.Errorf("CodeArg(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+const historyBucket = "preview_history"
+
+// Run is one delivered preview generation.
+type Run struct {
+	ID            string
+	ChatID        int64
+	UserID        int64
+	Options       Options
+	PromptVersion string
+	// InputFileIDs and InputUniqueIDs identify the source product photos.
+	InputFileIDs   []string
+	InputUniqueIDs []string
+	// OutputFileIDs are the Telegram file IDs of the delivered images; they
+	// can be resent without uploading again.
+	OutputFileIDs []string
+	// AsDocuments records that outputs were delivered as files, which
+	// Telegram requires when resending them.
+	AsDocuments bool
+	CreatedAt   time.Time
+}
+
+type HistoryOptions struct {
+	// DB persists runs; nil keeps them in memory.
+	DB *storage.DB
+	// MaxPerUser keeps only the newest runs of each user; defaults to 50.
+	MaxPerUser int
+	Logger     *slog.Logger
+}
+
+// History keeps each user's recent preview runs, newest first.
+type History struct {
+	mu     sync.Mutex
+	db     *storage.DB
+	mem    map[int64][]Run
+	keep   int
+	logger *slog.Logger
+}
+
+func NewHistory(opts HistoryOptions) (*History, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	keep := opts.MaxPerUser
+	if keep < 1 {
+		keep = 50
+	}
+
+	h := &History{db: opts.DB, keep: keep, logger: logger}
+	if opts.DB == nil {
+		h.mem = make(map[int64][]Run)
+		return h, nil
+	}
+	if _, err := opts.DB.Bucket(historyBucket); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Record assigns the run an ID and time, stores it and drops the user's
+// runs beyond the limit.
+func (h *History) Record(run Run) (Run, error) {
+	id, err := newRecipeID()
+	if err != nil {
+		return Run{}, err
+	}
+	run.ID = id
+	run.CreatedAt = time.Now().UTC()
+	if run.PromptVersion == "" {
+		run.PromptVersion = PromptVersion
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.db == nil {
+		runs := append(h.mem[run.UserID], run)
+		if len(runs) > h.keep {
+			runs = runs[len(runs)-h.keep:]
+		}
+		h.mem[run.UserID] = runs
+		return run, nil
+	}
+
+	prefix := historyPrefix(run.UserID)
+	err = h.db.Update(func(tx *storage.Tx) error {
+		key := fmt.Sprintf("%s%020d/%s", prefix, run.CreatedAt.UnixNano(), run.ID)
+		if err := tx.Put(historyBucket, key, run); err != nil {
+			return err
+		}
+
+		var keys []string
+		err := tx.Scan(historyBucket, prefix, func(key string, _ []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for len(keys) > h.keep {
+			if err := tx.Delete(historyBucket, keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+	return run, err
+}
+
+// List returns a page of the user's runs, newest first, and how many runs
+// there are in total.
+func (h *History) List(userID int64, offset, limit int) ([]Run, int) {
+	runs := h.all(userID)
+	if offset < 0 {
+		offset = 0
+	}
+	out := make([]Run, 0, limit)
+	for i := len(runs) - 1 - offset; i >= 0 && len(out) < limit; i-- {
+		out = append(out, runs[i])
+	}
+	return out, len(runs)
+}
+
+// Get returns one of the user's runs.
+func (h *History) Get(userID int64, id string) (Run, bool) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	for _, run := range h.all(userID) {
+		if run.ID == id {
+			return run, true
+		}
+	}
+	return Run{}, false
+}
+
+// all returns the user's runs, oldest first.
+func (h *History) all(userID int64) []Run {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.db == nil {
+		return append([]Run(nil), h.mem[userID]...)
+	}
+
+	var runs []Run
+	err := h.db.View(func(tx *storage.Tx) error {
+		return tx.Scan(historyBucket, historyPrefix(userID), func(key string, raw []byte) error {
+			var run Run
+			if err := json.Unmarshal(raw, &run); err != nil {
+				return fmt.Errorf("decode run %s: %w", key, err)
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	if err != nil {
+		h.logger.Error("preview history load failed", "user_id", userID, "err", err)
+	}
+	return runs
+}
+
+func historyPrefix(userID int64) string {
+	return fmt.Sprintf("%d/", userID)
+}
@@ -0,0 +1,220 @@
+package preview
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+const presetBucket = "preview_presets"
+
+// MaxPresets caps saved presets per user.
+const MaxPresets = 20
+
+// maxPresetName keeps names short enough to type and to fit in buttons.
+const maxPresetName = 24
+
+var (
+	ErrPresetName  = errors.New("preview: invalid preset name")
+	ErrPresetLimit = errors.New("preview: too many presets")
+)
+
+// Preset is a named set of wizard settings a user saved.
+type Preset struct {
+	Name      string
+	Options   Options
+	CreatedAt time.Time
+}
+
+// PresetName normalises a user-typed name to a single lowercase token
+// usable as "preset=<name>"; it returns "" when nothing usable is left.
+func PresetName(raw string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.ToLower(strings.TrimSpace(raw)) {
+		if n == maxPresetName {
+			break
+		}
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('_')
+		default:
+			continue
+		}
+		n++
+	}
+	return strings.Trim(b.String(), "_-")
+}
+
+type PresetOptions struct {
+	// DB persists presets; nil keeps them in memory.
+	DB     *storage.DB
+	Logger *slog.Logger
+}
+
+// Presets stores each user's named wizard settings.
+type Presets struct {
+	mu     sync.Mutex
+	db     *storage.DB
+	mem    map[string]Preset
+	logger *slog.Logger
+}
+
+func NewPresets(opts PresetOptions) (*Presets, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	p := &Presets{db: opts.DB, logger: logger}
+	if opts.DB == nil {
+		p.mem = make(map[string]Preset)
+		return p, nil
+	}
+	if _, err := opts.DB.Bucket(presetBucket); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Save stores opts under name, replacing a preset of the same name.
+func (p *Presets) Save(userID int64, name string, opts Options) (Preset, error) {
+	name = PresetName(name)
+	if name == "" {
+		return Preset{}, ErrPresetName
+	}
+	preset := Preset{Name: name, Options: opts, CreatedAt: time.Now().UTC()}
+	key := presetKey(userID, name)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		if _, ok := p.mem[key]; !ok && len(p.listLocked(userID)) >= MaxPresets {
+			return Preset{}, ErrPresetLimit
+		}
+		p.mem[key] = preset
+		return preset, nil
+	}
+
+	err := p.db.Update(func(tx *storage.Tx) error {
+		var existing Preset
+		ok, err := tx.Get(presetBucket, key, &existing)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			count := 0
+			err := tx.Scan(presetBucket, presetPrefix(userID), func(string, []byte) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if count >= MaxPresets {
+				return ErrPresetLimit
+			}
+		}
+		return tx.Put(presetBucket, key, preset)
+	})
+	return preset, err
+}
+
+func (p *Presets) Get(userID int64, name string) (Preset, bool) {
+	name = PresetName(name)
+	if name == "" {
+		return Preset{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		preset, ok := p.mem[presetKey(userID, name)]
+		return preset, ok
+	}
+
+	var preset Preset
+	var ok bool
+	err := p.db.View(func(tx *storage.Tx) error {
+		var err error
+		ok, err = tx.Get(presetBucket, presetKey(userID, name), &preset)
+		return err
+	})
+	if err != nil {
+		p.logger.Error("preset load failed", "user_id", userID, "name", name, "err", err)
+	}
+	return preset, ok
+}
+
+// List returns the user's presets sorted by name.
+func (p *Presets) List(userID int64) []Preset {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listLocked(userID)
+}
+
+func (p *Presets) Delete(userID int64, name string) error {
+	key := presetKey(userID, PresetName(name))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		delete(p.mem, key)
+		return nil
+	}
+	return p.db.Update(func(tx *storage.Tx) error {
+		return tx.Delete(presetBucket, key)
+	})
+}
+
+func (p *Presets) listLocked(userID int64) []Preset {
+	prefix := presetPrefix(userID)
+	var out []Preset
+
+	if p.db == nil {
+		for key, preset := range p.mem {
+			if strings.HasPrefix(key, prefix) {
+				out = append(out, preset)
+			}
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		return out
+	}
+
+	err := p.db.View(func(tx *storage.Tx) error {
+		return tx.Scan(presetBucket, prefix, func(key string, raw []byte) error {
+			var preset Preset
+			if err := json.Unmarshal(raw, &preset); err != nil {
+				return fmt.Errorf("decode preset %s: %w", key, err)
+			}
+			out = append(out, preset)
+			return nil
+		})
+	})
+	if err != nil {
+		p.logger.Error("preset list failed", "user_id", userID, "err", err)
+	}
+	return out
+}
+
+func presetPrefix(userID int64) string {
+	return fmt.Sprintf("%d/", userID)
+}
+
+func presetKey(userID int64, name string) string {
+	return presetPrefix(userID) + name
+}
@@ -7,7 +7,7 @@ import (
 )
 
 type Options struct {
-	Mode          string // "grid" | "vertical"
+	Mode          string // "grid" | "vertical" | "cutout"
 	GridPreset    string // "1x1" | "2x2" | "3x2" | "3x3"
 	VerticalCount string // "1" | "2" | "3" | "4"
 	AspectRatio   string // optional override, e.g. "1:1", "4:5", "9:16"
@@ -16,6 +16,11 @@ type Options struct {
 	VisualStyle   string // "" | "dark_premium" | ...
 	HumanUsage    bool
 	Custom        string
+	Bundle        bool   // every reference image is a separate product of one set
+	BundleSize    int    // number of products in bundle mode
+	Batch         bool   // run the whole pipeline once per reference photo (not used by BuildPrompt)
+	Variation     bool   // ask for a fresh take on the same setup ("more like this")
+	Preset        string // saved preset named by "preset=<name>"; callers load it
 }
 
 type OutputPreset struct {
@@ -51,8 +56,20 @@ type VisualPreset struct {
 const (
 	aspectGrid     = "3:4"
 	aspectVertical = "9:16"
+	aspectCutout   = "1:1"
 )
 
+// CutoutKeyColor is the uniform background requested in cutout mode; it is
+// removed locally afterwards (see imaging.Cutout).
+const CutoutKeyColor = "#00FF00"
+
+// MaxBundleProducts caps how many reference photos are sent to the model in bundle mode.
+const MaxBundleProducts = 6
+
+// PromptVersion identifies the prompt pack. Bump it when templates change so
+// regenerating from an older recipe warns that the result will differ.
+const PromptVersion = "1"
+
 var gridPresets = map[string]struct{ Cols, Rows int }{
 	"1x1": {Cols: 1, Rows: 1},
 	"2x2": {Cols: 2, Rows: 2},
@@ -188,6 +205,115 @@ var frameTemplates = []FrameTemplate{
 	},
 }
 
+var bundleFrameTemplates = []FrameTemplate{
+	{
+		ID:      "bundle_hero_lineup",
+		Title:   "Hero Set Lineup",
+		Concept: "The whole set presented as one confident family",
+		Execution: []string{
+			"All products standing side by side on a seamless studio sweep",
+			"Stagger heights and depth slightly so every item is readable",
+			"Tallest/most important item as the visual anchor, others balanced around it",
+			"One consistent key light for the entire set; matching shadow direction",
+			"Equal sharpness across every product",
+		},
+	},
+	{
+		ID:      "bundle_knolling_flatlay",
+		Title:   "Knolling Flat Lay",
+		Concept: "Organized top-down arrangement that shows everything in the kit",
+		Execution: []string{
+			"Top-down 90° camera, products laid out on a clean surface",
+			"Strict grid alignment with equal spacing between items",
+			"Soft even overhead light, minimal shadows",
+			"Every product fully visible; no overlaps",
+			"Neutral surface that complements the set palette",
+		},
+	},
+	{
+		ID:      "bundle_gift_box",
+		Title:   "Gift Box Reveal",
+		Concept: "Premium unboxing moment of the complete set",
+		Execution: []string{
+			"Products nested in an open premium gift box or tray (generic, no branding)",
+			"Tissue paper/ribbon accents kept subtle and off the products",
+			"Three-quarter high angle showing every item inside",
+			"Warm, inviting light with soft falloff",
+			"Box must not hide or crop any product",
+		},
+	},
+	{
+		ID:      "bundle_pyramid_stack",
+		Title:   "Sculptural Stack",
+		Concept: "Architectural composition built from the set",
+		Execution: []string{
+			"Arrange products in a stable pyramid/stepped composition using plinths or blocks",
+			"Each product on its own level so none is hidden",
+			"Monochrome props matching the set palette",
+			"Museum-grade lighting with clean separation per item",
+			"Physically plausible placement; nothing floating unless intentional",
+		},
+	},
+	{
+		ID:      "bundle_in_use_context",
+		Title:   "Set In Context",
+		Concept: "The kit in a believable premium use environment",
+		Execution: []string{
+			"Products placed together where they would naturally be used (vanity, desk, kitchen counter)",
+			"Environment softly defocused; products remain the sharp hero group",
+			"Keep the set visually grouped, not scattered",
+			"Natural window light or soft studio light",
+			"No extra products that are not part of the set",
+		},
+	},
+	{
+		ID:      "bundle_pair_detail",
+		Title:   "Close Pair Detail",
+		Concept: "Closer crop that still shows every item together",
+		Execution: []string{
+			"Tighter crop, products slightly overlapping in depth but never covering labels",
+			"Shallow depth of field with the front products in focus",
+			"Reveal materials and finishes of each item",
+			"Every product still identifiable in the frame",
+		},
+	},
+	{
+		ID:      "bundle_floating_set",
+		Title:   "Floating Set",
+		Concept: "Weightless composition of the full kit",
+		Execution: []string{
+			"All products levitating in a balanced cluster",
+			"Consistent scale between items (true relative size)",
+			"Soft shadows beneath to ground the composition",
+			"Airy background with gentle gradient",
+			"No product rotated so far that its identity is lost",
+		},
+	},
+	{
+		ID:      "bundle_podium_circle",
+		Title:   "Circular Podium Arrangement",
+		Concept: "Products arranged on a round podium like a display case",
+		Execution: []string{
+			"Round podium or turntable with products arranged in an arc",
+			"Eye-level to slight high angle",
+			"Rim light separating each product from the background",
+			"Symmetric, calm, retail-display feel",
+			"All products facing the camera with front labels visible",
+		},
+	},
+	{
+		ID:      "bundle_color_story",
+		Title:   "Color Story Backdrop",
+		Concept: "Unified set on a backdrop drawn from its shared palette",
+		Execution: []string{
+			"Solid or two-tone backdrop sampled from the set's dominant colors",
+			"Products grouped tightly with intentional negative space",
+			"Soft colored shadows harmonizing the group",
+			"Clean editorial finish suitable for a marketplace cover",
+		},
+	},
+}
+
 var productTypes = map[string]ProductType{
 	"": {
 		Name: "Auto/General",
@@ -524,6 +650,22 @@ func ResolveOutputPreset(opts Options) OutputPreset {
 	gridKey := strings.ToLower(strings.TrimSpace(opts.GridPreset))
 	verticalKey := strings.ToLower(strings.TrimSpace(opts.VerticalCount))
 
+	if mode == "cutout" {
+		out := OutputPreset{
+			Mode:            "cutout",
+			Cols:            1,
+			Rows:            1,
+			Count:           1,
+			AspectRatio:     aspectCutout,
+			ResolutionHint:  "4K",
+			LayoutPresetKey: "cutout",
+		}
+		if ar := normalizeAspectRatio(opts.AspectRatio); ar != "" {
+			out.AspectRatio = ar
+		}
+		return out
+	}
+
 	if mode == "vertical" {
 		count, ok := verticalCounts[verticalKey]
 		if !ok {
@@ -568,26 +710,37 @@ func ResolveOutputPreset(opts Options) OutputPreset {
 }
 
 func FramesForCount(n int) []FrameTemplate {
+	return framesFromSet(frameTemplates, n)
+}
+
+func frameSet(bundle bool) []FrameTemplate {
+	if bundle {
+		return bundleFrameTemplates
+	}
+	return frameTemplates
+}
+
+func framesFromSet(templates []FrameTemplate, n int) []FrameTemplate {
 	if n < 1 {
 		n = 1
 	}
-	if n > len(frameTemplates) {
-		n = len(frameTemplates)
+	if n > len(templates) {
+		n = len(templates)
 	}
 	out := make([]FrameTemplate, 0, n)
 	for i := 0; i < n; i++ {
-		out = append(out, cloneFrameTemplate(frameTemplates[i]))
+		out = append(out, cloneFrameTemplate(templates[i]))
 	}
 	return out
 }
 
-func framesForOutput(count int, selectedIDs []string) []FrameTemplate {
+func framesForOutput(templates []FrameTemplate, count int, selectedIDs []string) []FrameTemplate {
 	if len(selectedIDs) == 0 {
-		return FramesForCount(count)
+		return framesFromSet(templates, count)
 	}
 
-	byID := make(map[string]FrameTemplate, len(frameTemplates))
-	for _, t := range frameTemplates {
+	byID := make(map[string]FrameTemplate, len(templates))
+	for _, t := range templates {
 		byID[t.ID] = t
 	}
 
@@ -609,7 +762,7 @@ func framesForOutput(count int, selectedIDs []string) []FrameTemplate {
 	}
 
 	if len(out) == 0 {
-		return FramesForCount(count)
+		return framesFromSet(templates, count)
 	}
 
 	if len(out) > count {
@@ -617,7 +770,7 @@ func framesForOutput(count int, selectedIDs []string) []FrameTemplate {
 	}
 
 	if len(out) < count {
-		for _, tpl := range frameTemplates {
+		for _, tpl := range templates {
 			if len(out) >= count {
 				break
 			}
@@ -657,12 +810,23 @@ func ParseArgs(raw string, defaults Options) Options {
 		case "vertical", "portrait", "v":
 			opts.Mode = "vertical"
 			continue
+		case "cutout", "transparent", "png":
+			opts.Mode = "cutout"
+			continue
 		case "use", "human", "usage", "inuse":
 			opts.HumanUsage = true
 			continue
 		case "nohuman", "nouse", "no-usage":
 			opts.HumanUsage = false
 			continue
+		case "bundle", "kit", "set", "giftset":
+			opts.Bundle = true
+			opts.Batch = false
+			continue
+		case "batch", "catalog":
+			opts.Batch = true
+			opts.Bundle = false
+			continue
 		}
 
 		if _, ok := gridPresets[tok]; ok {
@@ -702,6 +866,14 @@ func ParseArgs(raw string, defaults Options) Options {
 			opts.VisualStyle = tok
 			continue
 		}
+		if strings.HasPrefix(tok, "code=") {
+			// Share codes are case-sensitive; callers read them with CodeArg.
+			continue
+		}
+		if name, ok := strings.CutPrefix(tok, "preset="); ok {
+			opts.Preset = PresetName(name)
+			continue
+		}
 		if strings.HasPrefix(tok, "ar=") || strings.HasPrefix(tok, "aspect=") {
 			ar := tok
 			ar = strings.TrimPrefix(ar, "aspect=")
@@ -719,12 +891,17 @@ func ParseArgs(raw string, defaults Options) Options {
 		custom = append(custom, orig)
 	}
 
-	opts.Custom = strings.TrimSpace(strings.Join(custom, " "))
+	if len(custom) > 0 {
+		opts.Custom = strings.TrimSpace(strings.Join(custom, " "))
+	}
 	return opts
 }
 
 func BuildPrompt(opts Options) (string, OutputPreset) {
 	out := ResolveOutputPreset(opts)
+	if out.Mode == "cutout" {
+		return buildCutoutPrompt(opts, out), out
+	}
 
 	productTypeKey := strings.ToLower(strings.TrimSpace(opts.ProductType))
 	productType, ok := productTypes[productTypeKey]
@@ -736,11 +913,23 @@ func BuildPrompt(opts Options) (string, OutputPreset) {
 	visualKey := strings.ToLower(strings.TrimSpace(opts.VisualStyle))
 	visual, hasVisual := visualPresets[visualKey]
 
-	frames := framesForOutput(out.Count, opts.FrameIDs)
+	bundleSize := opts.BundleSize
+	if bundleSize > MaxBundleProducts {
+		bundleSize = MaxBundleProducts
+	}
+	bundle := opts.Bundle && bundleSize >= 2
+
+	frames := framesForOutput(frameSet(bundle), out.Count, opts.FrameIDs)
 	for i := range frames {
 		frames[i].Execution = append(frames[i].Execution,
 			"REFERENCE MOOD LOCK: match the reference image mood, lighting, contrast, and palette; avoid off-palette backgrounds/effects.",
 		)
+		if bundle {
+			frames[i].Execution = append(frames[i].Execution,
+				fmt.Sprintf("SET COMPLETENESS: all %d products appear together, each exactly once, at true relative scale.", bundleSize),
+				"No product may hide, overlap, or crop the label/branding of another product.",
+			)
+		}
 		if frames[i].ID == "dynamic_interaction" {
 			frames[i].Execution = append(frames[i].Execution, productType.Frame3...)
 		}
@@ -775,17 +964,24 @@ func BuildPrompt(opts Options) (string, OutputPreset) {
 
 	b.WriteString("TASK: Premium marketplace-ready product preview generation.\n\n")
 
-	b.WriteString("REFERENCE IMAGE (IDENTITY LOCK): The attached photo contains the real product. Treat this as an image-edit/compositing task.\n")
-	b.WriteString("- The product in every output MUST be the exact same object from the reference photo.\n")
-	b.WriteString("- Preserve shape, proportions, materials, colors, and all physical details exactly.\n")
-	b.WriteString("- Do NOT replace the product with another item (no substitutions) or invent a different product type.\n")
-	b.WriteString("- Branding/text rule: if the reference has text/logo/label, keep it exactly; if it has none, do NOT add any text/logo/brand/claims.\n")
-	b.WriteString("- If the reference photo includes a room/background, isolate the main product and replace the background with the requested studio scene.\n")
-	b.WriteString("- You may remove background and re-light; never redesign the product or add new parts.\n")
-	b.WriteString("- Do NOT add captions/watermarks/text overlays.\n\n")
+	if bundle {
+		writeBundleIdentityLock(&b, bundleSize)
+	} else {
+		b.WriteString("REFERENCE IMAGE (IDENTITY LOCK): The attached photo contains the real product. Treat this as an image-edit/compositing task.\n")
+		b.WriteString("- The product in every output MUST be the exact same object from the reference photo.\n")
+		b.WriteString("- Preserve shape, proportions, materials, colors, and all physical details exactly.\n")
+		b.WriteString("- Do NOT replace the product with another item (no substitutions) or invent a different product type.\n")
+		b.WriteString("- Branding/text rule: if the reference has text/logo/label, keep it exactly; if it has none, do NOT add any text/logo/brand/claims.\n")
+		b.WriteString("- If the reference photo includes a room/background, isolate the main product and replace the background with the requested studio scene.\n")
+		b.WriteString("- You may remove background and re-light; never redesign the product or add new parts.\n")
+		b.WriteString("- Do NOT add captions/watermarks/text overlays.\n\n")
+	}
 
 	b.WriteString("OUTPUT SPEC:\n")
 	b.WriteString(fmt.Sprintf("- Create %d images.\n", out.Count))
+	if bundle {
+		b.WriteString(fmt.Sprintf("- Each image is a group composition of all %d products.\n", bundleSize))
+	}
 	b.WriteString(fmt.Sprintf("- Aspect ratio per image: %s (%s).\n", out.AspectRatio, out.Mode))
 	b.WriteString(fmt.Sprintf("- Quality: %s. Lighting: studio-grade.\n", out.ResolutionHint))
 	b.WriteString("- FULL-BLEED REQUIRED: no borders/frames/bars/mattes/padding/margins/empty edges; if ratio mismatch, outpaint/extend background.\n\n")
@@ -857,6 +1053,18 @@ func BuildPrompt(opts Options) (string, OutputPreset) {
 		b.WriteString("- " + custom + "\n\n")
 	}
 
+	if opts.Variation {
+		b.WriteString("VARIATION:\n")
+		for _, line := range []string{
+			"This is a new take on a set the client already liked: keep the same frames, style, palette and mood.",
+			"Vary composition, camera angle, prop placement and light direction noticeably from a typical render.",
+			"Product identity rules above still apply in full.",
+		} {
+			b.WriteString("- " + line + "\n")
+		}
+		b.WriteString("\n")
+	}
+
 	b.WriteString("FRAMES (generate one image per frame):\n")
 	for i, fr := range frames {
 		b.WriteString(fmt.Sprintf("\nFrame %d: %s\n", i+1, fr.Title))
@@ -870,6 +1078,14 @@ func BuildPrompt(opts Options) (string, OutputPreset) {
 	b.WriteString("\n")
 
 	b.WriteString("NEGATIVE PROMPT (avoid):\n")
+	if bundle {
+		for _, line := range []string{
+			"missing product from the set", "duplicated product", "merged/fused products",
+			"extra products not in the references", "swapped labels between products", "wrong relative scale",
+		} {
+			b.WriteString("- " + line + "\n")
+		}
+	}
 	for _, line := range []string{
 		"distorted product", "incorrect logo", "wrong typography", "misspelled label text",
 		"product substitution", "different product than reference", "invented branding", "invented brand name",
@@ -893,6 +1109,84 @@ func BuildPrompt(opts Options) (string, OutputPreset) {
 	return strings.TrimSpace(b.String()), out
 }
 
+func buildCutoutPrompt(opts Options, out OutputPreset) string {
+	bundleSize := opts.BundleSize
+	if bundleSize > MaxBundleProducts {
+		bundleSize = MaxBundleProducts
+	}
+	bundle := opts.Bundle && bundleSize >= 2
+
+	var b strings.Builder
+	b.Grow(2048)
+
+	b.WriteString("TASK: Isolated product cutout on a uniform key background (for background removal).\n\n")
+
+	if bundle {
+		writeBundleIdentityLock(&b, bundleSize)
+	} else {
+		b.WriteString("REFERENCE IMAGE (IDENTITY LOCK): The attached photo contains the real product. Treat this as an image-edit task.\n")
+		b.WriteString("- The product MUST be the exact same object from the reference photo.\n")
+		b.WriteString("- Preserve shape, proportions, materials, colors, and label/branding exactly; if it has no text/logo, add none.\n")
+		b.WriteString("- Never redesign, substitute, or add new parts.\n\n")
+	}
+
+	b.WriteString("OUTPUT SPEC:\n")
+	b.WriteString("- Create 1 image.\n")
+	b.WriteString(fmt.Sprintf("- Aspect ratio: %s. Quality: %s.\n", out.AspectRatio, out.ResolutionHint))
+	if bundle {
+		b.WriteString("- All products grouped together in one tight, front-facing arrangement.\n")
+	} else {
+		b.WriteString("- Single product, front-facing hero angle, fully visible (nothing cropped).\n")
+	}
+	b.WriteString("- Product centered with generous empty margin on all sides.\n\n")
+
+	b.WriteString("BACKGROUND (STRICT):\n")
+	for _, line := range []string{
+		"Perfectly uniform solid " + CutoutKeyColor + " (pure chroma green) background filling the whole canvas.",
+		"If the product itself is mostly green, use solid pure magenta #FF00FF instead.",
+		"No gradient, no texture, no vignette, no floor, no horizon line.",
+		"No cast shadows, no reflections, no glow on the background.",
+		"No key-colour spill on the product; clean, crisp product edges.",
+		"Even, soft studio lighting on the product only.",
+	} {
+		b.WriteString("- " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	if custom := strings.TrimSpace(opts.Custom); custom != "" {
+		b.WriteString("ADDITIONAL NOTES:\n")
+		b.WriteString("- " + custom + "\n\n")
+	}
+
+	b.WriteString("NEGATIVE PROMPT (avoid):\n")
+	for _, line := range []string{
+		"shadow", "reflection", "gradient background", "props", "scene", "extra objects",
+		"distorted product", "incorrect logo", "invented branding", "text overlays", "watermark", "cropped product",
+	} {
+		b.WriteString("- " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("OUTPUT RULES:\n")
+	b.WriteString("- Return exactly 1 image.\n")
+	b.WriteString("- Images only. No text, no JSON.\n")
+
+	return strings.TrimSpace(b.String())
+}
+
+func writeBundleIdentityLock(b *strings.Builder, n int) {
+	b.WriteString(fmt.Sprintf("REFERENCE IMAGES (BUNDLE IDENTITY LOCK): %d attached photos, each contains ONE real product of the same set/kit. Treat this as a multi-product compositing task.\n", n))
+	b.WriteString(fmt.Sprintf("- Every output MUST show all %d products together; never drop, duplicate, or merge items.\n", n))
+	b.WriteString("- Do NOT add products that are not in the reference photos.\n")
+	b.WriteString("- Keep true relative scale between products (use their real-world proportions).\n")
+	b.WriteString("- If a reference photo includes a room/background, isolate its product and place it in the requested studio scene.\n")
+	b.WriteString("- Do NOT add captions/watermarks/text overlays.\n")
+	for i := 1; i <= n; i++ {
+		b.WriteString(fmt.Sprintf("- Product #%d (photo #%d): exact same object as its photo; preserve shape, proportions, materials, colors, and label/branding exactly; if it has no text/logo, add none; never redesign or substitute.\n", i, i))
+	}
+	b.WriteString("\n")
+}
+
 func uniq(in []string) []string {
 	seen := make(map[string]struct{}, len(in))
 	out := make([]string, 0, len(in))
@@ -0,0 +1,222 @@
+package preview
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+// RecipeIDLen keeps recipe IDs short enough for callback_data.
+const RecipeIDLen = 8
+
+const recipeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+const recipeBucket = "preview_recipes"
+
+// Recipe is everything needed to repeat a delivered preview set.
+type Recipe struct {
+	ID            string
+	ChatID        int64
+	UserID        int64
+	Options       Options
+	PromptVersion string
+	// FileIDs are the Telegram file IDs of the source product photos.
+	FileIDs []string
+	// Outputs are blob references of the generated images, in frame order.
+	Outputs []string
+	// SentFileIDs are the Telegram file IDs of the delivered images.
+	SentFileIDs []string
+	AsFiles     bool
+	CreatedAt   time.Time
+}
+
+// Frames lists the frame template IDs the recipe rendered, in output order.
+func (r Recipe) Frames() []string {
+	return OutputFrameIDs(r.Options)
+}
+
+type RecipeOptions struct {
+	// DB persists recipes; nil keeps them in memory.
+	DB *storage.DB
+	// TTL forgets recipes older than this; zero keeps them forever.
+	TTL    time.Duration
+	Logger *slog.Logger
+}
+
+// Recipes stores generation recipes by short random ID.
+type Recipes struct {
+	mu     sync.Mutex
+	bucket *storage.Bucket
+	mem    map[string]Recipe
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+func NewRecipes(opts RecipeOptions) (*Recipes, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	r := &Recipes{ttl: opts.TTL, logger: logger}
+	if opts.DB == nil {
+		r.mem = make(map[string]Recipe)
+		return r, nil
+	}
+	bucket, err := opts.DB.Bucket(recipeBucket)
+	if err != nil {
+		return nil, err
+	}
+	r.bucket = bucket
+	return r, nil
+}
+
+// Save assigns the recipe an ID and creation time and stores it.
+func (r *Recipes) Save(rec Recipe) (Recipe, error) {
+	id, err := newRecipeID()
+	if err != nil {
+		return Recipe{}, err
+	}
+	rec.ID = id
+	rec.CreatedAt = time.Now().UTC()
+	if rec.PromptVersion == "" {
+		rec.PromptVersion = PromptVersion
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.bucket == nil {
+		r.mem[id] = rec
+		return rec, nil
+	}
+	return rec, r.bucket.Put(id, rec)
+}
+
+// Get returns the recipe unless it is missing or expired.
+func (r *Recipes) Get(id string) (Recipe, bool) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) != RecipeIDLen {
+		return Recipe{}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rec Recipe
+	if r.bucket == nil {
+		var ok bool
+		if rec, ok = r.mem[id]; !ok {
+			return Recipe{}, false
+		}
+	} else {
+		ok, err := r.bucket.Get(id, &rec)
+		if err != nil {
+			r.logger.Error("recipe load failed", "id", id, "err", err)
+		}
+		if !ok {
+			return Recipe{}, false
+		}
+	}
+	if r.expired(rec, time.Now()) {
+		return Recipe{}, false
+	}
+	return rec, true
+}
+
+// Sweep deletes expired recipes.
+func (r *Recipes) Sweep() (removed int) {
+	if r.ttl <= 0 {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.bucket == nil {
+		for id, rec := range r.mem {
+			if r.expired(rec, now) {
+				delete(r.mem, id)
+				removed++
+			}
+		}
+		return removed
+	}
+
+	var stale []string
+	err := r.bucket.ForEach(func(key string, raw []byte) error {
+		var rec Recipe
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("decode recipe %s: %w", key, err)
+		}
+		if r.expired(rec, now) {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.Error("recipe sweep failed", "err", err)
+	}
+	for _, id := range stale {
+		if err := r.bucket.Delete(id); err != nil {
+			r.logger.Error("recipe delete failed", "id", id, "err", err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+func (r *Recipes) expired(rec Recipe, now time.Time) bool {
+	return r.ttl > 0 && now.Sub(rec.CreatedAt) > r.ttl
+}
+
+// SingleFrame narrows opts to one of its frames, keeping the aspect ratio
+// the full set was rendered at.
+func SingleFrame(opts Options, frameID string) Options {
+	out := ResolveOutputPreset(opts)
+	opts.AspectRatio = out.AspectRatio
+	opts.FrameIDs = []string{frameID}
+	switch out.Mode {
+	case "vertical":
+		opts.VerticalCount = "1"
+	case "grid":
+		opts.GridPreset = "1x1"
+	}
+	return opts
+}
+
+// OutputFrameIDs lists the frame template IDs BuildPrompt renders for opts;
+// cutout mode has none.
+func OutputFrameIDs(opts Options) []string {
+	out := ResolveOutputPreset(opts)
+	if out.Mode == "cutout" {
+		return nil
+	}
+	bundle := opts.Bundle && min(opts.BundleSize, MaxBundleProducts) >= 2
+	frames := framesForOutput(frameSet(bundle), out.Count, opts.FrameIDs)
+	ids := make([]string, len(frames))
+	for i, f := range frames {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+func newRecipeID() (string, error) {
+	buf := make([]byte, RecipeIDLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("recipe id: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = recipeAlphabet[int(b)%len(recipeAlphabet)]
+	}
+	return string(buf), nil
+}
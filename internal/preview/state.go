@@ -1,8 +1,15 @@
 package preview
 
 import (
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"pro-banana-ai-bot/internal/metrics"
 )
 
 type UIState struct {
@@ -22,9 +29,17 @@ type UIState struct {
 	LastPhotoFileID string
 	MessageID       int
 
+	Bundle        bool
+	BundleFileIDs []string
+
+	// AsFiles delivers results as documents instead of compressed photos.
+	AsFiles bool
+
 	AwaitingPhoto  bool
 	AwaitingCustom bool
-	Menu           string // "main" | "category" | "style" | "frames"
+	// AwaitingPresetName means the next text names a preset to save.
+	AwaitingPresetName bool
+	Menu               string // "main" | "category" | "style" | "frames" | "presets"
 
 	UpdatedAt time.Time
 }
@@ -87,14 +102,56 @@ func (s *UIState) ToggleFrame(idx int) {
 	s.LastSelectedOrder = filterSelected(s.LastSelectedOrder, s.SelectedFrames)
 }
 
+// ApplyOptions loads the generation settings in opts into the wizard;
+// photos and delivery are left as they are.
+func (s *UIState) ApplyOptions(opts Options) {
+	s.Mode = opts.Mode
+	s.GridPreset = opts.GridPreset
+	s.VerticalCount = opts.VerticalCount
+	s.AspectRatio = opts.AspectRatio
+	s.ProductType = opts.ProductType
+	s.VisualStyle = opts.VisualStyle
+	s.HumanUsage = opts.HumanUsage
+	s.Custom = opts.Custom
+	s.Bundle = opts.Bundle
+	s.SelectFrames(opts.FrameIDs)
+}
+
+// SelectFrames selects frames by template ID, in order; unknown IDs are
+// skipped and no IDs selects the default frames.
+func (s *UIState) SelectFrames(ids []string) {
+	var selected [9]bool
+	var order []int
+	for _, id := range ids {
+		idx := frameIndex(id)
+		if idx < 0 || idx > 8 || selected[idx] {
+			continue
+		}
+		selected[idx] = true
+		order = append(order, idx)
+	}
+	if len(order) == 0 {
+		for i := 0; i < 9; i++ {
+			selected[i] = true
+			order = append(order, i)
+		}
+	}
+	s.SelectedFrames = selected
+	s.LastSelectedOrder = order
+	if len(order) != desiredCount(*s) {
+		s.SyncSelection()
+	}
+}
+
 func (s UIState) SelectionFrameIDs() []string {
 	indices := selectionIndicesForOutput(s)
+	templates := frameSet(s.bundleActive())
 	out := make([]string, 0, len(indices))
 	for _, idx := range indices {
-		if idx < 0 || idx >= len(frameTemplates) {
+		if idx < 0 || idx >= len(templates) {
 			continue
 		}
-		out = append(out, frameTemplates[idx].ID)
+		out = append(out, templates[idx].ID)
 	}
 	return out
 }
@@ -110,43 +167,237 @@ func (s UIState) PromptOptions() Options {
 		VisualStyle:   s.VisualStyle,
 		HumanUsage:    s.HumanUsage,
 		Custom:        s.Custom,
+		Bundle:        s.bundleActive(),
+		BundleSize:    len(s.BundlePhotos()),
 	}
 }
 
+// Settings returns the wizard's settings as a preset: PromptOptions
+// without what depends on the current photos.
+func (s UIState) Settings() Options {
+	opts := s.PromptOptions()
+	opts.Bundle = s.Bundle
+	opts.BundleSize = 0
+	return opts
+}
+
+// BundlePhotos returns the product photos used in bundle mode, capped at MaxBundleProducts.
+func (s UIState) BundlePhotos() []string {
+	ids := s.BundleFileIDs
+	if len(ids) > MaxBundleProducts {
+		ids = ids[:MaxBundleProducts]
+	}
+	return append([]string(nil), ids...)
+}
+
+func (s UIState) bundleActive() bool {
+	return s.Bundle && len(s.BundleFileIDs) >= 2
+}
+
+type StoreOptions struct {
+	// Backend defaults to an in-memory backend.
+	Backend Backend
+
+	// TTL expires wizards not touched for this long; zero disables expiry.
+	TTL time.Duration
+	// MaxStates caps stored wizards; the least recently updated go first.
+	MaxStates int
+
+	Logger *slog.Logger
+}
+
+// Store keeps wizards in memory and writes changed ones to the Backend on
+// Flush and Sweep, so button presses never wait on disk.
 type Store struct {
-	mu sync.Mutex
-	m  map[stateKey]*UIState
+	mu        sync.Mutex
+	cache     map[StateKey]UIState
+	dirty     map[StateKey]bool
+	backend   Backend
+	ttl       time.Duration
+	maxStates int
+	logger    *slog.Logger
+
+	// flushMu orders backend writes so an older snapshot never lands last.
+	flushMu sync.Mutex
 }
 
-type stateKey struct {
+type StateKey struct {
 	ChatID int64
 	UserID int64
 }
 
-func NewStore() *Store {
-	return &Store{m: make(map[stateKey]*UIState)}
+func NewStore(opts StoreOptions) *Store {
+	backend := opts.Backend
+	if backend == nil {
+		backend = NewMemoryBackend()
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return &Store{
+		cache:     make(map[StateKey]UIState),
+		dirty:     make(map[StateKey]bool),
+		backend:   backend,
+		ttl:       opts.TTL,
+		maxStates: opts.MaxStates,
+		logger:    logger,
+	}
+}
+
+var (
+	evictedTTL = metrics.NewCounter("preview_evictions_ttl")
+	evictedLRU = metrics.NewCounter("preview_evictions_lru")
+	stateCount = metrics.NewGauge("preview_states")
+)
+
+// Lookup returns the stored state without falling back to defaults. Expired
+// state that the janitor has not swept yet is reported as missing.
+func (s *Store) Lookup(chatID, userID int64) (UIState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.cachedLocked(StateKey{ChatID: chatID, UserID: userID})
+	if !ok || s.expired(st, time.Now()) {
+		return UIState{}, false
+	}
+	st = st.clone()
+	st.SyncSelection()
+	return st, true
+}
+
+// Flush writes wizards changed since the last flush to the Backend.
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := make(map[StateKey]UIState, len(s.dirty))
+	for key := range s.dirty {
+		pending[key] = s.cache[key].clone()
+	}
+	clear(s.dirty)
+	s.mu.Unlock()
+
+	var firstErr error
+	for key, st := range pending {
+		if err := s.backend.Save(key, st); err != nil {
+			s.mu.Lock()
+			if _, ok := s.cache[key]; ok {
+				s.dirty[key] = true
+			}
+			s.mu.Unlock()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Sweep saves changed wizards, then deletes expired ones and, past
+// MaxStates, the least recently updated ones.
+func (s *Store) Sweep() (expired int, evicted int) {
+	if err := s.Flush(); err != nil {
+		s.logger.Error("preview state flush failed", "err", err)
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	now := time.Now()
+	var stale, live []sweepEntry
+	err := s.backend.ForEach(func(key StateKey, st UIState) error {
+		e := sweepEntry{key: key, updated: st.UpdatedAt}
+		if s.expired(st, now) {
+			stale = append(stale, e)
+		} else {
+			live = append(live, e)
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errSkippedRecords):
+		s.logger.Warn("preview state sweep dropped bad records", "err", err)
+	case err != nil:
+		s.logger.Error("preview state sweep failed", "err", err)
+		return 0, 0
+	}
+
+	var victims []sweepEntry
+	if s.maxStates > 0 && len(live) > s.maxStates {
+		sort.Slice(live, func(i, j int) bool { return live[i].updated.Before(live[j].updated) })
+		overflow := len(live) - s.maxStates
+		victims = live[:overflow]
+		live = live[overflow:]
+	}
+	evicted = s.deleteUntouched(victims)
+	expired = s.deleteUntouched(stale)
+
+	evictedTTL.Add(int64(expired))
+	evictedLRU.Add(int64(evicted))
+	stateCount.Set(int64(len(live)))
+	return expired, evicted
+}
+
+type sweepEntry struct {
+	key     StateKey
+	updated time.Time
+}
+
+// deleteUntouched removes entries whose wizard was not used since they were
+// read from the Backend.
+func (s *Store) deleteUntouched(entries []sweepEntry) int {
+	n := 0
+	for _, e := range entries {
+		s.mu.Lock()
+		if st, ok := s.cache[e.key]; ok && st.UpdatedAt.After(e.updated) {
+			s.mu.Unlock()
+			continue
+		}
+		delete(s.cache, e.key)
+		delete(s.dirty, e.key)
+		s.mu.Unlock()
+
+		if err := s.backend.Delete(e.key); err != nil {
+			s.logger.Error("preview state delete failed", "key", e.key.String(), "err", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func (s *Store) expired(st UIState, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(st.UpdatedAt) > s.ttl
 }
 
 func (s *Store) Get(chatID, userID int64) UIState {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	st := s.getOrCreateLocked(chatID, userID)
+	st := s.loadLocked(StateKey{ChatID: chatID, UserID: userID})
 	st.SyncSelection()
-	return *st
+	return st
 }
 
 func (s *Store) Update(chatID, userID int64, fn func(*UIState)) UIState {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	st := s.getOrCreateLocked(chatID, userID)
+	key := StateKey{ChatID: chatID, UserID: userID}
+	st := s.loadLocked(key)
 	if fn != nil {
-		fn(st)
+		fn(&st)
 	}
 	st.SyncSelection()
 	st.UpdatedAt = time.Now()
-	return *st
+
+	s.cache[key] = st.clone()
+	s.dirty[key] = true
+	return st
 }
 
 func (s *Store) Reset(chatID, userID int64) UIState {
@@ -155,14 +406,35 @@ func (s *Store) Reset(chatID, userID int64) UIState {
 	})
 }
 
-func (s *Store) getOrCreateLocked(chatID, userID int64) *UIState {
-	key := stateKey{ChatID: chatID, UserID: userID}
-	if st, ok := s.m[key]; ok {
-		return st
+// loadLocked returns a copy of the live state for key, or defaults.
+func (s *Store) loadLocked(key StateKey) UIState {
+	st, ok := s.cachedLocked(key)
+	if !ok || s.expired(st, time.Now()) {
+		return defaultState()
 	}
-	st := defaultState()
-	s.m[key] = &st
-	return s.m[key]
+	return st.clone()
+}
+
+// cachedLocked returns the state for key, reading it from the Backend only
+// the first time after a restart.
+func (s *Store) cachedLocked(key StateKey) (UIState, bool) {
+	if st, ok := s.cache[key]; ok {
+		return st, true
+	}
+	st, ok, err := s.backend.Load(key)
+	if err != nil {
+		s.logger.Error("preview state load failed", "chat_id", key.ChatID, "user_id", key.UserID, "err", err)
+	}
+	if ok {
+		s.cache[key] = st
+	}
+	return st, ok
+}
+
+func (s UIState) clone() UIState {
+	s.LastSelectedOrder = append([]int(nil), s.LastSelectedOrder...)
+	s.BundleFileIDs = append([]string(nil), s.BundleFileIDs...)
+	return s
 }
 
 func defaultState() UIState {
@@ -186,6 +458,20 @@ func defaultState() UIState {
 	}
 }
 
+// frameIndex finds a frame template's position in the single-product or
+// bundle set.
+func frameIndex(id string) int {
+	id = strings.ToLower(strings.TrimSpace(id))
+	for _, set := range [][]FrameTemplate{frameTemplates, bundleFrameTemplates} {
+		for i, t := range set {
+			if t.ID == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 func desiredCount(st UIState) int {
 	out := ResolveOutputPreset(Options{
 		Mode:          st.Mode,
@@ -0,0 +1,53 @@
+package preview
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+func TestSweepSkipsBadRecords(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	backend, err := NewBoltBackend(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := db.Bucket(boltBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for key, v := range map[string]any{
+		"garbage": UIState{UpdatedAt: now},
+		"1:2":     "not a state",
+		"3:4":     UIState{UpdatedAt: now.Add(-2 * time.Hour)},
+		"5:6":     UIState{UpdatedAt: now},
+	} {
+		if err := bucket.Put(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewStore(StoreOptions{Backend: backend, TTL: time.Hour, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if expired, _ := store.Sweep(); expired != 1 {
+		t.Errorf("Sweep expired %d, want 1", expired)
+	}
+
+	var keys []string
+	_ = bucket.ForEach(func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "5:6" {
+		t.Errorf("records left = %q, want only the live one", keys)
+	}
+}
@@ -0,0 +1,267 @@
+// Package ratelimit enforces per-key request budgets with token buckets and
+// daily caps, shared by the bot and the web server.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Budget string
+
+const (
+	BudgetChat    Budget = "chat"
+	BudgetImage   Budget = "image"
+	BudgetPreview Budget = "preview"
+)
+
+const (
+	TierFree = "free"
+	TierPro  = "pro"
+)
+
+// Limit is a token bucket (Burst requests, refilled evenly over Per) plus a
+// daily cap counted in images (messages for chat). Zero values disable that part.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+	Daily int
+}
+
+type TierLimits map[Budget]Limit
+
+// DefaultTierLimits is used for tiers missing from Options.Tiers.
+var DefaultTierLimits = map[string]TierLimits{
+	TierFree: {
+		BudgetChat:    {Burst: 10, Per: time.Minute, Daily: 200},
+		BudgetImage:   {Burst: 3, Per: time.Minute, Daily: 20},
+		BudgetPreview: {Burst: 2, Per: time.Minute, Daily: 36},
+	},
+	TierPro: {
+		BudgetChat:    {Burst: 30, Per: time.Minute, Daily: 2000},
+		BudgetImage:   {Burst: 10, Per: time.Minute, Daily: 200},
+		BudgetPreview: {Burst: 6, Per: time.Minute, Daily: 360},
+	},
+}
+
+// ParseTierLimits reads "chat=10/m,200/d;image=3/m,20/d;preview=2/m,36/d".
+// Budgets left out of spec keep the values from base.
+func ParseTierLimits(spec string, base TierLimits) (TierLimits, error) {
+	out := make(TierLimits, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, rules, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("limits: %q: missing '='", part)
+		}
+		budget := Budget(strings.ToLower(strings.TrimSpace(name)))
+		switch budget {
+		case BudgetChat, BudgetImage, BudgetPreview:
+		default:
+			return nil, fmt.Errorf("limits: unknown budget %q", name)
+		}
+
+		var lim Limit
+		for _, rule := range strings.Split(rules, ",") {
+			count, unit, ok := strings.Cut(strings.TrimSpace(rule), "/")
+			if !ok {
+				return nil, fmt.Errorf("limits: %q: want N/unit", rule)
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("limits: %q: bad count", rule)
+			}
+			switch strings.ToLower(strings.TrimSpace(unit)) {
+			case "s":
+				lim.Burst, lim.Per = n, time.Second
+			case "m":
+				lim.Burst, lim.Per = n, time.Minute
+			case "h":
+				lim.Burst, lim.Per = n, time.Hour
+			case "d":
+				lim.Daily = n
+			default:
+				return nil, fmt.Errorf("limits: %q: unit must be s, m, h or d", rule)
+			}
+		}
+		out[budget] = lim
+	}
+	return out, nil
+}
+
+type Options struct {
+	Tiers map[string]TierLimits
+	// Tier maps a limiter key to its tier; nil puts everyone on TierFree.
+	Tier func(key string) string
+}
+
+// Limiter enforces per-key budgets. Keys are opaque: Telegram user IDs in the
+// bot, API keys or client IPs in the web server.
+type Limiter struct {
+	mu        sync.Mutex
+	tiers     map[string]TierLimits
+	tier      func(key string) string
+	buckets   map[limitKey]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type limitKey struct {
+	key    string
+	budget Budget
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	day    string
+	used   int
+}
+
+// Decision explains a denied request; RetryAt is when it would pass.
+type Decision struct {
+	Allowed bool
+	Daily   bool
+	Cap     int
+	RetryAt time.Time
+}
+
+func New(opts Options) *Limiter {
+	tiers := make(map[string]TierLimits)
+	for name, lim := range DefaultTierLimits {
+		tiers[name] = lim
+	}
+	for name, lim := range opts.Tiers {
+		tiers[name] = lim
+	}
+
+	tier := opts.Tier
+	if tier == nil {
+		tier = func(string) string { return TierFree }
+	}
+
+	return &Limiter{
+		tiers:   tiers,
+		tier:    tier,
+		buckets: make(map[limitKey]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow spends one request from the budget's bucket and images from its
+// daily cap. Nothing is spent when the request is denied.
+func (l *Limiter) Allow(key string, budget Budget, images int) Decision {
+	if images < 1 {
+		images = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	lim, ok := l.tiers[l.tier(key)][budget]
+	if !ok {
+		lim = DefaultTierLimits[TierFree][budget]
+	}
+
+	k := limitKey{key: key, budget: budget}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		l.buckets[k] = b
+	}
+
+	day := now.UTC().Format("2006-01-02")
+	if b.day != day {
+		b.day = day
+		b.used = 0
+	}
+
+	if lim.Daily > 0 && b.used+images > lim.Daily {
+		return Decision{Daily: true, Cap: lim.Daily, RetryAt: nextUTCMidnight(now)}
+	}
+
+	if lim.Burst > 0 && lim.Per > 0 {
+		rate := float64(lim.Burst) / lim.Per.Seconds()
+		b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+			return Decision{Cap: lim.Burst, RetryAt: now.Add(wait)}
+		}
+		b.tokens--
+	}
+
+	b.used += images
+	return Decision{Allowed: true}
+}
+
+// Quota is a key's standing in one budget without spending anything.
+type Quota struct {
+	Used   int
+	Daily  int
+	Tokens int
+	Burst  int
+}
+
+func (l *Limiter) Status(key string, budget Budget) Quota {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.tiers[l.tier(key)][budget]
+	if !ok {
+		lim = DefaultTierLimits[TierFree][budget]
+	}
+	q := Quota{Daily: lim.Daily, Tokens: lim.Burst, Burst: lim.Burst}
+
+	b, ok := l.buckets[limitKey{key: key, budget: budget}]
+	if !ok {
+		return q
+	}
+	now := l.now()
+	if b.day == now.UTC().Format("2006-01-02") {
+		q.Used = b.used
+	}
+	if lim.Burst > 0 && lim.Per > 0 {
+		rate := float64(lim.Burst) / lim.Per.Seconds()
+		q.Tokens = int(math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*rate))
+	}
+	return q
+}
+
+// Tier reports which tier key is on.
+func (l *Limiter) Tier(key string) string {
+	return l.tier(key)
+}
+
+// sweepLocked drops buckets untouched since yesterday so the map stays bounded
+// by recently active keys.
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < 10*time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > 24*time.Hour && b.day != now.UTC().Format("2006-01-02") {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func nextUTCMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for Limiter.now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestLimiter(c *clock, lim Limit) *Limiter {
+	l := New(Options{Tiers: map[string]TierLimits{TierFree: {BudgetImage: lim}}})
+	l.now = c.now
+	return l
+}
+
+func TestLimiterAllow(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	type step struct {
+		after  time.Duration
+		images int
+		want   Decision
+	}
+	tests := []struct {
+		name  string
+		lim   Limit
+		steps []step
+	}{
+		{
+			name: "burst then refill",
+			lim:  Limit{Burst: 2, Per: time.Minute},
+			steps: []step{
+				{images: 1, want: Decision{Allowed: true}},
+				{images: 1, want: Decision{Allowed: true}},
+				{images: 1, want: Decision{Cap: 2, RetryAt: start.Add(30 * time.Second)}},
+				{after: 30 * time.Second, images: 1, want: Decision{Allowed: true}},
+			},
+		},
+		{
+			name: "daily cap counts images",
+			lim:  Limit{Daily: 5},
+			steps: []step{
+				{images: 4, want: Decision{Allowed: true}},
+				{images: 2, want: Decision{Daily: true, Cap: 5, RetryAt: start.Add(12 * time.Hour)}},
+				{images: 1, want: Decision{Allowed: true}},
+				{images: 1, want: Decision{Daily: true, Cap: 5, RetryAt: start.Add(12 * time.Hour)}},
+			},
+		},
+		{
+			name: "daily cap rolls over at UTC midnight",
+			lim:  Limit{Daily: 2},
+			steps: []step{
+				{images: 2, want: Decision{Allowed: true}},
+				{after: 11*time.Hour + 59*time.Minute, images: 1, want: Decision{Daily: true, Cap: 2, RetryAt: start.Add(12 * time.Hour)}},
+				{after: time.Minute, images: 2, want: Decision{Allowed: true}},
+				{images: 1, want: Decision{Daily: true, Cap: 2, RetryAt: start.Add(36 * time.Hour)}},
+			},
+		},
+		{
+			name: "zero images spends one",
+			lim:  Limit{Daily: 1},
+			steps: []step{
+				{images: 0, want: Decision{Allowed: true}},
+				{images: 0, want: Decision{Daily: true, Cap: 1, RetryAt: start.Add(12 * time.Hour)}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clock{t: start}
+			l := newTestLimiter(c, tt.lim)
+			for i, s := range tt.steps {
+				c.t = c.t.Add(s.after)
+				if got := l.Allow("u1", BudgetImage, s.images); got != s.want {
+					t.Fatalf("step %d: Allow = %+v, want %+v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterKeysAreIndependent(t *testing.T) {
+	c := &clock{t: time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)}
+	l := newTestLimiter(c, Limit{Daily: 1})
+
+	if d := l.Allow("a", BudgetImage, 1); !d.Allowed {
+		t.Fatalf("a: %+v", d)
+	}
+	if d := l.Allow("b", BudgetImage, 1); !d.Allowed {
+		t.Fatalf("b: %+v", d)
+	}
+	if q := l.Status("a", BudgetImage); q.Used != 1 || q.Daily != 1 {
+		t.Fatalf("Status(a) = %+v", q)
+	}
+
+	c.t = c.t.Add(24 * time.Hour)
+	if q := l.Status("a", BudgetImage); q.Used != 0 {
+		t.Fatalf("Status(a) next day: Used = %d, want 0", q.Used)
+	}
+}
+
+func TestParseTierLimits(t *testing.T) {
+	base := DefaultTierLimits[TierFree]
+	tests := []struct {
+		spec    string
+		want    TierLimits
+		wantErr bool
+	}{
+		{spec: "", want: base},
+		{
+			spec: "image=5/m,50/d",
+			want: TierLimits{
+				BudgetChat:    base[BudgetChat],
+				BudgetImage:   {Burst: 5, Per: time.Minute, Daily: 50},
+				BudgetPreview: base[BudgetPreview],
+			},
+		},
+		{
+			spec: " chat = 1/s ; preview=100/d ",
+			want: TierLimits{
+				BudgetChat:    {Burst: 1, Per: time.Second},
+				BudgetImage:   base[BudgetImage],
+				BudgetPreview: {Daily: 100},
+			},
+		},
+		{spec: "image", wantErr: true},
+		{spec: "video=1/m", wantErr: true},
+		{spec: "image=5", wantErr: true},
+		{spec: "image=x/m", wantErr: true},
+		{spec: "image=-1/m", wantErr: true},
+		{spec: "image=5/w", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseTierLimits(tt.spec, base)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseTierLimits = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %+v, want %+v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+// Backend persists sessions. Store caches loaded sessions, so Load is only
+// called on first use of a user after start. Sweep deletes outside the
+// Store's lock, so implementations must be safe for concurrent use.
+type Backend interface {
+	Load(userID int64) (Session, bool, error)
+	Save(sess Session) error
+	Delete(userID int64) error
+	ForEach(fn func(Session) error) error
+	// Idle lists users whose last activity is before cutoff without loading
+	// their histories.
+	Idle(cutoff time.Time) ([]int64, error)
+}
+
+type MemoryBackend struct {
+	mu sync.Mutex
+	m  map[int64]Session
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{m: make(map[int64]Session)}
+}
+
+func (b *MemoryBackend) Load(userID int64) (Session, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sess, ok := b.m[userID]
+	if !ok {
+		return Session{}, false, nil
+	}
+	return sess.clone(), true, nil
+}
+
+func (b *MemoryBackend) Save(sess Session) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.m[sess.UserID] = sess.clone()
+	return nil
+}
+
+func (b *MemoryBackend) Delete(userID int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.m, userID)
+	return nil
+}
+
+func (b *MemoryBackend) ForEach(fn func(Session) error) error {
+	b.mu.Lock()
+	sessions := make([]Session, 0, len(b.m))
+	for _, sess := range b.m {
+		sessions = append(sessions, sess.clone())
+	}
+	b.mu.Unlock()
+
+	for _, sess := range sessions {
+		if err := fn(sess); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Idle(cutoff time.Time) ([]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ids []int64
+	for userID, sess := range b.m {
+		if sess.LastActivity.Before(cutoff) {
+			ids = append(ids, userID)
+		}
+	}
+	return ids, nil
+}
+
+const (
+	boltBucket = "sessions"
+	// activityBucket maps user ID to LastActivity so sweeps need not decode
+	// whole histories.
+	activityBucket = "session_activity"
+)
+
+// DiskBackend stores sessions as JSON in the embedded database. Image payloads
+// are expected to be moved out-of-line into a BlobStore by Store.
+type DiskBackend struct {
+	db       *storage.DB
+	bucket   *storage.Bucket
+	activity *storage.Bucket
+}
+
+func NewDiskBackend(db *storage.DB) (*DiskBackend, error) {
+	bucket, err := db.Bucket(boltBucket)
+	if err != nil {
+		return nil, err
+	}
+	activity, err := db.Bucket(activityBucket)
+	if err != nil {
+		return nil, err
+	}
+	b := &DiskBackend{db: db, bucket: bucket, activity: activity}
+	if err := b.indexActivity(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// indexActivity fills the activity index for sessions saved before it
+// existed.
+func (b *DiskBackend) indexActivity() error {
+	indexed := make(map[string]bool)
+	err := b.activity.ForEach(func(key string, _ []byte) error {
+		indexed[key] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *storage.Tx) error {
+		var missing []Session
+		err := tx.Scan(boltBucket, "", func(key string, raw []byte) error {
+			if indexed[key] {
+				return nil
+			}
+			var sess Session
+			if err := json.Unmarshal(raw, &sess); err != nil {
+				return nil
+			}
+			missing = append(missing, sess)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, sess := range missing {
+			if err := tx.Put(activityBucket, strconv.FormatInt(sess.UserID, 10), sess.LastActivity); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *DiskBackend) Load(userID int64) (Session, bool, error) {
+	var sess Session
+	ok, err := b.bucket.Get(strconv.FormatInt(userID, 10), &sess)
+	if err != nil || !ok {
+		return Session{}, false, err
+	}
+	return sess, true, nil
+}
+
+func (b *DiskBackend) Save(sess Session) error {
+	key := strconv.FormatInt(sess.UserID, 10)
+	return b.db.Update(func(tx *storage.Tx) error {
+		if err := tx.Put(boltBucket, key, sess); err != nil {
+			return err
+		}
+		return tx.Put(activityBucket, key, sess.LastActivity)
+	})
+}
+
+func (b *DiskBackend) Delete(userID int64) error {
+	key := strconv.FormatInt(userID, 10)
+	return b.db.Update(func(tx *storage.Tx) error {
+		if err := tx.Delete(boltBucket, key); err != nil {
+			return err
+		}
+		return tx.Delete(activityBucket, key)
+	})
+}
+
+func (b *DiskBackend) Idle(cutoff time.Time) ([]int64, error) {
+	var ids []int64
+	err := b.activity.ForEach(func(key string, raw []byte) error {
+		var last time.Time
+		if err := json.Unmarshal(raw, &last); err != nil {
+			return fmt.Errorf("decode session activity %s: %w", key, err)
+		}
+		if !last.Before(cutoff) {
+			return nil
+		}
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse session key %q: %w", key, err)
+		}
+		ids = append(ids, userID)
+		return nil
+	})
+	return ids, err
+}
+
+func (b *DiskBackend) ForEach(fn func(Session) error) error {
+	var sessions []Session
+	err := b.bucket.ForEach(func(_ string, raw []byte) error {
+		var sess Session
+		if err := json.Unmarshal(raw, &sess); err != nil {
+			return nil
+		}
+		sessions = append(sessions, sess)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sess := range sessions {
+		if err := fn(sess); err != nil {
+			return err
+		}
+	}
+	return nil
+}
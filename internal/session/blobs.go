@@ -0,0 +1,112 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStore keeps image data URLs on disk, addressed by their SHA-256 hash, so
+// history records only carry short references.
+type BlobStore struct {
+	dir string
+}
+
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, errors.New("blob dir is empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// Put stores the data URL and returns its reference; identical payloads share one file.
+func (b *BlobStore) Put(dataURL string) (string, error) {
+	sum := sha256.Sum256([]byte(dataURL))
+	ref := hex.EncodeToString(sum[:])
+
+	path := b.path(ref)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		return ref, nil
+	}
+
+	tmp, err := os.CreateTemp(b.dir, ".blob-*")
+	if err != nil {
+		return "", fmt.Errorf("create blob: %w", err)
+	}
+	if _, err := tmp.WriteString(dataURL); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("store blob: %w", err)
+	}
+	return ref, nil
+}
+
+func (b *BlobStore) Get(ref string) (string, error) {
+	if !isBlobRef(ref) {
+		return "", fmt.Errorf("invalid blob ref %q", ref)
+	}
+	raw, err := os.ReadFile(b.path(ref))
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// Prune removes blobs that are not in live and are older than minAge (so
+// blobs written by in-flight appends are never removed).
+func (b *BlobStore) Prune(live map[string]struct{}, minAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-minAge)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isBlobRef(name) {
+			continue
+		}
+		if _, ok := live[name]; ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.dir, name)); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (b *BlobStore) path(ref string) string {
+	return filepath.Join(b.dir, ref)
+}
+
+func isBlobRef(ref string) bool {
+	if len(ref) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(ref)
+	return err == nil
+}
@@ -1,14 +1,21 @@
 package session
 
 import (
+	"io"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
+
+	"pro-banana-ai-bot/internal/metrics"
 )
 
 type HistoryMessage struct {
 	Role      string
 	Content   string
-	ImageURLs []string
+	ImageURLs []string `json:",omitempty"`
+	// ImageRefs point into the BlobStore; Snapshot resolves them back into ImageURLs.
+	ImageRefs []string `json:",omitempty"`
 }
 
 type Session struct {
@@ -20,12 +27,34 @@ type Session struct {
 
 type Options struct {
 	MaxMessages int
+
+	// Backend defaults to an in-memory backend.
+	Backend Backend
+	// Blobs, when set, stores image payloads out-of-line instead of in history.
+	Blobs *BlobStore
+
+	// TTL drops sessions idle for longer than this; zero keeps them forever.
+	TTL time.Duration
+	// MaxCacheBytes caps the in-memory cache; least recently active sessions
+	// are evicted first and reload from the backend on next use.
+	MaxCacheBytes int64
+
+	Logger *slog.Logger
 }
 
 type Store struct {
 	mu         sync.Mutex
 	sessions   map[int64]*Session
 	maxHistory int
+	backend    Backend
+	blobs      *BlobStore
+	ttl        time.Duration
+	maxBytes   int64
+	logger     *slog.Logger
+
+	// deleting holds users whose expired session Sweep is removing from the
+	// backend; they are not reloaded from it meanwhile.
+	deleting map[int64]bool
 }
 
 func NewStore(opts Options) *Store {
@@ -34,9 +63,25 @@ func NewStore(opts Options) *Store {
 		maxHistory = 20
 	}
 
+	backend := opts.Backend
+	if backend == nil {
+		backend = NewMemoryBackend()
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
 	return &Store{
 		sessions:   make(map[int64]*Session),
+		deleting:   make(map[int64]bool),
 		maxHistory: maxHistory,
+		backend:    backend,
+		blobs:      opts.Blobs,
+		ttl:        opts.TTL,
+		maxBytes:   opts.MaxCacheBytes,
+		logger:     logger,
 	}
 }
 
@@ -44,22 +89,25 @@ func (s *Store) Clear(userID int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if sess, ok := s.sessions[userID]; ok {
-		sess.History = nil
-		sess.LastActivity = time.Now()
+	sess := s.loadLocked(userID)
+	if sess == nil {
+		return
 	}
+	sess.History = nil
+	sess.LastActivity = time.Now()
+	s.saveLocked(sess)
 }
 
 func (s *Store) Snapshot(userID int64, username string) []HistoryMessage {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	sess := s.getOrCreateLocked(userID, username)
 	sess.LastActivity = time.Now()
 
 	history := make([]HistoryMessage, len(sess.History))
 	copy(history, sess.History)
-	return history
+	s.mu.Unlock()
+
+	return s.resolveImages(history)
 }
 
 func (s *Store) Append(userID int64, username string, msgs ...HistoryMessage) {
@@ -67,6 +115,8 @@ func (s *Store) Append(userID int64, username string, msgs ...HistoryMessage) {
 		return
 	}
 
+	msgs = s.storeImages(msgs)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -77,10 +127,212 @@ func (s *Store) Append(userID int64, username string, msgs ...HistoryMessage) {
 	if len(sess.History) > s.maxHistory {
 		sess.History = sess.History[len(sess.History)-s.maxHistory:]
 	}
+	s.saveLocked(sess)
 }
 
-func (s *Store) getOrCreateLocked(userID int64, username string) *Session {
+var (
+	evictedTTL = metrics.NewCounter("session_evictions_ttl")
+	evictedLRU = metrics.NewCounter("session_evictions_lru")
+	cachedSize = metrics.NewGauge("session_cache_bytes")
+)
+
+// Sweep deletes sessions idle longer than the TTL from cache and backend, then
+// trims the in-memory cache to MaxCacheBytes.
+func (s *Store) Sweep() (expired int, evicted int) {
+	now := time.Now()
+	ttl, maxCacheBytes := s.ttl, s.maxBytes
+
+	if ttl > 0 {
+		stale, err := s.backend.Idle(now.Add(-ttl))
+		if err != nil {
+			s.logger.Error("session sweep failed", "err", err)
+		}
+
+		s.mu.Lock()
+		for userID, sess := range s.sessions {
+			if now.Sub(sess.LastActivity) > ttl {
+				stale = append(stale, userID)
+			}
+		}
+		var victims []int64
+		for _, userID := range uniqueIDs(stale) {
+			// Re-check: the user may have come back since the backend scan.
+			if sess, ok := s.sessions[userID]; ok && now.Sub(sess.LastActivity) <= ttl {
+				continue
+			}
+			delete(s.sessions, userID)
+			s.deleting[userID] = true
+			victims = append(victims, userID)
+		}
+		s.mu.Unlock()
+
+		// Backend deletes run unlocked so a slow disk does not stall chats.
+		for _, userID := range victims {
+			err := s.backend.Delete(userID)
+
+			s.mu.Lock()
+			delete(s.deleting, userID)
+			// A user who came back meanwhile started afresh; keep that.
+			if sess, ok := s.sessions[userID]; ok {
+				s.saveLocked(sess)
+			}
+			s.mu.Unlock()
+
+			if err != nil {
+				s.logger.Error("session delete failed", "user_id", userID, "err", err)
+				continue
+			}
+			expired++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type entry struct {
+		userID int64
+		last   time.Time
+		size   int64
+	}
+	entries := make([]entry, 0, len(s.sessions))
+	var total int64
+	for userID, sess := range s.sessions {
+		size := sess.approxSize()
+		total += size
+		entries = append(entries, entry{userID: userID, last: sess.LastActivity, size: size})
+	}
+
+	if maxCacheBytes > 0 && total > maxCacheBytes {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].last.Before(entries[j].last) })
+		for _, e := range entries {
+			if total <= maxCacheBytes {
+				break
+			}
+			delete(s.sessions, e.userID)
+			total -= e.size
+			evicted++
+		}
+	}
+
+	evictedTTL.Add(int64(expired))
+	evictedLRU.Add(int64(evicted))
+	cachedSize.Set(total)
+	return expired, evicted
+}
+
+// PruneBlobs deletes blobs no longer referenced by any stored session.
+func (s *Store) PruneBlobs(minAge time.Duration) (int, error) {
+	if s.blobs == nil {
+		return 0, nil
+	}
+
+	live := make(map[string]struct{})
+	err := s.backend.ForEach(func(sess Session) error {
+		for _, m := range sess.History {
+			for _, ref := range m.ImageRefs {
+				live[ref] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	for _, sess := range s.sessions {
+		for _, m := range sess.History {
+			for _, ref := range m.ImageRefs {
+				live[ref] = struct{}{}
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	return s.blobs.Prune(live, minAge)
+}
+
+// storeImages moves inline data URLs into the blob store. On failure the
+// image stays inline so no turn is lost.
+func (s *Store) storeImages(msgs []HistoryMessage) []HistoryMessage {
+	if s.blobs == nil {
+		return msgs
+	}
+
+	out := make([]HistoryMessage, len(msgs))
+	for i, m := range msgs {
+		var inline []string
+		refs := append([]string(nil), m.ImageRefs...)
+		for _, url := range m.ImageURLs {
+			ref, err := s.blobs.Put(url)
+			if err != nil {
+				s.logger.Error("history blob store failed", "err", err)
+				inline = append(inline, url)
+				continue
+			}
+			refs = append(refs, ref)
+		}
+		m.ImageURLs = inline
+		m.ImageRefs = refs
+		out[i] = m
+	}
+	return out
+}
+
+func (s *Store) resolveImages(history []HistoryMessage) []HistoryMessage {
+	if s.blobs == nil {
+		return history
+	}
+
+	for i, m := range history {
+		if len(m.ImageRefs) == 0 {
+			continue
+		}
+		urls := append([]string(nil), m.ImageURLs...)
+		for _, ref := range m.ImageRefs {
+			url, err := s.blobs.Get(ref)
+			if err != nil {
+				s.logger.Warn("history blob missing", "ref", ref, "err", err)
+				continue
+			}
+			urls = append(urls, url)
+		}
+		history[i].ImageURLs = urls
+		history[i].ImageRefs = nil
+	}
+	return history
+}
+
+// loadLocked returns the cached session, loading it from the backend on first use.
+func (s *Store) loadLocked(userID int64) *Session {
 	if sess, ok := s.sessions[userID]; ok {
+		return sess
+	}
+	if s.deleting[userID] {
+		return nil
+	}
+
+	stored, ok, err := s.backend.Load(userID)
+	if err != nil {
+		s.logger.Error("session load failed", "user_id", userID, "err", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	sess := &stored
+	s.sessions[userID] = sess
+	return sess
+}
+
+func (s *Store) saveLocked(sess *Session) {
+	if err := s.backend.Save(sess.clone()); err != nil {
+		s.logger.Error("session save failed", "user_id", sess.UserID, "err", err)
+	}
+}
+
+func (s *Store) getOrCreateLocked(userID int64, username string) *Session {
+	if sess := s.loadLocked(userID); sess != nil {
 		if sess.Username == "" && username != "" {
 			sess.Username = username
 		}
@@ -96,3 +348,40 @@ func (s *Store) getOrCreateLocked(userID int64, username string) *Session {
 	s.sessions[userID] = sess
 	return sess
 }
+
+// approxSize estimates the memory held by the session's history.
+func (s *Session) approxSize() int64 {
+	size := int64(64 + len(s.Username))
+	for _, m := range s.History {
+		size += int64(len(m.Role) + len(m.Content) + 48)
+		for _, url := range m.ImageURLs {
+			size += int64(len(url))
+		}
+		size += int64(len(m.ImageRefs) * 64)
+	}
+	return size
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
+}
+
+func (s Session) clone() Session {
+	history := make([]HistoryMessage, len(s.History))
+	for i, m := range s.History {
+		m.ImageURLs = append([]string(nil), m.ImageURLs...)
+		m.ImageRefs = append([]string(nil), m.ImageRefs...)
+		history[i] = m
+	}
+	s.History = history
+	return s
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+// slowDelete blocks Delete until release is closed.
+type slowDelete struct {
+	*MemoryBackend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *slowDelete) Delete(userID int64) error {
+	close(b.started)
+	<-b.release
+	return b.MemoryBackend.Delete(userID)
+}
+
+func TestSweepDeletesOutsideLock(t *testing.T) {
+	backend := &slowDelete{MemoryBackend: NewMemoryBackend(), started: make(chan struct{}), release: make(chan struct{})}
+	old := Session{UserID: 1, History: []HistoryMessage{{Role: "user", Content: "eski"}}, LastActivity: time.Now().Add(-2 * time.Hour)}
+	if err := backend.Save(old); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(Options{Backend: backend, TTL: time.Hour})
+
+	done := make(chan int)
+	go func() {
+		expired, _ := store.Sweep()
+		done <- expired
+	}()
+	<-backend.started
+
+	// The store stays usable while the delete is in flight, and a returning
+	// user starts afresh instead of reloading the expired history.
+	store.Append(1, "", HistoryMessage{Role: "user", Content: "yangi"})
+	close(backend.release)
+	if expired := <-done; expired != 1 {
+		t.Errorf("Sweep expired %d, want 1", expired)
+	}
+
+	stored, ok, _ := backend.Load(1)
+	if !ok || len(stored.History) != 1 || stored.History[0].Content != "yangi" {
+		t.Errorf("stored session = %+v, %v; want only the new turn", stored, ok)
+	}
+}
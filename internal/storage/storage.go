@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DB is an embedded bbolt database shared by the bot's durable stores; each
+// store keeps its records as JSON values in its own bucket.
+type DB struct {
+	bolt *bolt.DB
+}
+
+func Open(path string) (*DB, error) {
+	if path == "" {
+		return nil, errors.New("storage path is empty")
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create storage dir: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open storage: %w", err)
+	}
+	return &DB{bolt: db}, nil
+}
+
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+// Bucket returns a handle to the named bucket, creating it if needed.
+func (db *DB) Bucket(name string) (*Bucket, error) {
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket %s: %w", name, err)
+	}
+	return &Bucket{db: db.bolt, name: []byte(name)}, nil
+}
+
+type Bucket struct {
+	db   *bolt.DB
+	name []byte
+}
+
+// Get decodes the value stored under key into v and reports whether it existed.
+func (b *Bucket) Get(key string, v any) (bool, error) {
+	var raw []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(b.name).Get([]byte(key)); data != nil {
+			raw = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	if err != nil || raw == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("decode %s/%s: %w", b.name, key, err)
+	}
+	return true, nil
+}
+
+func (b *Bucket) Put(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s/%s: %w", b.name, key, err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Put([]byte(key), raw)
+	})
+}
+
+func (b *Bucket) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn for every record in key order; fn must not retain raw.
+func (b *Bucket) ForEach(fn func(key string, raw []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// Tx gives JSON access to several buckets inside one bolt transaction, for
+// stores that must update related records atomically.
+type Tx struct {
+	tx *bolt.Tx
+}
+
+// Update runs fn in a read-write transaction; buckets are created on first Put.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	})
+}
+
+func (db *DB) View(fn func(tx *Tx) error) error {
+	return db.bolt.View(func(tx *bolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	})
+}
+
+func (t *Tx) Get(bucket, key string, v any) (bool, error) {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return false, nil
+	}
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (t *Tx) Put(bucket, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s/%s: %w", bucket, key, err)
+	}
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return fmt.Errorf("create bucket %s: %w", bucket, err)
+	}
+	return b.Put([]byte(key), raw)
+}
+
+func (t *Tx) Delete(bucket, key string) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+// Scan calls fn for every key with the given prefix, in key order.
+func (t *Tx) Scan(bucket, prefix string, fn func(key string, raw []byte) error) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	p := []byte(prefix)
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if err := fn(string(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
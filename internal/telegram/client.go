@@ -21,12 +21,21 @@ type Options struct {
 	HTTPClient *http.Client
 	Logger     *slog.Logger
 	Debug      bool
+	// APIEndpoint overrides the Bot API URL template (e.g. a fake server in
+	// tests); it must contain two %s for the token and method.
+	APIEndpoint string
+	// FileEndpoint overrides the file download template (token, file path).
+	FileEndpoint string
+	// Pacing limits outbound calls; zero values use Telegram's published limits.
+	Pacing PacingOptions
 }
 
 type Client struct {
-	bot        *tgbotapi.BotAPI
-	httpClient *http.Client
-	logger     *slog.Logger
+	bot          *tgbotapi.BotAPI
+	httpClient   *http.Client
+	logger       *slog.Logger
+	fileEndpoint string
+	pace         *scheduler
 }
 
 func New(opts Options) (*Client, error) {
@@ -37,7 +46,12 @@ func New(opts Options) (*Client, error) {
 		return nil, errors.New("http client is nil")
 	}
 
-	bot, err := tgbotapi.NewBotAPIWithClient(opts.Token, tgbotapi.APIEndpoint, opts.HTTPClient)
+	endpoint := opts.APIEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithClient(opts.Token, endpoint, opts.HTTPClient)
 	if err != nil {
 		return nil, err
 	}
@@ -48,13 +62,43 @@ func New(opts Options) (*Client, error) {
 		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
 	}
 
+	fileEndpoint := opts.FileEndpoint
+	if fileEndpoint == "" {
+		fileEndpoint = tgbotapi.FileEndpoint
+	}
+
 	return &Client{
-		bot:        bot,
-		httpClient: opts.HTTPClient,
-		logger:     logger,
+		bot:          bot,
+		httpClient:   opts.HTTPClient,
+		logger:       logger,
+		fileEndpoint: fileEndpoint,
+		pace:         newScheduler(opts.Pacing, logger),
 	}, nil
 }
 
+// send delivers a message-producing call to chatID within the pacing limits.
+func (c *Client) send(ctx context.Context, chatID int64, cfg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return c.sendWith(ctx, chatID, PriorityBulk, cfg)
+}
+
+func (c *Client) sendWith(ctx context.Context, chatID int64, prio Priority, cfg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	err := c.pace.do(ctx, chatID, prio, func() error {
+		var err error
+		sent, err = c.bot.Send(cfg)
+		return err
+	})
+	return sent, err
+}
+
+// request makes a call whose result is only ok/error.
+func (c *Client) request(ctx context.Context, chatID int64, prio Priority, cfg tgbotapi.Chattable) error {
+	return c.pace.do(ctx, chatID, prio, func() error {
+		_, err := c.bot.Request(cfg)
+		return err
+	})
+}
+
 func (c *Client) Username() string {
 	return c.bot.Self.UserName
 }
@@ -79,22 +123,41 @@ func (c *Client) StopUpdates() {
 	c.bot.StopReceivingUpdates()
 }
 
-func (c *Client) SendTyping(chatID int64) {
-	_, _ = c.bot.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
+func (c *Client) SendTyping(ctx context.Context, chatID int64) {
+	_ = c.request(ctx, chatID, PriorityAction, tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
 }
 
-func (c *Client) SendText(chatID int64, text string) error {
+func (c *Client) SendText(ctx context.Context, chatID int64, text string) error {
 	parts := splitByBytes(text, 4096)
 	for _, p := range parts {
 		msg := tgbotapi.NewMessage(chatID, p)
-		if _, err := c.bot.Send(msg); err != nil {
+		if _, err := c.send(ctx, chatID, msg); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *Client) SendTextWithKeyboard(chatID int64, text string, kb tgbotapi.InlineKeyboardMarkup) (int, error) {
+func (c *Client) SendTextMessage(ctx context.Context, chatID int64, text string) (int, error) {
+	parts := splitByBytes(text, 4096)
+	lastID := 0
+	for _, p := range parts {
+		sent, err := c.send(ctx, chatID, tgbotapi.NewMessage(chatID, p))
+		if err != nil {
+			return 0, err
+		}
+		lastID = sent.MessageID
+	}
+	return lastID, nil
+}
+
+func (c *Client) EditText(ctx context.Context, chatID int64, messageID int, text string) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, truncateByBytes(text, 4096))
+	_, err := c.send(ctx, chatID, edit)
+	return err
+}
+
+func (c *Client) SendTextWithKeyboard(ctx context.Context, chatID int64, text string, kb tgbotapi.InlineKeyboardMarkup) (int, error) {
 	parts := splitByBytes(text, 4096)
 	lastID := 0
 	for i, p := range parts {
@@ -102,7 +165,7 @@ func (c *Client) SendTextWithKeyboard(chatID int64, text string, kb tgbotapi.Inl
 		if i == len(parts)-1 {
 			msg.ReplyMarkup = kb
 		}
-		sent, err := c.bot.Send(msg)
+		sent, err := c.send(ctx, chatID, msg)
 		if err != nil {
 			return 0, err
 		}
@@ -111,22 +174,52 @@ func (c *Client) SendTextWithKeyboard(chatID int64, text string, kb tgbotapi.Inl
 	return lastID, nil
 }
 
-func (c *Client) EditTextWithKeyboard(chatID int64, messageID int, text string, kb tgbotapi.InlineKeyboardMarkup) error {
+func (c *Client) EditTextWithKeyboard(ctx context.Context, chatID int64, messageID int, text string, kb tgbotapi.InlineKeyboardMarkup) error {
 	text = truncateByBytes(text, 4096)
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
 	edit.ReplyMarkup = &kb
-	_, err := c.bot.Send(edit)
+	// Menu edits answer a button press, so they do not wait behind uploads.
+	_, err := c.sendWith(ctx, chatID, PriorityUrgent, edit)
 	return err
 }
 
-func (c *Client) AnswerCallback(callbackID string, text string, showAlert bool) error {
+func (c *Client) AnswerCallback(ctx context.Context, callbackID string, text string, showAlert bool) error {
 	cb := tgbotapi.NewCallback(callbackID, truncateByBytes(text, 180))
 	cb.ShowAlert = showAlert
-	_, err := c.bot.Request(cb)
+	return c.request(ctx, 0, PriorityUrgent, cb)
+}
+
+type LabeledPrice = tgbotapi.LabeledPrice
+
+type Invoice struct {
+	Title       string
+	Description string
+	Payload     string
+	// Currency "XTR" (Telegram Stars) needs no provider token.
+	Currency      string
+	ProviderToken string
+	Prices        []LabeledPrice
+}
+
+func (c *Client) SendInvoice(ctx context.Context, chatID int64, inv Invoice) error {
+	cfg := tgbotapi.NewInvoice(chatID, inv.Title, inv.Description, inv.Payload, inv.ProviderToken, "", inv.Currency, inv.Prices)
+	cfg.SuggestedTipAmounts = []int{}
+	_, err := c.send(ctx, chatID, cfg)
 	return err
 }
 
-func (c *Client) SendPhotoDataURL(chatID int64, dataURL string, caption string) error {
+// AnswerPreCheckout must be called within 10 seconds of the query.
+func (c *Client) AnswerPreCheckout(ctx context.Context, queryID string, ok bool, errorMessage string) error {
+	return c.request(ctx, 0, PriorityUrgent, tgbotapi.PreCheckoutConfig{
+		PreCheckoutQueryID: queryID,
+		OK:                 ok,
+		ErrorMessage:       errorMessage,
+	})
+}
+
+// SendDocumentDataURL sends the image as a file so Telegram keeps it byte-exact
+// (no recompression, transparency preserved).
+func (c *Client) SendDocumentDataURL(ctx context.Context, chatID int64, dataURL string, filename string, caption string) error {
 	mimeType, base64Data, err := parseDataURL(dataURL)
 	if err != nil {
 		return err
@@ -137,48 +230,176 @@ func (c *Client) SendPhotoDataURL(chatID int64, dataURL string, caption string)
 		return fmt.Errorf("decode base64: %w", err)
 	}
 
-	name := "image.jpg"
-	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
-		name = "image" + exts[0]
+	if strings.TrimSpace(filename) == "" {
+		filename = "image.png"
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			filename = "image" + exts[0]
+		}
 	}
 
-	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
-		Name:  name,
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  filename,
 		Bytes: bytes,
 	})
 	if caption != "" {
-		photo.Caption = truncateByBytes(caption, 1024)
+		doc.Caption = truncateByBytes(caption, 1024)
 	}
 
-	_, err = c.bot.Send(photo)
+	_, err = c.send(ctx, chatID, doc)
 	return err
 }
 
-func (c *Client) DownloadFileBase64(ctx context.Context, fileID string) (string, string, error) {
-	fileURL, err := c.bot.GetFileDirectURL(fileID)
+// maxAlbum is Telegram's limit of items per media group.
+const maxAlbum = 10
+
+// MediaFile is one image of an album.
+type MediaFile struct {
+	DataURL string
+	// FileID resends a file already on Telegram instead of uploading DataURL.
+	FileID string
+	// Filename names the file when sent as a document; defaults from the MIME type.
+	Filename string
+}
+
+// SendAlbum sends images as media groups of up to 10 with caption on the
+// first item. asDocuments sends them as files so Telegram does not
+// recompress them. It returns the Telegram file IDs of the sent items.
+func (c *Client) SendAlbum(ctx context.Context, chatID int64, files []MediaFile, caption string, asDocuments bool) ([]string, error) {
+	fileIDs := make([]string, 0, len(files))
+	for start := 0; start < len(files); start += maxAlbum {
+		chunk := files[start:min(start+maxAlbum, len(files))]
+		chunkCaption := ""
+		if start == 0 {
+			chunkCaption = truncateByBytes(caption, 1024)
+		}
+
+		media := make([]interface{}, 0, len(chunk))
+		for i, f := range chunk {
+			data, err := fileData(f, start+i)
+			if err != nil {
+				return fileIDs, err
+			}
+			if asDocuments {
+				doc := tgbotapi.NewInputMediaDocument(data)
+				if i == 0 {
+					doc.Caption = chunkCaption
+				}
+				media = append(media, doc)
+			} else {
+				photo := tgbotapi.NewInputMediaPhoto(data)
+				if i == 0 {
+					photo.Caption = chunkCaption
+				}
+				media = append(media, photo)
+			}
+		}
+
+		// A media group needs at least two items.
+		if len(media) == 1 {
+			var cfg tgbotapi.Chattable
+			if asDocuments {
+				doc := tgbotapi.NewDocument(chatID, media[0].(tgbotapi.InputMediaDocument).Media)
+				doc.Caption = chunkCaption
+				cfg = doc
+			} else {
+				photo := tgbotapi.NewPhoto(chatID, media[0].(tgbotapi.InputMediaPhoto).Media)
+				photo.Caption = chunkCaption
+				cfg = photo
+			}
+			sent, err := c.send(ctx, chatID, cfg)
+			if err != nil {
+				return fileIDs, err
+			}
+			fileIDs = append(fileIDs, sentFileID(sent))
+			continue
+		}
+
+		var sent []tgbotapi.Message
+		err := c.pace.do(ctx, chatID, PriorityBulk, func() error {
+			var err error
+			sent, err = c.bot.SendMediaGroup(tgbotapi.NewMediaGroup(chatID, media))
+			return err
+		})
+		if err != nil {
+			return fileIDs, err
+		}
+		for _, m := range sent {
+			fileIDs = append(fileIDs, sentFileID(m))
+		}
+	}
+	return fileIDs, nil
+}
+
+func fileData(f MediaFile, index int) (tgbotapi.RequestFileData, error) {
+	if f.FileID != "" {
+		return tgbotapi.FileID(f.FileID), nil
+	}
+	mimeType, base64Data, err := parseDataURL(f.DataURL)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+
+	name := strings.TrimSpace(f.Filename)
+	if name == "" {
+		ext := ".jpg"
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+		name = fmt.Sprintf("image_%02d%s", index+1, ext)
+	}
+	return tgbotapi.FileBytes{Name: name, Bytes: bytes}, nil
+}
+
+// sentFileID returns the file ID of the largest photo size or the document.
+func sentFileID(m tgbotapi.Message) string {
+	if n := len(m.Photo); n > 0 {
+		return m.Photo[n-1].FileID
+	}
+	if m.Document != nil {
+		return m.Document.FileID
+	}
+	return ""
+}
+
+// Download is a file fetched from Telegram.
+type Download struct {
+	Base64   string
+	MimeType string
+	// UniqueID is Telegram's file_unique_id, which unlike the file ID stays
+	// the same over time.
+	UniqueID string
+}
+
+func (c *Client) DownloadFile(ctx context.Context, fileID string) (Download, error) {
+	file, err := c.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
 	if err != nil {
-		return "", "", err
+		return Download{}, err
 	}
+	fileURL := fmt.Sprintf(c.fileEndpoint, c.bot.Token, file.FilePath)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
 	if err != nil {
-		return "", "", err
+		return Download{}, err
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", "", err
+		return Download{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", "", fmt.Errorf("telegram file download %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return Download{}, fmt.Errorf("telegram file download %s: %s", resp.Status, strings.TrimSpace(string(body)))
 	}
 
 	bytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", err
+		return Download{}, err
 	}
 
 	mimeType := strings.TrimSpace(resp.Header.Get("content-type"))
@@ -195,7 +416,11 @@ func (c *Client) DownloadFileBase64(ctx context.Context, fileID string) (string,
 		mimeType = "image/jpeg"
 	}
 
-	return base64.StdEncoding.EncodeToString(bytes), mimeType, nil
+	return Download{
+		Base64:   base64.StdEncoding.EncodeToString(bytes),
+		MimeType: mimeType,
+		UniqueID: file.FileUniqueID,
+	}, nil
 }
 
 func parseDataURL(value string) (mimeType string, base64Data string, err error) {
@@ -0,0 +1,346 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/metrics"
+)
+
+var (
+	floodWaitCount  = metrics.NewCounter("telegram_flood_waits")
+	floodGiveUps    = metrics.NewCounter("telegram_flood_giveups")
+	outboundWaiting = metrics.NewGauge("telegram_outbound_waiting")
+)
+
+// Priority orders calls waiting for the global rate limit.
+type Priority int
+
+const (
+	// PriorityBulk is for messages, photos and progress edits.
+	PriorityBulk Priority = iota
+	// PriorityUrgent is for answers Telegram expects within seconds
+	// (callback and pre-checkout queries) and edits of the menu a user is
+	// clicking; they skip per-chat pacing and jump ahead of bulk sends, but
+	// still wait out a chat's flood pause.
+	PriorityUrgent
+	// PriorityAction is for chat actions such as "typing". They are best
+	// effort: no per-chat pacing, skipped while the chat is paused, and a
+	// 429 drops them instead of pausing anything.
+	PriorityAction
+)
+
+// PacingOptions tunes outbound rate limits. Telegram allows about 30
+// messages per second overall and about one per second in a single chat,
+// with short bursts tolerated.
+type PacingOptions struct {
+	// GlobalRate is calls per second across all chats (default 30).
+	GlobalRate float64
+	// ChatRate is messages per second into one chat (default 1).
+	ChatRate float64
+	// ChatBurst is how many messages a quiet chat may receive at once
+	// (default 3).
+	ChatBurst int
+	// MaxRetries bounds retries after "429 Too Many Requests" (default 3;
+	// negative disables retries).
+	MaxRetries int
+	// MaxRetryWait is the longest retry_after honoured; longer waits fail
+	// the call instead (default 60s).
+	MaxRetryWait time.Duration
+}
+
+// scheduler paces outbound Bot API calls and retries flood-limited ones.
+type scheduler struct {
+	opts   PacingOptions
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	tokens     float64
+	refilledAt time.Time
+	urgent     []*waiter
+	bulk       []*waiter
+	timer      *time.Timer
+	// chats is keyed by chat ID; chatless calls share key 0, so a 429 on
+	// them pauses only other chatless calls.
+	chats map[int64]*chatBucket
+}
+
+type waiter struct {
+	ready    chan struct{}
+	granted  bool
+	canceled bool
+}
+
+type chatBucket struct {
+	tokens     float64
+	refilledAt time.Time
+	pausedTill time.Time
+}
+
+// pruneChatsAbove bounds the per-chat map; idle chats are forgotten.
+const pruneChatsAbove = 4096
+
+func newScheduler(opts PacingOptions, logger *slog.Logger) *scheduler {
+	if opts.GlobalRate <= 0 {
+		opts.GlobalRate = 30
+	}
+	if opts.ChatRate <= 0 {
+		opts.ChatRate = 1
+	}
+	if opts.ChatBurst < 1 {
+		opts.ChatBurst = 3
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.MaxRetryWait <= 0 {
+		opts.MaxRetryWait = 60 * time.Second
+	}
+
+	return &scheduler{
+		opts:       opts,
+		logger:     logger,
+		tokens:     opts.GlobalRate,
+		refilledAt: time.Now(),
+		chats:      make(map[int64]*chatBucket),
+	}
+}
+
+// do runs call once the chat and global limits allow it, retrying when
+// Telegram answers 429. chatID 0 means the call is not tied to a chat.
+func (s *scheduler) do(ctx context.Context, chatID int64, prio Priority, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		wait := s.pausedFor(chatID)
+		if chatID != 0 && prio == PriorityBulk {
+			wait = s.reserveChat(chatID)
+		}
+		if wait > 0 && prio == PriorityAction {
+			// The action would be stale by the time the pause ends.
+			return nil
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+		if err := s.acquire(ctx, prio); err != nil {
+			return err
+		}
+
+		err := call()
+		wait, limited := retryAfter(err)
+		if !limited {
+			return err
+		}
+
+		floodWaitCount.Inc()
+		if prio == PriorityAction {
+			return err
+		}
+		if attempt >= s.opts.MaxRetries || wait > s.opts.MaxRetryWait {
+			floodGiveUps.Inc()
+			s.logger.Warn("telegram flood limit, giving up", "chat_id", chatID, "retry_after", wait, "attempt", attempt+1)
+			return err
+		}
+		s.logger.Warn("telegram flood limit, retrying", "chat_id", chatID, "retry_after", wait, "attempt", attempt+1)
+		s.pause(chatID, wait)
+	}
+}
+
+// reserveChat takes one message from the chat's bucket and returns how long
+// the caller must wait for it. Reservations may run the bucket negative, so
+// concurrent senders to one chat queue up in turn.
+func (s *scheduler) reserveChat(chatID int64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.chats[chatID]
+	if !ok {
+		if len(s.chats) >= pruneChatsAbove {
+			s.pruneChatsLocked(now)
+		}
+		b = &chatBucket{tokens: float64(s.opts.ChatBurst), refilledAt: now}
+		s.chats[chatID] = b
+	}
+
+	b.tokens = min(float64(s.opts.ChatBurst), b.tokens+now.Sub(b.refilledAt).Seconds()*s.opts.ChatRate)
+	b.refilledAt = now
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / s.opts.ChatRate * float64(time.Second))
+	}
+	if paused := b.pausedTill.Sub(now); paused > wait {
+		wait = paused
+	}
+	return wait
+}
+
+func (s *scheduler) pruneChatsLocked(now time.Time) {
+	for id, b := range s.chats {
+		full := b.tokens+now.Sub(b.refilledAt).Seconds()*s.opts.ChatRate >= float64(s.opts.ChatBurst)
+		if full && now.After(b.pausedTill) {
+			delete(s.chats, id)
+		}
+	}
+}
+
+// pausedFor returns how long the chat is still paused after a 429.
+func (s *scheduler) pausedFor(chatID int64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.chats[chatID]; ok {
+		return max(time.Until(b.pausedTill), 0)
+	}
+	return 0
+}
+
+// pause holds calls to the chat (or, for chatID 0, chatless calls) for d.
+func (s *scheduler) pause(chatID int64, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until := time.Now().Add(d)
+	b, ok := s.chats[chatID]
+	if !ok {
+		b = &chatBucket{refilledAt: time.Now()}
+		s.chats[chatID] = b
+	}
+	if until.After(b.pausedTill) {
+		b.pausedTill = until
+	}
+}
+
+// acquire takes a global token, queueing behind earlier callers of the same
+// priority and behind every urgent caller.
+func (s *scheduler) acquire(ctx context.Context, prio Priority) error {
+	s.mu.Lock()
+	now := time.Now()
+	s.refillLocked(now)
+	if s.tokens >= 1 && len(s.urgent) == 0 && (prio == PriorityUrgent || len(s.bulk) == 0) {
+		s.tokens--
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	if prio == PriorityUrgent {
+		s.urgent = append(s.urgent, w)
+	} else {
+		s.bulk = append(s.bulk, w)
+	}
+	outboundWaiting.Add(1)
+	s.scheduleLocked()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.granted {
+			return nil
+		}
+		w.canceled = true
+		return ctx.Err()
+	}
+}
+
+func (s *scheduler) refillLocked(now time.Time) {
+	s.tokens = min(s.opts.GlobalRate, s.tokens+now.Sub(s.refilledAt).Seconds()*s.opts.GlobalRate)
+	s.refilledAt = now
+}
+
+// scheduleLocked arms the grant timer for when the next token is due.
+func (s *scheduler) scheduleLocked() {
+	if s.timer != nil {
+		return
+	}
+	wait := time.Duration((1 - s.tokens) / s.opts.GlobalRate * float64(time.Second))
+	s.timer = time.AfterFunc(max(wait, 0), s.grant)
+}
+
+func (s *scheduler) grant() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = nil
+	s.refillLocked(time.Now())
+	for s.tokens >= 1 {
+		w := s.popLocked()
+		if w == nil {
+			return
+		}
+		outboundWaiting.Add(-1)
+		if w.canceled {
+			continue
+		}
+		w.granted = true
+		close(w.ready)
+		s.tokens--
+	}
+	if len(s.urgent)+len(s.bulk) > 0 {
+		s.scheduleLocked()
+	}
+}
+
+func (s *scheduler) popLocked() *waiter {
+	queue := &s.bulk
+	if len(s.urgent) > 0 {
+		queue = &s.urgent
+	}
+	if len(*queue) == 0 {
+		return nil
+	}
+	w := (*queue)[0]
+	(*queue)[0] = nil
+	*queue = (*queue)[1:]
+	return w
+}
+
+var retryAfterText = regexp.MustCompile(`retry after (\d+)`)
+
+// retryAfter reports whether err is a 429 and how long Telegram asked to
+// wait. It reads parameters.retry_after and falls back to the description.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	if apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	if m := retryAfterText.FindStringSubmatch(apiErr.Message); m != nil {
+		secs, _ := strconv.Atoi(m[1])
+		return time.Duration(secs) * time.Second, true
+	}
+	if apiErr.Code == 429 {
+		return time.Second, true
+	}
+	return 0, false
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,83 @@
+package telegram_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/telegram"
+	"pro-banana-ai-bot/internal/telegram/telegramtest"
+)
+
+var flood = telegramtest.Failure{Code: 429, Description: "Too Many Requests: retry after 1", RetryAfter: 1}
+
+func newClient(t *testing.T) (*telegram.Client, *telegramtest.Server) {
+	t.Helper()
+	srv := telegramtest.NewServer()
+	t.Cleanup(srv.Close)
+	opts := srv.ClientOptions()
+	opts.Pacing = telegram.PacingOptions{GlobalRate: 1000, ChatRate: 1000, ChatBurst: 100}
+	c, err := telegram.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestUrgentRetryWaitsForChatPause(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Fail("editMessageText", flood)
+
+	start := time.Now()
+	kb := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("ok", "ok")))
+	if err := c.EditTextWithKeyboard(context.Background(), 1, 1, "menu", kb); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the 1s pause", elapsed)
+	}
+	if n := len(srv.Calls("editMessageText")); n != 2 {
+		t.Errorf("editMessageText called %d times, want 2", n)
+	}
+
+}
+
+func TestChatlessFloodDoesNotPauseChats(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Fail("answerCallbackQuery", flood)
+
+	done := make(chan error, 1)
+	go func() { done <- c.AnswerCallback(context.Background(), "cb", "", false) }()
+	for len(srv.Calls("answerCallbackQuery")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := c.SendText(context.Background(), 1, "salom"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("chat message waited %v behind a callback flood pause", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("callback answer after retry: %v", err)
+	}
+}
+
+func TestCanceledContextInterruptsPacedWait(t *testing.T) {
+	c, srv := newClient(t)
+	srv.Fail("sendMessage", telegramtest.Failure{Code: 429, Description: "Too Many Requests: retry after 30", RetryAfter: 30})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.SendText(ctx, 1, "salom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendText = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SendText returned after %v, want it cut short by ctx", elapsed)
+	}
+}
@@ -0,0 +1,370 @@
+// Package telegramtest runs a local fake of the Telegram Bot API so handlers
+// can be exercised end to end without network access. Point a real
+// telegram.Client at it with NewClient, feed updates to the handler, then
+// inspect what the bot sent with Calls and Sent.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/telegram"
+)
+
+// Token is accepted by the server; any other token gets 401.
+const Token = "123456:TEST"
+
+// BotUsername is what getMe reports.
+const BotUsername = "test_bot"
+
+// Call is one Bot API request as the server received it.
+type Call struct {
+	Method string
+	Params url.Values
+	// Files holds uploaded multipart files by field name.
+	Files map[string][]byte
+	// MessageID is the ID the server assigned when the call created a message.
+	MessageID int
+}
+
+// ChatID parses the chat_id parameter.
+func (c Call) ChatID() int64 {
+	id, _ := strconv.ParseInt(c.Params.Get("chat_id"), 10, 64)
+	return id
+}
+
+// Text returns the text or caption sent with the call; for albums, the
+// caption of the first item.
+func (c Call) Text() string {
+	if t := c.Params.Get("text"); t != "" {
+		return t
+	}
+	if raw := c.Params.Get("media"); raw != "" {
+		var media []struct {
+			Caption string `json:"caption"`
+		}
+		if json.Unmarshal([]byte(raw), &media) == nil && len(media) > 0 {
+			return media[0].Caption
+		}
+	}
+	return c.Params.Get("caption")
+}
+
+// Keyboard decodes reply_markup, if any.
+func (c Call) Keyboard() (tgbotapi.InlineKeyboardMarkup, bool) {
+	var kb tgbotapi.InlineKeyboardMarkup
+	raw := c.Params.Get("reply_markup")
+	if raw == "" || json.Unmarshal([]byte(raw), &kb) != nil {
+		return kb, false
+	}
+	return kb, true
+}
+
+// Failure is an error the server returns instead of handling a call.
+type Failure struct {
+	Code        int
+	Description string
+	// RetryAfter is reported in parameters.retry_after, as on 429 responses.
+	RetryAfter int
+}
+
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	calls    []Call
+	files    map[string][]byte
+	failures map[string][]Failure
+	nextMsg  int
+	nextFile int
+}
+
+// NewServer starts the fake; Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		files:    make(map[string][]byte),
+		failures: make(map[string][]Failure),
+		nextMsg:  1000,
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Endpoint is the telegram.Options.APIEndpoint template for this server.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + "/bot%s/%s"
+}
+
+// FileEndpoint is the telegram.Options.FileEndpoint template for this server.
+func (s *Server) FileEndpoint() string {
+	return s.srv.URL + "/file/bot%s/%s"
+}
+
+// ClientOptions points telegram.Options at this server; adjust them (e.g.
+// Pacing) before calling telegram.New.
+func (s *Server) ClientOptions() telegram.Options {
+	return telegram.Options{
+		Token:        Token,
+		HTTPClient:   s.srv.Client(),
+		APIEndpoint:  s.Endpoint(),
+		FileEndpoint: s.FileEndpoint(),
+	}
+}
+
+// NewClient returns a telegram.Client talking to this server.
+func (s *Server) NewClient() (*telegram.Client, error) {
+	return telegram.New(s.ClientOptions())
+}
+
+// AddFile makes fileID downloadable through getFile.
+func (s *Server) AddFile(fileID string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileID] = append([]byte(nil), data...)
+}
+
+// Fail makes the next call of method fail with f; calls fail once per Fail.
+func (s *Server) Fail(method string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], f)
+}
+
+// Calls returns the recorded calls, limited to methods when given.
+func (s *Server) Calls(methods ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		if len(methods) == 0 || contains(methods, c.Method) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Sent returns calls that put something into chatID: messages, photos,
+// documents, albums and invoices.
+func (s *Server) Sent(chatID int64) []Call {
+	var out []Call
+	for _, c := range s.Calls("sendMessage", "sendPhoto", "sendDocument", "sendMediaGroup", "sendInvoice") {
+		if c.ChatID() == chatID {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Reset forgets recorded calls and pending failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+	s.failures = make(map[string][]Failure)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.serveFile(w, rest)
+		return
+	}
+
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"ok": false, "error_code": 401, "description": "Unauthorized"})
+		return
+	}
+
+	call, err := parseCall(method, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error_code": 400, "description": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	if queued := s.failures[method]; len(queued) > 0 {
+		f := queued[0]
+		s.failures[method] = queued[1:]
+		s.calls = append(s.calls, call)
+		s.mu.Unlock()
+		writeFailure(w, f)
+		return
+	}
+	result, status := s.resultLocked(&call)
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+
+	if status != http.StatusOK {
+		writeJSON(w, status, map[string]any{"ok": false, "error_code": status, "description": result})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": result})
+}
+
+func (s *Server) resultLocked(call *Call) (any, int) {
+	switch call.Method {
+	case "getMe":
+		return tgbotapi.User{ID: 1, IsBot: true, FirstName: "Test", UserName: BotUsername}, http.StatusOK
+	case "sendMessage", "sendPhoto", "sendDocument", "sendInvoice", "editMessageText", "editMessageCaption", "editMessageReplyMarkup":
+		return s.messageLocked(call), http.StatusOK
+	case "sendMediaGroup":
+		var media []struct {
+			Type    string `json:"type"`
+			Media   string `json:"media"`
+			Caption string `json:"caption"`
+		}
+		_ = json.Unmarshal([]byte(call.Params.Get("media")), &media)
+		msgs := make([]tgbotapi.Message, 0, len(media))
+		for _, item := range media {
+			s.nextMsg++
+			msg := tgbotapi.Message{
+				MessageID: s.nextMsg,
+				Date:      int(time.Now().Unix()),
+				Chat:      &tgbotapi.Chat{ID: call.ChatID(), Type: "private"},
+				Caption:   item.Caption,
+			}
+			if data, ok := call.Files[strings.TrimPrefix(item.Media, "attach://")]; ok {
+				s.attachLocked(&msg, item.Type, data)
+			}
+			msgs = append(msgs, msg)
+		}
+		if len(msgs) > 0 {
+			call.MessageID = msgs[0].MessageID
+		}
+		return msgs, http.StatusOK
+	case "getFile":
+		fileID := call.Params.Get("file_id")
+		if _, ok := s.files[fileID]; !ok {
+			return "Bad Request: invalid file_id", http.StatusBadRequest
+		}
+		return tgbotapi.File{FileID: fileID, FileUniqueID: "u-" + fileID, FilePath: "files/" + fileID}, http.StatusOK
+	default:
+		return true, http.StatusOK
+	}
+}
+
+// messageLocked builds the Message a send or edit call returns. Edits keep
+// the edited message's ID.
+func (s *Server) messageLocked(call *Call) tgbotapi.Message {
+	id, _ := strconv.Atoi(call.Params.Get("message_id"))
+	if id == 0 {
+		s.nextMsg++
+		id = s.nextMsg
+	}
+	call.MessageID = id
+
+	msg := tgbotapi.Message{
+		MessageID: id,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: call.ChatID(), Type: "private"},
+		Text:      call.Params.Get("text"),
+		Caption:   call.Params.Get("caption"),
+	}
+	for _, data := range call.Files {
+		s.attachLocked(&msg, strings.TrimPrefix(strings.ToLower(call.Method), "send"), data)
+	}
+	return msg
+}
+
+// attachLocked stores an uploaded file so it can be downloaded again and
+// sets it on msg as a photo or document.
+func (s *Server) attachLocked(msg *tgbotapi.Message, kind string, data []byte) {
+	s.nextFile++
+	fileID := fmt.Sprintf("sent-%d", s.nextFile)
+	s.files[fileID] = data
+	switch kind {
+	case "photo":
+		msg.Photo = []tgbotapi.PhotoSize{{FileID: fileID, FileUniqueID: "u-" + fileID}}
+	case "document":
+		msg.Document = &tgbotapi.Document{FileID: fileID, FileUniqueID: "u-" + fileID}
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, path string) {
+	fileID := strings.TrimPrefix(path, "files/")
+	s.mu.Lock()
+	data, ok := s.files[fileID]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	_, _ = w.Write(data)
+}
+
+func parseCall(method string, r *http.Request) (Call, error) {
+	call := Call{Method: method, Params: url.Values{}, Files: map[string][]byte{}}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return call, err
+		}
+		for k, v := range r.MultipartForm.Value {
+			call.Params[k] = v
+		}
+		for field, headers := range r.MultipartForm.File {
+			f, err := headers[0].Open()
+			if err != nil {
+				return call, err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return call, err
+			}
+			call.Files[field] = data
+		}
+		return call, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return call, err
+	}
+	call.Params = r.PostForm
+	return call, nil
+}
+
+func writeFailure(w http.ResponseWriter, f Failure) {
+	body := map[string]any{"ok": false, "error_code": f.Code, "description": f.Description}
+	if f.RetryAfter > 0 {
+		body["parameters"] = map[string]any{"retry_after": f.RetryAfter}
+	}
+	writeJSON(w, f.Code, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package telegramtest
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"pro-banana-ai-bot/internal/telegram"
+)
+
+var nextUpdate atomic.Int64
+
+// Text builds a private-chat message update; text starting with "/" is
+// marked as a bot command.
+func Text(userID int64, text string) telegram.Update {
+	msg := message(userID)
+	msg.Text = text
+	if strings.HasPrefix(text, "/") {
+		cmd, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+	}
+	return telegram.Update{UpdateID: int(nextUpdate.Add(1)), Message: msg}
+}
+
+// Photo builds a private-chat photo update; register the bytes with
+// Server.AddFile under fileID so the handler can download them.
+func Photo(userID int64, fileID string, caption string) telegram.Update {
+	msg := message(userID)
+	msg.Photo = []tgbotapi.PhotoSize{{FileID: fileID, FileUniqueID: "u-" + fileID, Width: 1024, Height: 1024}}
+	msg.Caption = caption
+	return telegram.Update{UpdateID: int(nextUpdate.Add(1)), Message: msg}
+}
+
+// Callback builds a button press on messageID, as if sent from the
+// keyboard of a message the bot sent to userID.
+func Callback(userID int64, messageID int, data string) telegram.Update {
+	id := nextUpdate.Add(1)
+	return telegram.Update{
+		UpdateID: int(id),
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   "cb-" + strconv.FormatInt(id, 10),
+			From: user(userID),
+			Message: &tgbotapi.Message{
+				MessageID: messageID,
+				Date:      int(time.Now().Unix()),
+				Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+			},
+			Data: data,
+		},
+	}
+}
+
+func message(userID int64) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: int(nextUpdate.Add(1)),
+		From:      user(userID),
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+	}
+}
+
+func user(userID int64) *tgbotapi.User {
+	return &tgbotapi.User{ID: userID, FirstName: "User", UserName: "user" + strconv.FormatInt(userID, 10)}
+}
+
+// PreCheckout builds the query Telegram sends before charging userID for an
+// invoice with payload.
+func PreCheckout(userID int64, payload string, stars int) telegram.Update {
+	id := nextUpdate.Add(1)
+	return telegram.Update{
+		UpdateID: int(id),
+		PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{
+			ID:             "pc-" + strconv.FormatInt(id, 10),
+			From:           user(userID),
+			Currency:       "XTR",
+			TotalAmount:    stars,
+			InvoicePayload: payload,
+		},
+	}
+}
+
+// Payment builds the service message Telegram sends once the invoice with
+// payload has been paid.
+func Payment(userID int64, payload string, stars int, chargeID string) telegram.Update {
+	msg := message(userID)
+	msg.SuccessfulPayment = &tgbotapi.SuccessfulPayment{
+		Currency:                "XTR",
+		TotalAmount:             stars,
+		InvoicePayload:          payload,
+		TelegramPaymentChargeID: chargeID,
+	}
+	return telegram.Update{UpdateID: int(nextUpdate.Add(1)), Message: msg}
+}
@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type WebhookOptions struct {
+	// URL is the public HTTPS address Telegram posts to; its path is served
+	// on ListenAddr, usually behind a TLS-terminating reverse proxy.
+	URL        string
+	ListenAddr string
+	// SecretToken is checked on every request; empty generates one per run.
+	SecretToken    string
+	MaxConnections int
+	// Buffer is how many updates may wait for the consumer. When it is full
+	// the request fails and Telegram redelivers the update later.
+	Buffer int
+}
+
+// ListenWebhook registers the webhook and serves it until ctx is done, then
+// answers new deliveries with 503, stops the server, deletes the webhook and
+// closes the channel. Updates already acknowledged are still in the channel
+// at that point, so the consumer must read it until it is closed; the rest
+// stay with Telegram for the next start.
+func (c *Client) ListenWebhook(ctx context.Context, opts WebhookOptions) (<-chan Update, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("webhook url %q is invalid", opts.URL)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	secret := opts.SecretToken
+	if secret == "" {
+		if secret, err = randomSecret(); err != nil {
+			return nil, err
+		}
+	}
+	buffer := opts.Buffer
+	if buffer < 1 {
+		buffer = 100
+	}
+
+	updates := make(chan Update, buffer)
+	var closing atomic.Bool
+	mux := http.NewServeMux()
+	mux.Handle(path, c.webhookHandler(secret, updates, &closing))
+	srv := &http.Server{Addr: opts.ListenAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+		close(errc)
+	}()
+
+	params := tgbotapi.Params{}
+	params.AddNonEmpty("url", opts.URL)
+	params.AddNonEmpty("secret_token", secret)
+	params.AddNonZero("max_connections", opts.MaxConnections)
+	if _, err := c.bot.MakeRequest("setWebhook", params); err != nil {
+		_ = srv.Close()
+		return nil, fmt.Errorf("set webhook: %w", err)
+	}
+	c.logger.Info("webhook registered", "url", opts.URL, "listen", opts.ListenAddr)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case err := <-errc:
+			if err != nil {
+				c.logger.Error("webhook server failed", "err", err)
+			}
+		}
+
+		closing.Store(true)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		if err := c.DeleteWebhook(); err != nil {
+			c.logger.Error("delete webhook failed", "err", err)
+		}
+		close(updates)
+	}()
+	return updates, nil
+}
+
+// DeleteWebhook switches the bot back to getUpdates; polling fails while a
+// webhook is set.
+func (c *Client) DeleteWebhook() error {
+	_, err := c.bot.Request(tgbotapi.DeleteWebhookConfig{})
+	return err
+}
+
+// webhookHandler acknowledges as soon as the update is queued; handling
+// happens on the consumer side of the channel. Once closing is set it
+// refuses updates so Telegram redelivers them after the restart.
+func (c *Client) webhookHandler(secret string, updates chan<- Update, closing *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			c.logger.Warn("webhook request with bad secret", "remote", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&update); err != nil {
+			c.logger.Warn("webhook update decode failed", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if closing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		default:
+			c.logger.Warn("webhook buffer full, asking telegram to retry", "update_id", update.UpdateID)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func randomSecret() (string, error) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,158 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+const invitesBucket = "invites"
+
+// codeAlphabet skips look-alike characters; codes are matched case-insensitively.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	ErrInviteInvalid = errors.New("users: invite code not found or revoked")
+	ErrInviteUsedUp  = errors.New("users: invite code has no uses left")
+)
+
+type Invite struct {
+	Code      string
+	MaxUses   int
+	Uses      int
+	Note      string `json:",omitempty"`
+	CreatedBy int64
+	CreatedAt time.Time
+	Revoked   bool
+	UsedBy    []int64
+}
+
+func (i Invite) Remaining() int {
+	return max(i.MaxUses-i.Uses, 0)
+}
+
+// Invites keeps invite codes in the embedded database.
+type Invites struct {
+	mu sync.Mutex
+	db *storage.DB
+}
+
+func NewInvites(db *storage.DB) (*Invites, error) {
+	if _, err := db.Bucket(invitesBucket); err != nil {
+		return nil, err
+	}
+	return &Invites{db: db}, nil
+}
+
+// Create issues a new code usable maxUses times.
+func (s *Invites) Create(createdBy int64, maxUses int, note string) (Invite, error) {
+	if maxUses < 1 {
+		maxUses = 1
+	}
+	code, err := newCode(8)
+	if err != nil {
+		return Invite{}, err
+	}
+	inv := Invite{Code: code, MaxUses: maxUses, Note: note, CreatedBy: createdBy, CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.db.Update(func(tx *storage.Tx) error {
+		return tx.Put(invitesBucket, code, inv)
+	})
+	return inv, err
+}
+
+// Redeem spends one use of code for userID. Redeeming a code the user
+// already used succeeds without spending another use.
+func (s *Invites) Redeem(code string, userID int64) (Invite, error) {
+	code = normalizeCode(code)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var inv Invite
+	err := s.db.Update(func(tx *storage.Tx) error {
+		ok, err := tx.Get(invitesBucket, code, &inv)
+		if err != nil {
+			return err
+		}
+		if !ok || inv.Revoked {
+			return ErrInviteInvalid
+		}
+		for _, id := range inv.UsedBy {
+			if id == userID {
+				return nil
+			}
+		}
+		if inv.Remaining() == 0 {
+			return ErrInviteUsedUp
+		}
+		inv.Uses++
+		inv.UsedBy = append(inv.UsedBy, userID)
+		return tx.Put(invitesBucket, code, inv)
+	})
+	return inv, err
+}
+
+func (s *Invites) Revoke(code string) (Invite, error) {
+	code = normalizeCode(code)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var inv Invite
+	err := s.db.Update(func(tx *storage.Tx) error {
+		ok, err := tx.Get(invitesBucket, code, &inv)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInviteInvalid
+		}
+		inv.Revoked = true
+		return tx.Put(invitesBucket, code, inv)
+	})
+	return inv, err
+}
+
+// Active lists codes that can still be redeemed, newest first.
+func (s *Invites) Active() ([]Invite, error) {
+	var out []Invite
+	err := s.db.View(func(tx *storage.Tx) error {
+		return tx.Scan(invitesBucket, "", func(key string, raw []byte) error {
+			var inv Invite
+			if err := json.Unmarshal(raw, &inv); err != nil {
+				return fmt.Errorf("decode invite %s: %w", key, err)
+			}
+			if !inv.Revoked && inv.Remaining() > 0 {
+				out = append(out, inv)
+			}
+			return nil
+		})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, err
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func newCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("invite code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,309 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pro-banana-ai-bot/internal/storage"
+)
+
+const bucketName = "users"
+
+// maxRecent is how many activities a profile keeps for /user.
+const maxRecent = 10
+
+const (
+	ResultOK       = "ok"
+	ResultFailed   = "failed"
+	ResultCanceled = "canceled"
+	ResultTimeout  = "timeout"
+)
+
+// Activity is one finished generation job.
+type Activity struct {
+	At     time.Time
+	Kind   string
+	Result string
+}
+
+type User struct {
+	ID          int64
+	Username    string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Updates     int
+	Generations int
+	Failures    int
+	Banned      bool
+	BanReason   string `json:",omitempty"`
+	// Allowed is set when the user redeemed an invite code.
+	Allowed    bool
+	InviteCode string `json:",omitempty"`
+	// SendAsFiles delivers generated images as documents (original quality)
+	// instead of compressed photos.
+	SendAsFiles bool `json:",omitempty"`
+	Recent      []Activity
+}
+
+type Stats struct {
+	Total       int
+	Active24h   int
+	Active7d    int
+	Banned      int
+	Generations int
+	Failures    int
+}
+
+type Options struct {
+	// DB persists profiles; nil keeps them in memory only.
+	DB     *storage.DB
+	Logger *slog.Logger
+}
+
+// Store tracks everyone who talked to the bot. Profiles live in memory and
+// are written back by Flush; bans are written immediately.
+type Store struct {
+	mu     sync.Mutex
+	users  map[int64]*User
+	dirty  map[int64]bool
+	bucket *storage.Bucket
+	logger *slog.Logger
+}
+
+func NewStore(opts Options) (*Store, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	s := &Store{
+		users:  make(map[int64]*User),
+		dirty:  make(map[int64]bool),
+		logger: logger,
+	}
+	if opts.DB == nil {
+		return s, nil
+	}
+
+	bucket, err := opts.DB.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	s.bucket = bucket
+	err = bucket.ForEach(func(key string, raw []byte) error {
+		var u User
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return fmt.Errorf("decode user %s: %w", key, err)
+		}
+		s.users[u.ID] = &u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seen records an incoming update from the user.
+func (s *Store) Seen(userID int64, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	u := s.getLocked(userID, now)
+	u.LastSeen = now
+	u.Updates++
+	if username != "" {
+		u.Username = username
+	}
+	s.dirty[userID] = true
+}
+
+// Record appends a finished generation to the user's activity.
+func (s *Store) Record(userID int64, kind, result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	u := s.getLocked(userID, now)
+	switch result {
+	case ResultOK:
+		u.Generations++
+	case ResultFailed, ResultTimeout:
+		u.Failures++
+	}
+	u.Recent = append(u.Recent, Activity{At: now, Kind: kind, Result: result})
+	if len(u.Recent) > maxRecent {
+		u.Recent = append([]Activity(nil), u.Recent[len(u.Recent)-maxRecent:]...)
+	}
+	s.dirty[userID] = true
+}
+
+func (s *Store) SetBanned(userID int64, banned bool, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.getLocked(userID, time.Now().UTC())
+	u.Banned = banned
+	u.BanReason = ""
+	if banned {
+		u.BanReason = reason
+	}
+	return s.saveLocked(u)
+}
+
+// SetAllowed grants access through an invite code.
+func (s *Store) SetAllowed(userID int64, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.getLocked(userID, time.Now().UTC())
+	u.Allowed = true
+	u.InviteCode = code
+	return s.saveLocked(u)
+}
+
+func (s *Store) SetSendAsFiles(userID int64, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.getLocked(userID, time.Now().UTC())
+	u.SendAsFiles = on
+	return s.saveLocked(u)
+}
+
+func (s *Store) SendsAsFiles(userID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	return ok && u.SendAsFiles
+}
+
+func (s *Store) IsAllowed(userID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	return ok && u.Allowed
+}
+
+func (s *Store) IsBanned(userID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	return ok && u.Banned
+}
+
+func (s *Store) Get(userID int64) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return User{}, false
+	}
+	return clone(u), true
+}
+
+// FindByUsername matches case-insensitively, with or without the leading @.
+func (s *Store) FindByUsername(username string) (User, bool) {
+	username = strings.TrimPrefix(username, "@")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.Username, username) {
+			return clone(u), true
+		}
+	}
+	return User{}, false
+}
+
+// Recipients lists users that are not banned, oldest first.
+func (s *Store) Recipients() []int64 {
+	s.mu.Lock()
+	ids := make([]int64, 0, len(s.users))
+	first := make(map[int64]time.Time, len(s.users))
+	for id, u := range s.users {
+		if !u.Banned {
+			ids = append(ids, id)
+			first[id] = u.FirstSeen
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return first[ids[i]].Before(first[ids[j]]) })
+	return ids
+}
+
+func (s *Store) Stats(now time.Time) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st Stats
+	for _, u := range s.users {
+		st.Total++
+		if now.Sub(u.LastSeen) <= 24*time.Hour {
+			st.Active24h++
+		}
+		if now.Sub(u.LastSeen) <= 7*24*time.Hour {
+			st.Active7d++
+		}
+		if u.Banned {
+			st.Banned++
+		}
+		st.Generations += u.Generations
+		st.Failures += u.Failures
+	}
+	return st
+}
+
+// Flush writes profiles changed since the last flush.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.dirty {
+		if err := s.saveLocked(s.users[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) getLocked(userID int64, now time.Time) *User {
+	u, ok := s.users[userID]
+	if !ok {
+		u = &User{ID: userID, FirstSeen: now, LastSeen: now}
+		s.users[userID] = u
+	}
+	return u
+}
+
+func (s *Store) saveLocked(u *User) error {
+	delete(s.dirty, u.ID)
+	if s.bucket == nil {
+		return nil
+	}
+	if err := s.bucket.Put(strconv.FormatInt(u.ID, 10), u); err != nil {
+		s.dirty[u.ID] = true
+		return err
+	}
+	return nil
+}
+
+func clone(u *User) User {
+	out := *u
+	out.Recent = append([]Activity(nil), u.Recent...)
+	return out
+}